@@ -0,0 +1,177 @@
+package backup
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/halverneus/example/config"
+	"github.com/halverneus/example/database"
+	"github.com/halverneus/example/lib/web"
+	"github.com/halverneus/example/model"
+	"github.com/julienschmidt/httprouter"
+)
+
+// TestBackup through /api/backup, restoring the archive afterwards.
+func TestBackup(t *testing.T) {
+	// Load the database and keep contents in memory at their paths, with the
+	// trash enabled. Delete everything on completion.
+	if err := database.Load("json", "backup.db"); nil != err {
+		t.Fatalf("While loading database: %v\n", err)
+	}
+	defer os.Remove("backup.db")
+	defer os.Remove("backup.db.journal")
+
+	config.Get.Database.Filename = "backup.db"
+	config.Get.Storage.Driver = "memory"
+	config.Get.Trash.Enabled = true
+	config.Get.Backup.Admins = []string{"admin"}
+	defer func() {
+		config.Get.Database.Filename = "example.db"
+		config.Get.Storage.Driver = "filesystem"
+		config.Get.Trash.Enabled = false
+		config.Get.Backup.Admins = nil
+	}()
+	if err := model.Load(); nil != err {
+		t.Fatalf("While loading model: %v\n", err)
+	}
+
+	// Setup routes to API calls and start server.
+	router := httprouter.New()
+	router.GET("/api/backup", web.Wrap(GET))
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	// download a backup as the user to the archive, expecting the status.
+	download := func(user, query, archive string, status int) {
+		req, err := http.NewRequest("GET", server.URL+"/api/backup"+query, nil)
+		if nil != err {
+			t.Fatalf("Failed to create request with: %v\n", err)
+		}
+		req.SetBasicAuth(user, "password")
+		resp, err := http.DefaultClient.Do(req)
+		if nil != err {
+			t.Fatalf("Failed to receive response with: %v\n", err)
+		}
+		defer resp.Body.Close()
+		raw, err := ioutil.ReadAll(resp.Body)
+		if nil != err {
+			t.Fatalf("Failed to read response with: %v\n", err)
+		}
+		if status != resp.StatusCode {
+			t.Fatalf("Expected status %d and got %d (%s)\n", status, resp.StatusCode, raw)
+		}
+		if "" != archive {
+			if err = ioutil.WriteFile(archive, raw, 0666); nil != err {
+				t.Fatalf("Failed to write %s with: %v\n", archive, err)
+			}
+		}
+	}
+
+	// upload contents to the path.
+	upload := func(filePath, contents string) {
+		meta := &model.FileMetadata{Path: filePath, Uploader: "admin"}
+		if err := model.File.Upload(meta, strings.NewReader(contents)); nil != err {
+			t.Fatalf("Failed to upload %s with: %v\n", filePath, err)
+		}
+	}
+
+	// expect contents at the path.
+	expect := func(filePath, contents string) {
+		err := model.File.Download(filePath, nil, func(meta *model.FileMetadata, content io.ReadSeeker) error {
+			raw, err := ioutil.ReadAll(content)
+			if nil == err && contents != string(raw) {
+				t.Errorf("Expected %q at %s and got %q\n", contents, filePath, raw)
+			}
+			return err
+		})
+		if nil != err {
+			t.Errorf("Failed to download %s with: %v\n", filePath, err)
+		}
+	}
+
+	// Back up current files and the trash.
+	upload("/a.txt", "one")
+	upload("/b/c.txt", "two")
+	upload("/gone.txt", "three")
+	if err := model.File.Delete("/gone.txt", "admin"); nil != err {
+		t.Fatalf("Failed to delete /gone.txt with: %v\n", err)
+	}
+
+	// Only admins may back up.
+	download("john", "", "", http.StatusForbidden)
+
+	// Plain and compressed archives verify, listing the database and every
+	// object.
+	for _, archive := range []string{"backup.tar", "backup.tar.zst"} {
+		query := ""
+		if strings.HasSuffix(archive, ".zst") {
+			query = "?" + ZstdQuery
+		}
+		download("admin", query, archive, http.StatusOK)
+		defer os.Remove(archive)
+		manifest, err := model.Backup.Verify(archive)
+		if nil != err {
+			t.Fatalf("Failed to verify %s with: %v\n", archive, err)
+		}
+		if 4 != len(manifest.Members) {
+			t.Errorf("Expected 4 members in %s and got %+v\n", archive, manifest.Members)
+		}
+	}
+	if raw, _ := ioutil.ReadFile("backup.tar.zst"); !bytes.HasPrefix(raw, []byte{0x28, 0xb5, 0x2f, 0xfd}) {
+		t.Error("Expected a zstd compressed archive")
+	}
+
+	// Corrupt and incomplete archives are refused before anything changes.
+	raw, err := ioutil.ReadFile("backup.tar")
+	if nil != err {
+		t.Fatalf("Failed to read backup.tar with: %v\n", err)
+	}
+	defer os.Remove("corrupt.tar")
+	for _, corrupt := range [][]byte{
+		bytes.Replace(raw, []byte("three"), []byte("thr3e"), 1),
+		raw[:len(raw)/2],
+	} {
+		if err = ioutil.WriteFile("corrupt.tar", corrupt, 0666); nil != err {
+			t.Fatalf("Failed to write corrupt.tar with: %v\n", err)
+		}
+		if _, err = model.Backup.Verify("corrupt.tar"); nil == err {
+			t.Error("Expected corrupt archive to fail verification")
+		}
+		if _, err = model.Backup.Restore("corrupt.tar"); nil == err {
+			t.Error("Expected corrupt archive to fail restoring")
+		}
+	}
+
+	// Restoring into empty storage brings back the files and the trash as
+	// they were, replacing changes made since.
+	upload("/later.txt", "four")
+	if err = model.Load(); nil != err {
+		t.Fatalf("While reloading model: %v\n", err)
+	}
+	backup, err := model.Backup.Restore("backup.tar.zst")
+	if nil != err {
+		t.Fatalf("Failed to restore with: %v\n", err)
+	}
+	if "" == backup {
+		t.Error("Expected the replaced database to be kept")
+	}
+	defer os.Remove(backup)
+	defer os.Remove(backup + ".journal")
+	if err = database.Load("json", "backup.db"); nil != err {
+		t.Fatalf("While loading restored database: %v\n", err)
+	}
+	expect("/a.txt", "one")
+	expect("/b/c.txt", "two")
+	if _, err = model.File.Metadata("/later.txt"); nil == err {
+		t.Error("Expected file uploaded after the backup to be gone")
+	}
+	if items := model.Trash.List(); 1 != len(items) || "/gone.txt" != items[0].File.Path {
+		t.Errorf("Expected /gone.txt in the trash and got %+v\n", items)
+	}
+}
@@ -0,0 +1,16 @@
+package backup
+
+import (
+	"net/http"
+
+	"github.com/halverneus/example/model"
+)
+
+// errorStatus for an error returned by the model.
+func errorStatus(err error) int {
+	switch err {
+	case model.ErrNotAdmin:
+		return http.StatusForbidden
+	}
+	return http.StatusInternalServerError
+}
@@ -0,0 +1,47 @@
+package backup
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/halverneus/example/lib/web"
+	"github.com/halverneus/example/model"
+)
+
+const (
+	// ZstdQuery compresses the archive with zstd (ex: "?zstd").
+	ZstdQuery = "zstd"
+
+	// ContentDisposition names the archive when saved.
+	ContentDisposition = "Content-Disposition"
+)
+
+// GetRequest is just a URL call to "/api/latest/backup", streaming a tar
+// archive of the database and every stored object as they were when the
+// request arrived, followed by a manifest of their checksums. Compressed with
+// zstd when asked for with "?zstd". Credentials of a backup admin required. An
+// archive cut short by an error is missing its manifest and fails to verify.
+
+// GET a backup of the server.
+func GET(ctx *web.Context) {
+	if !model.Backup.Allowed(ctx.User) {
+		err := model.ErrNotAdmin
+		ctx.Respond().Status(errorStatus(err)).With(err).Do()
+		return
+	}
+
+	_, compress := ctx.R.URL.Query()[ZstdQuery]
+	name := fmt.Sprintf("example-%s.tar", time.Now().UTC().Format("20060102T150405"))
+	if compress {
+		name += ".zst"
+	}
+	w := ctx.Respond().
+		Add(web.ContentType, "application/x-tar").
+		Add(ContentDisposition, fmt.Sprintf("attachment; filename=%q", name)).
+		Stream()
+	if err := model.Backup.Write(w, compress); nil != err {
+		ctx.Logf("Received error while writing backup: %v\n", err)
+		return
+	}
+	ctx.Debugln("Request completed")
+}
@@ -0,0 +1,113 @@
+package file
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/halverneus/example/config"
+	"github.com/halverneus/example/database"
+	"github.com/halverneus/example/model"
+	"github.com/halverneus/example/storage"
+)
+
+// TestDeduplicate identical contents into one blob shared by every file until
+// the last one is deleted.
+func TestDeduplicate(t *testing.T) {
+	const contents = "same contents"
+
+	// Load the database and keep contents in memory, storing identical contents
+	// once. Delete everything on completion.
+	if err := database.Load("json", "dedup.db"); nil != err {
+		t.Fatalf("While loading database: %v\n", err)
+	}
+	defer os.Remove("dedup.db")
+	defer os.Remove("dedup.db.journal")
+
+	config.Get.Storage.Driver = "memory"
+	config.Get.Storage.Deduplicate = true
+	defer func() {
+		config.Get.Storage.Driver = "filesystem"
+		config.Get.Storage.Deduplicate = false
+	}()
+	if err := model.Load(); nil != err {
+		t.Fatalf("While loading model: %v\n", err)
+	}
+
+	// upload the contents to the path.
+	upload := func(filePath string) {
+		meta := &model.FileMetadata{Path: filePath, Uploader: "john"}
+		if err := model.File.Upload(meta, strings.NewReader(contents)); nil != err {
+			t.Fatalf("Failed to upload %s with: %v\n", filePath, err)
+		}
+	}
+
+	// expect the contents at the path.
+	expect := func(filePath string) {
+		err := model.File.Download(filePath, nil, func(meta *model.FileMetadata, content io.ReadSeeker) error {
+			raw, err := ioutil.ReadAll(content)
+			if nil == err && contents != string(raw) {
+				t.Errorf("Expected %q at %s and got %q\n", contents, filePath, raw)
+			}
+			return err
+		})
+		if nil != err {
+			t.Errorf("Failed to download %s with: %v\n", filePath, err)
+		}
+	}
+
+	// blobs kept in storage.
+	blobs := func() (keys []string) {
+		model.File.Storage.List("/.blobs/", func(info *storage.Info) error {
+			keys = append(keys, info.Key)
+			return nil
+		})
+		return
+	}
+
+	// Both files share a single blob named by the digest.
+	upload("/a.txt")
+	upload("/b.txt")
+	digest := sha256.Sum256([]byte(contents))
+	name := hex.EncodeToString(digest[:])
+	key := path.Join("/.blobs", name[:2], name[2:4], name)
+	if stored := blobs(); 1 != len(stored) || key != stored[0] {
+		t.Fatalf("Expected the single blob %s and got %v\n", key, stored)
+	}
+	if references := database.References(key); 2 != references {
+		t.Errorf("Expected 2 references to the blob and got %d\n", references)
+	}
+
+	// Deleting one file keeps the blob for the other.
+	if err := model.File.Delete("/a.txt", "john"); nil != err {
+		t.Fatalf("Failed to delete /a.txt with: %v\n", err)
+	}
+	if references := database.References(key); 1 != references {
+		t.Errorf("Expected 1 reference to the blob and got %d\n", references)
+	}
+	expect("/b.txt")
+
+	// Deleting the last file releases the blob for deletion. Earlier deletions
+	// still waiting in the queue, including that of /a.txt, are passed over.
+	if err := model.File.Delete("/b.txt", "john"); nil != err {
+		t.Fatalf("Failed to delete /b.txt with: %v\n", err)
+	}
+	if references := database.References(key); 0 != references {
+		t.Errorf("Expected no references to the blob and got %d\n", references)
+	}
+	timeout := time.After(5 * time.Second)
+	for released := false; !released; {
+		select {
+		case f := <-database.FileDeletionChan:
+			released = "/b.txt" == f.Path && key == f.StorageKey()
+		case <-timeout:
+			t.Fatal("Expected the blob to be released for deletion")
+		}
+	}
+}
@@ -10,18 +10,31 @@ import (
 // DeleteRequest is just a URL call. File exists at the path specified in the
 // URL. For example, to delete a file called "my/folder/file.json", one would
 // delete the file from the following endpoint:
-// "/api/latest/file/my/folder/file.json". Credentials required.
+// "/api/latest/file/my/folder/file.json". Credentials required. Versioned
+// files leave a delete marker, while "?version=ID" deletes a version for good
+// (see version.go). Other files go to the trash when enabled (see api/trash).
 
 // DeleteResponse returns nothing.
 type DeleteResponse struct{}
 
 // DELETE file from storage.
 func DELETE(ctx *web.Context) {
+	if id, multipart := uploadID(ctx); multipart {
+		abortMultipart(ctx, id)
+		return
+	}
 	filePath := ctx.PS.ByName("filepath")
 
-	// Delete file.
-	if err := model.File.Delete(filePath); nil != err {
-		ctx.Respond().Status(http.StatusNotFound).With(err).Do()
+	// Delete file, or a version of it for good when one is given.
+	var err error
+	if versionID := ctx.R.URL.Query().Get(VersionQuery); "" != versionID {
+		err = model.File.DeleteVersion(filePath, versionID)
+	} else {
+		err = model.File.Delete(filePath, ctx.User)
+	}
+	if nil != err {
+		ctx.Respond().Status(errorStatus(err, http.StatusNotFound)).With(err).Do()
+		return
 	}
 
 	// Reply with success.
@@ -0,0 +1,24 @@
+package file
+
+import (
+	"net/http"
+
+	"github.com/halverneus/example/model"
+)
+
+// errorStatus for an error returned by the model. Errors caused by the request
+// are a bad request, exceeded quotas are insufficient storage, and locked files
+// and users who aren't retention admins are forbidden. Anything else gets the fallback status.
+func errorStatus(err error, fallback int) int {
+	switch err.(type) {
+	case *model.KeyError, *model.ChecksumError, *model.RetentionError:
+		return http.StatusBadRequest
+	case *model.QuotaError:
+		return http.StatusInsufficientStorage
+	}
+	switch err {
+	case model.ErrLocked, model.ErrRetentionShortened, model.ErrNotRetentionAdmin:
+		return http.StatusForbidden
+	}
+	return fallback
+}
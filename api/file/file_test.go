@@ -0,0 +1,290 @@
+package file
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/halverneus/example/config"
+	"github.com/halverneus/example/database"
+	"github.com/halverneus/example/lib/encrypt"
+	"github.com/halverneus/example/lib/web"
+	"github.com/halverneus/example/model"
+	"github.com/halverneus/example/storage"
+	"github.com/julienschmidt/httprouter"
+	"gopkg.in/yaml.v2"
+)
+
+// TestAll methods for /api/file.
+func TestAll(t *testing.T) {
+	const (
+		contents = "hello, world"
+		sha256   = "09ca7e4eaa6e8ae9c7d261167129184883644d07dfba7cbfbc4c8a2e08360d5b"
+		md5B64   = "5NfxtO0uQtFYmPSyewGdpA=="
+	)
+
+	// All test cases to be performed, in order.
+	testCases := []struct {
+		name    string
+		method  string
+		path    string
+		body    string
+		headers map[string]string
+		status  int
+		result  string
+	}{
+		{"Get missing file", "GET", "/a/b.txt", "", nil, http.StatusNotFound, "*"},
+		{"Put file", "PUT", "/a/b.txt", contents, map[string]string{ChecksumSHA256: sha256, ContentMD5: md5B64}, http.StatusOK, "{}"},
+		{"Get file", "GET", "/a/b.txt", "", nil, http.StatusOK, contents},
+		{"Get range", "GET", "/a/b.txt", "", map[string]string{"Range": "bytes=7-"}, http.StatusPartialContent, "world"},
+		{"Get stale If-Range", "GET", "/a/b.txt", "", map[string]string{"Range": "bytes=7-", "If-Range": `"stale"`}, http.StatusOK, contents},
+		{"Get unsatisfiable range", "GET", "/a/b.txt", "", map[string]string{"Range": "bytes=100-"}, http.StatusRequestedRangeNotSatisfiable, "*"},
+		{"Head file", "HEAD", "/a/b.txt", "", nil, http.StatusOK, ""},
+		{"Put bad MD5", "PUT", "/a/b.txt", "altered", map[string]string{ContentMD5: md5B64}, http.StatusBadRequest, "*"},
+		{"Put bad SHA-256", "PUT", "/a/b.txt", "altered", map[string]string{ChecksumSHA256: sha256}, http.StatusBadRequest, "*"},
+		{"Put malformed checksum", "PUT", "/a/b.txt", contents, map[string]string{ChecksumSHA256: "abc"}, http.StatusBadRequest, "*"},
+		{"Get file after rejected puts", "GET", "/a/b.txt", "", nil, http.StatusOK, contents},
+		{"Get equivalent path", "GET", "/a//./b.txt", "", nil, http.StatusOK, contents},
+		{"Put path traversal", "PUT", "/a/../b.txt", contents, nil, http.StatusBadRequest, "*"},
+		{"Put control character", "PUT", "/a/%01b.txt", contents, nil, http.StatusBadRequest, "*"},
+		{"Put reserved path", "PUT", "/.blobs/b.txt", contents, nil, http.StatusBadRequest, "*"},
+		{"Put long name", "PUT", "/" + strings.Repeat("b", 256), contents, nil, http.StatusBadRequest, "*"},
+		{"Get path traversal", "GET", "/a/../a/b.txt", "", nil, http.StatusBadRequest, "*"},
+		{"Put decomposed name", "PUT", "/cafe%CC%81.txt", contents, nil, http.StatusOK, "{}"},
+		{"Get composed name", "GET", "/caf%C3%A9.txt", "", nil, http.StatusOK, contents},
+		{"Delete composed name", "DELETE", "/caf%C3%A9.txt", "", nil, http.StatusOK, "{}"},
+		{"Put over quota", "PUT", "/big.txt", strings.Repeat("b", 65), nil, http.StatusInsufficientStorage, "*"},
+		{"Put over quota streamed", "PUT", "/big.txt", strings.Repeat("b", 65), map[string]string{"Transfer-Encoding": "chunked"}, http.StatusInsufficientStorage, "*"},
+		{"Get upload over quota", "GET", "/big.txt", "", nil, http.StatusNotFound, "*"},
+		{"Put compressed file", "PUT", "/logs/b.txt", contents, nil, http.StatusOK, "{}"},
+		{"Get decompressed file", "GET", "/logs/b.txt", "", nil, http.StatusOK, contents},
+		{"Get decompressed range", "GET", "/logs/b.txt", "", map[string]string{"Range": "bytes=7-"}, http.StatusPartialContent, "world"},
+		{"Get compressed file", "GET", "/logs/b.txt", "", map[string]string{AcceptEncoding: "gzip, zstd"}, http.StatusOK, "*"},
+		{"Delete compressed file", "DELETE", "/logs/b.txt", "", nil, http.StatusOK, "{}"},
+		{"Delete file", "DELETE", "/a/b.txt", "", nil, http.StatusOK, "{}"},
+		{"Delete missing file", "DELETE", "/a/b.txt", "", nil, http.StatusNotFound, "*"},
+	}
+
+	// Load the database and keep contents in memory. Delete database on
+	// completion.
+	if err := database.Load("json", "example.db"); nil != err {
+		t.Errorf("While loading database: %v\n", err)
+		return
+	}
+	defer os.Remove("example.db")
+	defer os.Remove("example.db.journal")
+
+	// Encrypt everything at rest with a new master key.
+	const keyFile = "example.key"
+	key, err := encrypt.NewKey()
+	if nil != err {
+		t.Fatalf("While creating master key: %v\n", err)
+	}
+	if err = encrypt.SaveKeyFile(keyFile, key); nil != err {
+		t.Fatalf("While saving master key: %v\n", err)
+	}
+	defer os.Remove(keyFile)
+
+	// Keep contents in memory at random IDs, compress everything under "/logs/"
+	// at rest and limit storage to 64 bytes.
+	settings := "storage:\n" +
+		"  driver: memory\n" +
+		"  layout: hashed\n" +
+		"  encryption:\n    key_file: " + keyFile + "\n" +
+		"  compression:\n    - codec: zstd\n      prefixes: [/logs/]\n" +
+		"quota:\n  global:\n    max_bytes: 64\n"
+	if err = yaml.Unmarshal([]byte(settings), &config.Get); nil != err {
+		t.Fatalf("While assigning storage settings: %v\n", err)
+	}
+	defer func() {
+		config.Get.Storage.Driver = "filesystem"
+		config.Get.Storage.Layout = "path"
+		config.Get.Storage.Encryption.KeyFile = ""
+		config.Get.Storage.Compression = nil
+		config.Get.Quota.Global.MaxBytes = 0
+	}()
+	if err = model.Load(); nil != err {
+		t.Fatalf("While loading model: %v\n", err)
+	}
+
+	// Setup routes to API calls.
+	router := httprouter.New()
+	router.DELETE("/api/file/*filepath", web.Wrap(DELETE))
+	router.GET("/api/file/*filepath", web.Wrap(GET))
+	router.HEAD("/api/file/*filepath", web.Wrap(GET))
+	router.PUT("/api/file/*filepath", web.Wrap(PUT))
+
+	// Start server.
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	// client is used for making requests. Responses are left as sent.
+	client := &http.Client{Transport: &http.Transport{DisableCompression: true}}
+
+	// Run all test cases.
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req, err := http.NewRequest(tc.method, server.URL+"/api/file"+tc.path, strings.NewReader(tc.body))
+			if nil != err {
+				t.Fatalf("Failed to create request with: %v\n", err)
+			}
+			for k, v := range tc.headers {
+				req.Header.Set(k, v)
+			}
+
+			// Stream without announcing the length.
+			if "chunked" == req.Header.Get("Transfer-Encoding") {
+				req.Header.Del("Transfer-Encoding")
+				req.ContentLength = -1
+				req.Body = ioutil.NopCloser(strings.NewReader(tc.body))
+			}
+
+			// Perform request, not forgetting to close the response body.
+			var resp *http.Response
+			if resp, err = client.Do(req); nil != err {
+				t.Fatalf("Failed to receive response with: %v\n", err)
+			}
+			defer resp.Body.Close()
+
+			var rawResp []byte
+			if rawResp, err = ioutil.ReadAll(resp.Body); nil != err {
+				t.Fatalf("Failed to read response with: %v\n", err)
+			}
+
+			// Compare contents ('*' is wild) and status codes.
+			if "*" != tc.result && string(rawResp) != tc.result {
+				t.Fatalf("Response mismatch. Expected %s and got %s\n", tc.result, string(rawResp))
+			}
+			if tc.status != resp.StatusCode {
+				t.Fatalf(
+					"Status code mismatch. Expected %s and got %s (%s)\n",
+					http.StatusText(tc.status),
+					http.StatusText(resp.StatusCode),
+					string(rawResp),
+				)
+			}
+
+			// Successful uploads and downloads identify the contents, including
+			// how they are encoded.
+			if http.StatusOK == resp.StatusCode && "DELETE" != tc.method {
+				expected := `"` + sha256 + `"`
+				if encoding := resp.Header.Get(ContentEncoding); "" != encoding {
+					expected = `"` + sha256 + "-" + encoding + `"`
+					if "zstd" != encoding || contents == string(rawResp) {
+						t.Errorf("Expected zstd contents and got %s: %q\n", encoding, rawResp)
+					}
+				}
+				if etag := resp.Header.Get(ETag); expected != etag {
+					t.Errorf("ETag mismatch. Expected %s and got %s\n", expected, etag)
+				}
+			}
+		})
+	}
+}
+
+// failingBackend stages uploads that fail to commit.
+type failingBackend struct {
+	storage.Backend
+}
+
+// Put the contents in an upload that fails to commit.
+func (b *failingBackend) Put(r io.Reader) (storage.Upload, error) {
+	upload, err := b.Backend.Put(r)
+	if nil != err {
+		return nil, err
+	}
+	return &failingUpload{upload}, nil
+}
+
+// failingUpload is discarded on commit.
+type failingUpload struct {
+	storage.Upload
+}
+
+// Commit fails after discarding the contents.
+func (u *failingUpload) Commit(key string) error {
+	u.Abort()
+	return errors.New("storage is unavailable")
+}
+
+// TestCommitFailure leaves files as they were when the contents of an upload
+// can't be moved into place.
+func TestCommitFailure(t *testing.T) {
+	// Load the database and keep contents in memory at random IDs, versioning
+	// everything under "/docs/". Delete everything on completion.
+	if err := database.Load("json", "commit.db"); nil != err {
+		t.Fatalf("While loading database: %v\n", err)
+	}
+	defer os.Remove("commit.db")
+	defer os.Remove("commit.db.journal")
+
+	config.Get.Storage.Driver = "memory"
+	config.Get.Storage.Layout = "hashed"
+	config.Get.Versioning.Prefixes = []string{"/docs/"}
+	defer func() {
+		config.Get.Storage.Driver = "filesystem"
+		config.Get.Storage.Layout = "path"
+		config.Get.Versioning.Prefixes = nil
+	}()
+	if err := model.Load(); nil != err {
+		t.Fatalf("While loading model: %v\n", err)
+	}
+
+	// upload contents to the path, expecting success or failure.
+	upload := func(filePath, contents string, succeed bool) {
+		meta := &model.FileMetadata{Path: filePath, Uploader: "john"}
+		if err := model.File.Upload(meta, strings.NewReader(contents)); succeed != (nil == err) {
+			t.Fatalf("Upload of %s expected to succeed (%v) and got: %v\n", filePath, succeed, err)
+		}
+	}
+
+	// expect contents at the path.
+	expect := func(filePath, contents string) {
+		err := model.File.Download(filePath, nil, func(meta *model.FileMetadata, content io.ReadSeeker) error {
+			raw, err := ioutil.ReadAll(content)
+			if nil == err && contents != string(raw) {
+				t.Errorf("Expected %q at %s and got %q\n", contents, filePath, raw)
+			}
+			return err
+		})
+		if nil != err {
+			t.Errorf("Failed to download %s with: %v\n", filePath, err)
+		}
+	}
+
+	upload("/a.txt", "one", true)
+	upload("/docs/b.txt", "one", true)
+	healthy := model.File.Storage
+	model.File.Storage = &failingBackend{healthy}
+
+	// New files are not added.
+	upload("/new.txt", "one", false)
+	if _, err := model.File.Metadata("/new.txt"); nil == err {
+		t.Error("Expected file failing to commit to be missing")
+	}
+
+	// Replaced files and versions are brought back.
+	upload("/a.txt", "two", false)
+	upload("/docs/b.txt", "two", false)
+	expect("/a.txt", "one")
+	expect("/docs/b.txt", "one")
+	if versions, err := model.File.Versions("/docs/b.txt"); nil != err || 1 != len(versions) {
+		t.Errorf("Expected a single version of /docs/b.txt and got %d (%v)\n", len(versions), err)
+	}
+
+	// The restored files survive a reload.
+	model.File.Storage = healthy
+	if err := database.Load("json", "commit.db"); nil != err {
+		t.Fatalf("While reloading database: %v\n", err)
+	}
+	expect("/a.txt", "one")
+	expect("/docs/b.txt", "one")
+	if _, err := model.File.Metadata("/new.txt"); nil == err {
+		t.Error("Expected file failing to commit to be missing after reloading")
+	}
+}
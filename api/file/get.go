@@ -1,7 +1,10 @@
 package file
 
 import (
+	"io"
 	"net/http"
+	"strconv"
+	"strings"
 
 	"github.com/halverneus/example/lib/web"
 	"github.com/halverneus/example/model"
@@ -10,25 +13,79 @@ import (
 // GetRequest is a file stream. File is loaded from a path specified in the URL.
 // For example, to download a file called "my/folder/file.json", one would
 // stream the file from the following endpoint:
-// "/api/latest/file/my/folder/file.json". Credentials required.
+// "/api/latest/file/my/folder/file.json". Credentials required. Partial
+// downloads are supported with the "Range" and "If-Range" headers, and a HEAD
+// request returns the headers alone. Files compressed at rest are sent
+// compressed when "Accept-Encoding" allows for it. Past versions are
+// downloaded with "?version=ID" (see version.go), and "?list" lists the files
+// under the path instead (see list.go). Retention and legal holds are
+// reported in headers (see retention.go), as is the storage tier.
 
 // GET file from storage.
 func GET(ctx *web.Context) {
+	if id, multipart := uploadID(ctx); multipart {
+		listParts(ctx, id)
+		return
+	}
+	query := ctx.R.URL.Query()
+	if _, versions := query[VersionsQuery]; versions {
+		listVersions(ctx)
+		return
+	}
+	if _, list := query[ListQuery]; list {
+		listFiles(ctx)
+		return
+	}
 	filePath := ctx.PS.ByName("filepath")
 
-	// Retrieve metadata for sending headers.
-	metadata, err := model.File.Metadata(filePath)
+	// The contents are served while the model holds the file, which prevents
+	// callers from risking a deadlock.
+	encodings := acceptedEncodings(ctx.R.Header.Get(AcceptEncoding))
+	versionID := query.Get(VersionQuery)
+	err := model.File.DownloadVersion(filePath, versionID, encodings, func(meta *model.FileMetadata, content io.ReadSeeker) error {
+		// Assign headers. Length, ranges and validators are handled when serving.
+		resp := ctx.Respond().
+			Add(web.ContentType, meta.ContentType).
+			Add(Vary, AcceptEncoding).
+			Add(VersionID, meta.VersionID).
+			Add(StorageTier, meta.Tier)
+		if etag := meta.ETag(); "" != etag {
+			resp.Add(ETag, etag)
+		}
+		if "" != meta.Encoding {
+			resp.Add(ContentEncoding, meta.Encoding)
+		}
+		lockHeaders(resp, meta)
+		resp.Serve(meta.Modified, content)
+		return nil
+	})
 	if nil != err {
-		ctx.Respond().Status(http.StatusNotFound).With(err).Do()
-		return
+		ctx.Respond().Status(errorStatus(err, http.StatusNotFound)).With(err).Do()
 	}
+}
 
-	// Assign headers and retrieve writer.
-	writer := ctx.Respond().Add(web.ContentType, metadata.ContentType).Stream()
+// acceptedEncodings from an "Accept-Encoding" header, leaving out any refused
+// with a zero quality value.
+func acceptedEncodings(header string) (encodings []string) {
+	for _, part := range strings.Split(header, ",") {
+		fields := strings.Split(part, ";")
+		name := strings.ToLower(strings.TrimSpace(fields[0]))
+		if "" == name {
+			continue
+		}
 
-	// The writer is passed in to prevent callers from risking a deadlock.
-	if _, err = model.File.Download(filePath, writer); nil != err {
-		ctx.Logf("Error while downloading: %v\n", err)
+		// Look for "q=0", "q=0.0" and so on.
+		refused := false
+		for _, param := range fields[1:] {
+			kv := strings.SplitN(strings.TrimSpace(param), "=", 2)
+			if 2 == len(kv) && "q" == strings.ToLower(kv[0]) {
+				q, err := strconv.ParseFloat(kv[1], 64)
+				refused = nil == err && 0 == q
+			}
+		}
+		if !refused {
+			encodings = append(encodings, name)
+		}
 	}
 	return
 }
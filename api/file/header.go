@@ -0,0 +1,54 @@
+package file
+
+const (
+	// ContentMD5 header holds the Base64 encoded MD5 of the contents.
+	ContentMD5 = "Content-MD5"
+	// ChecksumSHA256 header holds the hex (or Base64) encoded SHA-256 of the
+	// contents.
+	ChecksumSHA256 = "X-Checksum-SHA256"
+	// ETag header identifies the contents of a file.
+	ETag = "ETag"
+	// AcceptEncoding header lists the content codings a client accepts.
+	AcceptEncoding = "Accept-Encoding"
+	// ContentEncoding header names the content coding of a response.
+	ContentEncoding = "Content-Encoding"
+	// Vary header lists request headers that changed the response.
+	Vary = "Vary"
+	// VersionID header identifies the version of a file.
+	VersionID = "X-Version-Id"
+	// RetentionMode header holds the retention mode of a file.
+	RetentionMode = "X-Retention-Mode"
+	// RetainUntil header holds the time until which a file is retained.
+	RetainUntil = "X-Retain-Until"
+	// LegalHold header is "on" while a file is under legal hold.
+	LegalHold = "X-Legal-Hold"
+	// StorageTier header names the tier holding a file, "hot" or "cold".
+	StorageTier = "X-Storage-Tier"
+
+	// UploadsQuery parameter initiates a multipart upload.
+	UploadsQuery = "uploads"
+	// UploadIDQuery parameter names the multipart upload a request belongs to.
+	UploadIDQuery = "uploadId"
+	// PartNumberQuery parameter numbers the part being uploaded.
+	PartNumberQuery = "partNumber"
+
+	// VersionsQuery parameter lists the versions of a file.
+	VersionsQuery = "versions"
+	// VersionQuery parameter selects a version of a file.
+	VersionQuery = "version"
+	// RestoreQuery parameter restores a version of a file.
+	RestoreQuery = "restore"
+	// RetentionQuery parameter sets the retention of a file.
+	RetentionQuery = "retention"
+	// LegalHoldQuery parameter sets the legal hold of a file.
+	LegalHoldQuery = "legal-hold"
+
+	// ListQuery parameter lists the files under the path as a prefix.
+	ListQuery = "list"
+	// DelimiterQuery parameter rolls paths up into common prefixes.
+	DelimiterQuery = "delimiter"
+	// StartAfterQuery parameter continues a listing after a path.
+	StartAfterQuery = "start-after"
+	// MaxKeysQuery parameter limits the number of entries listed.
+	MaxKeysQuery = "max-keys"
+)
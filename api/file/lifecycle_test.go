@@ -0,0 +1,118 @@
+package file
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/halverneus/example/config"
+	"github.com/halverneus/example/database"
+	"github.com/halverneus/example/lib/web"
+	"github.com/halverneus/example/model"
+	"github.com/julienschmidt/httprouter"
+	"gopkg.in/yaml.v2"
+)
+
+// TestLifecycle expiration of files uploaded through /api/file.
+func TestLifecycle(t *testing.T) {
+	// Load the database and keep contents in memory. Delete everything on
+	// completion.
+	if err := database.Load("json", "lifecycle.db"); nil != err {
+		t.Fatalf("While loading database: %v\n", err)
+	}
+	defer os.Remove("lifecycle.db")
+	defer os.Remove("lifecycle.db.journal")
+
+	config.Get.Storage.Driver = "memory"
+	defer func() {
+		config.Get.Storage.Driver = "filesystem"
+		config.Get.Lifecycle = nil
+	}()
+
+	// Rules need a positive minimum age.
+	if err := yamlLifecycle(`[{prefix: /tmp/}]`); nil != err {
+		t.Fatalf("Failed to parse lifecycle rules with: %v\n", err)
+	}
+	if err := model.Load(); nil == err {
+		t.Error("Expected a rule without a minimum age to be refused")
+	}
+
+	// Expire anything under "/builds/", and JSON under "/exports/".
+	if err := yamlLifecycle(`[
+		{prefix: /builds/, min_age: 1h},
+		{prefix: /exports/, min_age: 1h, content_types: [application/json]},
+	]`); nil != err {
+		t.Fatalf("Failed to parse lifecycle rules with: %v\n", err)
+	}
+	if err := model.Load(); nil != err {
+		t.Fatalf("While loading model: %v\n", err)
+	}
+
+	// Setup routes to API calls and start server.
+	router := httprouter.New()
+	router.GET("/api/file/*filepath", web.Wrap(GET))
+	router.PUT("/api/file/*filepath", web.Wrap(PUT))
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	// do a request to the path, expecting the status.
+	do := func(method, path, contentType string, status int) {
+		req, err := http.NewRequest(method, server.URL+"/api/file"+path, strings.NewReader("contents"))
+		if nil != err {
+			t.Fatalf("Failed to create request with: %v\n", err)
+		}
+		req.Header.Set(web.ContentType, contentType)
+		resp, err := http.DefaultClient.Do(req)
+		if nil != err {
+			t.Fatalf("Failed to receive response with: %v\n", err)
+		}
+		resp.Body.Close()
+		if status != resp.StatusCode {
+			t.Errorf("%s %s: expected status %d and got %d\n", method, path, status, resp.StatusCode)
+		}
+	}
+
+	// expire files, expecting the paths removed.
+	expire := func(paths ...string) {
+		removed, err := model.Lifecycle.Expire()
+		if nil != err {
+			t.Fatalf("Failed to expire files with: %v\n", err)
+		}
+		if len(paths) != len(removed) {
+			t.Fatalf("Expected %d files removed and got %d\n", len(paths), len(removed))
+		}
+		for i, meta := range removed {
+			if paths[i] != meta.Path {
+				t.Errorf("Expected %s removed and got %s\n", paths[i], meta.Path)
+			}
+		}
+	}
+
+	do("PUT", "/builds/app.bin", "application/octet-stream", http.StatusOK)
+	do("PUT", "/exports/data.json", "application/json; charset=utf-8", http.StatusOK)
+	do("PUT", "/exports/data.csv", "text/csv", http.StatusOK)
+	do("PUT", "/keep.txt", "text/plain", http.StatusOK)
+
+	// Nothing is old enough yet.
+	expire()
+
+	// Matching files expire once old enough, and are gone.
+	for i := range config.Get.Lifecycle {
+		config.Get.Lifecycle[i].MinAge = time.Nanosecond
+	}
+	expire("/builds/app.bin", "/exports/data.json")
+	do("GET", "/builds/app.bin", "", http.StatusNotFound)
+	do("GET", "/exports/data.json", "", http.StatusNotFound)
+	do("GET", "/exports/data.csv", "", http.StatusOK)
+	do("GET", "/keep.txt", "", http.StatusOK)
+	expire()
+}
+
+// yamlLifecycle rules replacing those configured.
+func yamlLifecycle(rules string) error {
+	config.Get.Lifecycle = nil
+	return yaml.Unmarshal([]byte("lifecycle: "+rules), &config.Get)
+}
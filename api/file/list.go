@@ -0,0 +1,81 @@
+package file
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/halverneus/example/lib/web"
+	"github.com/halverneus/example/model"
+)
+
+// Listing the files under a path prefix, in order, is a GET on the prefix with
+// "?list" (ex: "/api/latest/file/docs/?list"). Credentials required. Paths
+// holding the "delimiter" after the prefix are rolled up into common prefixes
+// (ex: "&delimiter=/" lists a folder). Up to "max-keys" files and prefixes are
+// listed (default and limit 1000), and truncated listings continue with
+// "&start-after=" set to the "next-start-after" returned.
+
+// ListResponse of the files and common prefixes under a prefix.
+type ListResponse struct {
+	Prefix         string      `json:"prefix"`
+	Delimiter      string      `json:"delimiter,omitempty"`
+	StartAfter     string      `json:"start-after,omitempty"`
+	Files          []*ListItem `json:"files"`
+	Prefixes       []string    `json:"common-prefixes"`
+	Truncated      bool        `json:"truncated"`
+	NextStartAfter string      `json:"next-start-after,omitempty"`
+}
+
+// ListItem describes a current file.
+type ListItem struct {
+	Path        string    `json:"path"`
+	ContentType string    `json:"content-type"`
+	Uploader    string    `json:"uploader"`
+	Modified    time.Time `json:"modified"`
+	Size        int64     `json:"size"`
+	ETag        string    `json:"etag,omitempty"`
+	VersionID   string    `json:"version-id"`
+}
+
+// listFiles under the file path as a prefix.
+func listFiles(ctx *web.Context) {
+	query := ctx.R.URL.Query()
+	var max int
+	if value := query.Get(MaxKeysQuery); "" != value {
+		var err error
+		if max, err = strconv.Atoi(value); nil != err || 0 > max {
+			err = fmt.Errorf("invalid %s: %s", MaxKeysQuery, value)
+			ctx.Respond().Status(http.StatusBadRequest).With(err).Do()
+			return
+		}
+	}
+
+	prefix, delimiter, startAfter := ctx.PS.ByName("filepath"), query.Get(DelimiterQuery), query.Get(StartAfterQuery)
+	listing := model.File.List(prefix, delimiter, startAfter, max)
+
+	// Reply with the listing.
+	resp := &ListResponse{
+		Prefix:         prefix,
+		Delimiter:      delimiter,
+		StartAfter:     startAfter,
+		Files:          []*ListItem{},
+		Prefixes:       []string{},
+		Truncated:      listing.Truncated,
+		NextStartAfter: listing.Next,
+	}
+	for _, meta := range listing.Files {
+		resp.Files = append(resp.Files, &ListItem{
+			Path:        meta.Path,
+			ContentType: meta.ContentType,
+			Uploader:    meta.Uploader,
+			Modified:    meta.Modified,
+			Size:        meta.Size,
+			ETag:        meta.ETag(),
+			VersionID:   meta.VersionID,
+		})
+	}
+	resp.Prefixes = append(resp.Prefixes, listing.Prefixes...)
+	ctx.Respond().With(resp).Do()
+}
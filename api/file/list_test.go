@@ -0,0 +1,166 @@
+package file
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/halverneus/example/config"
+	"github.com/halverneus/example/database"
+	"github.com/halverneus/example/lib/web"
+	"github.com/halverneus/example/model"
+	"github.com/julienschmidt/httprouter"
+)
+
+// TestList files under prefixes through /api/file.
+func TestList(t *testing.T) {
+	// Load the database and keep contents in memory at their paths. Delete
+	// everything on completion.
+	if err := database.Load("json", "list.db"); nil != err {
+		t.Fatalf("While loading database: %v\n", err)
+	}
+	defer os.Remove("list.db")
+	defer os.Remove("list.db.journal")
+
+	config.Get.Storage.Driver = "memory"
+	defer func() { config.Get.Storage.Driver = "filesystem" }()
+	if err := model.Load(); nil != err {
+		t.Fatalf("While loading model: %v\n", err)
+	}
+
+	// Setup routes to API calls and start server.
+	router := httprouter.New()
+	router.GET("/api/file/*filepath", web.Wrap(GET))
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	// list the prefix with the query, expecting the status.
+	list := func(prefix, query string, status int) *ListResponse {
+		resp, err := http.Get(server.URL + "/api/file" + prefix + "?list" + query)
+		if nil != err {
+			t.Fatalf("Failed to receive response with: %v\n", err)
+		}
+		defer resp.Body.Close()
+		raw, err := ioutil.ReadAll(resp.Body)
+		if nil != err {
+			t.Fatalf("Failed to read response with: %v\n", err)
+		}
+		if status != resp.StatusCode {
+			t.Fatalf("GET %s?list%s: expected status %d and got %d (%s)\n", prefix, query, status, resp.StatusCode, raw)
+		}
+		listing := &ListResponse{}
+		if http.StatusOK == status {
+			if err = json.Unmarshal(raw, listing); nil != err {
+				t.Fatalf("Failed to parse listing %s with: %v\n", raw, err)
+			}
+		}
+		return listing
+	}
+
+	// paths of the files listed.
+	paths := func(listing *ListResponse) []string {
+		list := []string{}
+		for _, item := range listing.Files {
+			list = append(list, item.Path)
+		}
+		return list
+	}
+
+	// expect the files and common prefixes listed.
+	expect := func(listing *ListResponse, files, prefixes []string, next string) {
+		if !reflect.DeepEqual(files, paths(listing)) || !reflect.DeepEqual(prefixes, listing.Prefixes) {
+			t.Errorf("Expected files %v and prefixes %v, got %v and %v\n", files, prefixes, paths(listing), listing.Prefixes)
+		}
+		if next != listing.NextStartAfter || ("" != next) != listing.Truncated {
+			t.Errorf("Expected to continue after %q and got %q (truncated %v)\n", next, listing.NextStartAfter, listing.Truncated)
+		}
+	}
+
+	// upload contents to the path.
+	upload := func(filePath string) {
+		meta := &model.FileMetadata{Path: filePath, Uploader: "admin"}
+		if err := model.File.Upload(meta, strings.NewReader(filePath)); nil != err {
+			t.Fatalf("Failed to upload %s with: %v\n", filePath, err)
+		}
+	}
+
+	for _, filePath := range []string{
+		"/list/z/y.txt", "/list/docs/f.txt", "/list/a.txt", "/list/docs/c/e.txt",
+		"/list/docsx.txt", "/list/docs/b.txt", "/list/docs/c/d.txt",
+	} {
+		upload(filePath)
+	}
+
+	// Everything under a prefix, in order.
+	expect(list("/list/", "", http.StatusOK), []string{
+		"/list/a.txt", "/list/docs/b.txt", "/list/docs/c/d.txt", "/list/docs/c/e.txt",
+		"/list/docs/f.txt", "/list/docsx.txt", "/list/z/y.txt",
+	}, []string{}, "")
+	expect(list("/list/docs", "", http.StatusOK), []string{
+		"/list/docs/b.txt", "/list/docs/c/d.txt", "/list/docs/c/e.txt", "/list/docs/f.txt", "/list/docsx.txt",
+	}, []string{}, "")
+
+	// A delimiter lists folders.
+	expect(list("/list/", "&delimiter=/", http.StatusOK),
+		[]string{"/list/a.txt", "/list/docsx.txt"}, []string{"/list/docs/", "/list/z/"}, "")
+	expect(list("/list/docs/", "&delimiter=/", http.StatusOK),
+		[]string{"/list/docs/b.txt", "/list/docs/f.txt"}, []string{"/list/docs/c/"}, "")
+
+	// Truncated listings continue after the last file or common prefix.
+	expect(list("/list/", "&delimiter=/&max-keys=2", http.StatusOK),
+		[]string{"/list/a.txt"}, []string{"/list/docs/"}, "/list/docs/")
+	expect(list("/list/", "&delimiter=/&max-keys=2&start-after="+url.QueryEscape("/list/docs/"), http.StatusOK),
+		[]string{"/list/docsx.txt"}, []string{"/list/z/"}, "")
+	expect(list("/list/", "&max-keys=3&start-after="+url.QueryEscape("/list/docs/b.txt"), http.StatusOK),
+		[]string{"/list/docs/c/d.txt", "/list/docs/c/e.txt", "/list/docs/f.txt"}, []string{}, "/list/docs/f.txt")
+	list("/list/", "&max-keys=-1", http.StatusBadRequest)
+
+	// Deleted files are gone from listings.
+	if err := model.File.Delete("/list/docs/c/d.txt", "admin"); nil != err {
+		t.Fatalf("Failed to delete with: %v\n", err)
+	}
+	expect(list("/list/docs/c/", "", http.StatusOK), []string{"/list/docs/c/e.txt"}, []string{}, "")
+
+	// Many files added and removed in any order stay in order.
+	random := rand.New(rand.NewSource(1))
+	remaining := map[string]bool{}
+	for _, i := range random.Perm(300) {
+		filePath := fmt.Sprintf("/many/%03d", i)
+		upload(filePath)
+		remaining[filePath] = true
+	}
+	for _, i := range random.Perm(300)[:250] {
+		filePath := fmt.Sprintf("/many/%03d", i)
+		if err := model.File.Delete(filePath, "admin"); nil != err {
+			t.Fatalf("Failed to delete %s with: %v\n", filePath, err)
+		}
+		delete(remaining, filePath)
+	}
+	expected := []string{}
+	for filePath := range remaining {
+		expected = append(expected, filePath)
+	}
+	sort.Strings(expected)
+
+	listed := []string{}
+	for startAfter, pages := "", 0; ; pages++ {
+		listing := list("/many/", "&max-keys=7&start-after="+url.QueryEscape(startAfter), http.StatusOK)
+		listed = append(listed, paths(listing)...)
+		if !listing.Truncated || 50 < pages {
+			break
+		}
+		startAfter = listing.NextStartAfter
+	}
+	if !reflect.DeepEqual(expected, listed) {
+		t.Errorf("Expected %v and got %v\n", expected, listed)
+	}
+}
@@ -0,0 +1,175 @@
+package file
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/halverneus/example/lib/web"
+	"github.com/halverneus/example/model"
+)
+
+// Large files are uploaded in parts, possibly in parallel, with the following
+// calls on the file path. Credentials required for all of them.
+//     POST   "/api/latest/file/my/file.bin?uploads"                    Initiate.
+//     PUT    "/api/latest/file/my/file.bin?uploadId=ID&partNumber=N"  Upload part N.
+//     GET    "/api/latest/file/my/file.bin?uploadId=ID"               List parts.
+//     POST   "/api/latest/file/my/file.bin?uploadId=ID"               Complete.
+//     DELETE "/api/latest/file/my/file.bin?uploadId=ID"               Abort.
+// Part numbers run from 1 to 10000 and uploading a part again replaces it. Set
+// "Content-MD5" when uploading a part to have it rejected if altered. Uploads
+// going without a new part for longer than "uploads.expiry" are aborted.
+
+// InitiateResponse identifies the new multipart upload.
+type InitiateResponse struct {
+	UploadID string `json:"upload-id"`
+}
+
+// PartResponse returns nothing. The "ETag" header identifies the part.
+type PartResponse struct{}
+
+// ListPartsResponse describes the parts uploaded so far, in order.
+type ListPartsResponse struct {
+	UploadID string          `json:"upload-id"`
+	Path     string          `json:"path"`
+	Parts    []*PartListItem `json:"parts"`
+}
+
+// PartListItem describes a part.
+type PartListItem struct {
+	PartNumber int    `json:"part-number"`
+	ETag       string `json:"etag"`
+	Size       int64  `json:"size"`
+}
+
+// CompleteRequest lists the parts making up the file in order, each with the
+// ETag received when uploading it (ex: {"parts": [{"part-number": 1, "etag":
+// "\"5d41402abc4b2a76b9719d911017c592\""}]}).
+type CompleteRequest struct {
+	Parts []*PartListItem `json:"parts"`
+}
+
+// CompleteResponse returns nothing. The "ETag" header identifies the file.
+type CompleteResponse struct{}
+
+// AbortResponse returns nothing.
+type AbortResponse struct{}
+
+// uploadID of the multipart upload a request refers to, if any.
+func uploadID(ctx *web.Context) (id string, multipart bool) {
+	id = ctx.R.URL.Query().Get(UploadIDQuery)
+	return id, "" != id
+}
+
+// initiateMultipart upload at the file path.
+func initiateMultipart(ctx *web.Context) {
+	u := &model.MultipartUpload{
+		Path:        ctx.PS.ByName("filepath"),
+		ContentType: ctx.R.Header.Get(web.ContentType),
+		Uploader:    ctx.User,
+	}
+	if err := model.Multipart.Initiate(u); nil != err {
+		ctx.Respond().Status(multipartStatus(err)).With(err).Do()
+		return
+	}
+
+	// Reply with the upload ID.
+	resp := &InitiateResponse{UploadID: u.ID}
+	ctx.Respond().With(resp).Do()
+}
+
+// uploadPart of a multipart upload.
+func uploadPart(ctx *web.Context, id string) {
+	number, err := strconv.Atoi(ctx.R.URL.Query().Get(PartNumberQuery))
+	if nil != err {
+		ctx.Respond().Status(http.StatusBadRequest).With(errors.New("part number is required")).Do()
+		return
+	}
+	var md5 string
+	if md5, err = checksumHeader(ctx.R.Header.Get(ContentMD5), 16); nil != err {
+		ctx.Respond().Status(http.StatusBadRequest).With(err).Do()
+		return
+	}
+
+	// Receive the part.
+	var part *model.MultipartPart
+	part, err = model.Multipart.UploadPart(id, ctx.PS.ByName("filepath"), ctx.User, number, ctx.Reader(), md5)
+	if nil != err {
+		ctx.Respond().Status(multipartStatus(err)).With(err).Do()
+		return
+	}
+
+	// Reply with success.
+	resp := &PartResponse{}
+	ctx.Respond().Add(ETag, part.ETag).With(resp).Do()
+}
+
+// listParts of a multipart upload.
+func listParts(ctx *web.Context, id string) {
+	u, parts, err := model.Multipart.ListParts(id, ctx.PS.ByName("filepath"), ctx.User)
+	if nil != err {
+		ctx.Respond().Status(multipartStatus(err)).With(err).Do()
+		return
+	}
+
+	// Reply with the parts.
+	resp := &ListPartsResponse{UploadID: u.ID, Path: u.Path, Parts: []*PartListItem{}}
+	for _, part := range parts {
+		resp.Parts = append(resp.Parts, &PartListItem{
+			PartNumber: part.Number,
+			ETag:       part.ETag,
+			Size:       part.Size,
+		})
+	}
+	ctx.Respond().With(resp).Do()
+}
+
+// completeMultipart upload, turning the listed parts into the file.
+func completeMultipart(ctx *web.Context, id string) {
+	req := &CompleteRequest{}
+	if err := ctx.Decode(req); nil != err {
+		ctx.Respond().Status(http.StatusBadRequest).With(err).Do()
+		return
+	}
+	parts := make([]*model.MultipartPart, len(req.Parts))
+	for i, part := range req.Parts {
+		parts[i] = &model.MultipartPart{Number: part.PartNumber, ETag: part.ETag}
+	}
+
+	meta, err := model.Multipart.Complete(id, ctx.PS.ByName("filepath"), ctx.User, parts)
+	if nil != err {
+		ctx.Respond().Status(multipartStatus(err)).With(err).Do()
+		return
+	}
+
+	// Reply with success.
+	resp := &CompleteResponse{}
+	ctx.Respond().Add(ETag, meta.ETag()).With(resp).Do()
+}
+
+// abortMultipart upload, discarding every part.
+func abortMultipart(ctx *web.Context, id string) {
+	if err := model.Multipart.Abort(id, ctx.PS.ByName("filepath"), ctx.User); nil != err {
+		ctx.Respond().Status(multipartStatus(err)).With(err).Do()
+		return
+	}
+
+	// Reply with success.
+	resp := &AbortResponse{}
+	ctx.Respond().With(resp).Do()
+}
+
+// multipartStatus for an error returned by the multipart model. Unknown uploads
+// are not found and parts that don't add up are a bad request.
+func multipartStatus(err error) int {
+	switch err {
+	case model.ErrUploadNotFound:
+		return http.StatusNotFound
+	case model.ErrNoParts:
+		return http.StatusBadRequest
+	}
+	if _, ok := err.(*model.PartError); ok {
+		return http.StatusBadRequest
+	}
+	return errorStatus(err, http.StatusInternalServerError)
+}
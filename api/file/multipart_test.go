@@ -0,0 +1,181 @@
+package file
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/halverneus/example/config"
+	"github.com/halverneus/example/database"
+	"github.com/halverneus/example/lib/web"
+	"github.com/halverneus/example/model"
+	"github.com/julienschmidt/httprouter"
+)
+
+// TestMultipart uploads through /api/file.
+func TestMultipart(t *testing.T) {
+	const (
+		filePath = "/multipart/file.txt"
+		sha256   = "09ca7e4eaa6e8ae9c7d261167129184883644d07dfba7cbfbc4c8a2e08360d5b"
+	)
+
+	// Load the database and keep contents in memory. Delete everything on
+	// completion.
+	if err := database.Load("json", "multipart.db"); nil != err {
+		t.Fatalf("While loading database: %v\n", err)
+	}
+	defer os.Remove("multipart.db")
+	defer os.Remove("multipart.db.journal")
+
+	const uploadsFolder = "testuploads"
+	config.Get.Storage.Driver = "memory"
+	config.Get.Uploads.Folder = uploadsFolder
+	defer func() {
+		config.Get.Storage.Driver = "filesystem"
+		config.Get.Uploads.Folder = "uploads"
+		config.Get.Uploads.Expiry = 24 * time.Hour
+		os.RemoveAll(uploadsFolder)
+	}()
+	if err := model.Load(); nil != err {
+		t.Fatalf("While loading model: %v\n", err)
+	}
+
+	// Setup routes to API calls and start server.
+	router := httprouter.New()
+	router.DELETE("/api/file/*filepath", web.Wrap(DELETE))
+	router.GET("/api/file/*filepath", web.Wrap(GET))
+	router.POST("/api/file/*filepath", web.Wrap(POST))
+	router.PUT("/api/file/*filepath", web.Wrap(PUT))
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	// do a request to the file path with the query, expecting the status.
+	do := func(method, query, body string, headers map[string]string, status int) (*http.Response, []byte) {
+		req, err := http.NewRequest(method, server.URL+"/api/file"+filePath+query, strings.NewReader(body))
+		if nil != err {
+			t.Fatalf("Failed to create request with: %v\n", err)
+		}
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if nil != err {
+			t.Fatalf("Failed to receive response with: %v\n", err)
+		}
+		defer resp.Body.Close()
+		raw, err := ioutil.ReadAll(resp.Body)
+		if nil != err {
+			t.Fatalf("Failed to read response with: %v\n", err)
+		}
+		if status != resp.StatusCode {
+			t.Errorf("%s %s: expected status %d and got %d (%s)\n", method, query, status, resp.StatusCode, raw)
+		}
+		return resp, raw
+	}
+
+	// initiate an upload, returning its ID.
+	initiate := func() string {
+		_, raw := do("POST", "?uploads", "", map[string]string{web.ContentType: "text/plain"}, http.StatusOK)
+		initiated := &InitiateResponse{}
+		if err := json.Unmarshal(raw, initiated); nil != err || "" == initiated.UploadID {
+			t.Fatalf("Failed to initiate upload: %s (%v)\n", raw, err)
+		}
+		return initiated.UploadID
+	}
+
+	// POST without a query lists every query it accepts.
+	if _, raw := do("POST", "", "", nil, http.StatusBadRequest); !strings.Contains(string(raw), "?legal-hold") {
+		t.Errorf("Expected accepted queries in %s\n", raw)
+	}
+
+	// Upload parts in parallel and out of order.
+	id := initiate()
+	parts := []string{"hello", ", ", "world"}
+	etags := make([]string, len(parts))
+	var wg sync.WaitGroup
+	for i := range parts {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			query := fmt.Sprintf("?uploadId=%s&partNumber=%d", id, i+1)
+			resp, _ := do("PUT", query, parts[i], nil, http.StatusOK)
+			etags[i] = resp.Header.Get(ETag)
+		}(i)
+	}
+	wg.Wait()
+	if `"5d41402abc4b2a76b9719d911017c592"` != etags[0] {
+		t.Errorf("Expected the quoted MD5 as the part ETag and got %s\n", etags[0])
+	}
+
+	// Parts that can't be uploaded.
+	do("PUT", "?uploadId="+id+"&partNumber=0", "x", nil, http.StatusBadRequest)
+	do("PUT", "?uploadId="+id+"&partNumber=10001", "x", nil, http.StatusBadRequest)
+	do("PUT", "?uploadId="+id, "x", nil, http.StatusBadRequest)
+	do("PUT", "?uploadId="+id+"&partNumber=4", "x", map[string]string{ContentMD5: "5d41402abc4b2a76b9719d911017c592"}, http.StatusBadRequest)
+	do("PUT", "?uploadId=0123&partNumber=1", "x", nil, http.StatusNotFound)
+
+	// List the parts in order.
+	_, raw := do("GET", "?uploadId="+id, "", nil, http.StatusOK)
+	list := &ListPartsResponse{}
+	if err := json.Unmarshal(raw, list); nil != err || 3 != len(list.Parts) {
+		t.Fatalf("Expected three parts and got: %s (%v)\n", raw, err)
+	}
+	for i, part := range list.Parts {
+		if i+1 != part.PartNumber || etags[i] != part.ETag || int64(len(parts[i])) != part.Size {
+			t.Errorf("Part %d listed as %+v\n", i+1, part)
+		}
+	}
+
+	// complete the upload with the parts, expecting the status.
+	complete := func(status int, numbers []int, etags []string) *http.Response {
+		req := &CompleteRequest{}
+		for i, number := range numbers {
+			req.Parts = append(req.Parts, &PartListItem{PartNumber: number, ETag: etags[i]})
+		}
+		body, _ := json.Marshal(req)
+		resp, _ := do("POST", "?uploadId="+id, string(body), nil, status)
+		return resp
+	}
+	complete(http.StatusBadRequest, nil, nil)
+	complete(http.StatusBadRequest, []int{2, 1, 3}, []string{etags[1], etags[0], etags[2]})
+	complete(http.StatusBadRequest, []int{1, 2, 3}, []string{etags[0], etags[0], etags[2]})
+	complete(http.StatusBadRequest, []int{1, 2, 4}, etags)
+	resp := complete(http.StatusOK, []int{1, 2, 3}, etags)
+	if expected := `"` + sha256 + `"`; expected != resp.Header.Get(ETag) {
+		t.Errorf("Expected ETag %s and got %s\n", expected, resp.Header.Get(ETag))
+	}
+
+	// The parts are now a file, and the upload is gone.
+	resp, raw = do("GET", "", "", nil, http.StatusOK)
+	if "hello, world" != string(raw) || "text/plain" != resp.Header.Get(web.ContentType) {
+		t.Errorf("Unexpected file contents %q of type %s\n", raw, resp.Header.Get(web.ContentType))
+	}
+	do("GET", "?uploadId="+id, "", nil, http.StatusNotFound)
+
+	// Aborted uploads are gone.
+	id = initiate()
+	do("PUT", "?uploadId="+id+"&partNumber=1", "abandoned", nil, http.StatusOK)
+	do("DELETE", "?uploadId="+id, "", nil, http.StatusOK)
+	do("GET", "?uploadId="+id, "", nil, http.StatusNotFound)
+
+	// Idle uploads are swept once expired.
+	id = initiate()
+	if count, err := model.Multipart.Sweep(); nil != err || 0 != count {
+		t.Errorf("Expected nothing to sweep and got %d with: %v\n", count, err)
+	}
+	config.Get.Uploads.Expiry = time.Nanosecond
+	if count, err := model.Multipart.Sweep(); nil != err || 1 != count {
+		t.Errorf("Expected one upload swept and got %d with: %v\n", count, err)
+	}
+	do("GET", "?uploadId="+id, "", nil, http.StatusNotFound)
+
+	// Remove the completed file.
+	do("DELETE", "", "", nil, http.StatusOK)
+}
@@ -0,0 +1,49 @@
+package file
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/halverneus/example/lib/web"
+)
+
+// PostRequest is just a URL call, either initiating a multipart upload with
+// "?uploads", completing one with "?uploadId=ID" (see multipart.go), restoring
+// a past version with "?restore&version=ID" (see version.go) or setting the
+// "?retention" or "?legal-hold" of a file (see retention.go). Credentials
+// required.
+
+// postQueries handled by POST, in order of precedence.
+var postQueries = []struct {
+	query  string
+	handle func(ctx *web.Context)
+}{
+	{RetentionQuery, setRetention},
+	{LegalHoldQuery, setLegalHold},
+	{RestoreQuery, restoreVersion},
+	{UploadsQuery, initiateMultipart},
+	{UploadIDQuery, func(ctx *web.Context) {
+		id, _ := uploadID(ctx)
+		completeMultipart(ctx, id)
+	}},
+}
+
+// POST to initiate or complete a multipart upload, restore a version or lock a
+// file.
+func POST(ctx *web.Context) {
+	query := ctx.R.URL.Query()
+	expected := make([]string, len(postQueries))
+	for i, q := range postQueries {
+		if _, found := query[q.query]; found {
+			q.handle(ctx)
+			return
+		}
+		expected[i] = "?" + q.query
+	}
+	last := len(expected) - 1
+	ctx.Respond().
+		Status(http.StatusBadRequest).
+		With(fmt.Errorf("expected one of %s or %s", strings.Join(expected[:last], ", "), expected[last])).
+		Do()
+}
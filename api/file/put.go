@@ -1,6 +1,9 @@
 package file
 
 import (
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
 	"net/http"
 
 	"github.com/halverneus/example/lib/web"
@@ -11,13 +14,20 @@ import (
 // For example, to save a file as "my/folder/file.json", one would set the
 // "Content-Type" header to "application/json" and stream the file to the
 // following endpoint: "/api/latest/file/my/folder/file.json". Credentials
-// required.
+// required. Optionally, set the "Content-MD5" and/or "X-Checksum-SHA256"
+// headers to have the upload rejected if the contents arrive altered. Paths are
+// normalized, so "my//folder/./file.json" is the same file, while paths with
+// "..", control characters or overlong names are rejected.
 
 // PutResponse returns nothing.
 type PutResponse struct{}
 
 // PUT file into storage.
 func PUT(ctx *web.Context) {
+	if id, multipart := uploadID(ctx); multipart {
+		uploadPart(ctx, id)
+		return
+	}
 	filePath := ctx.PS.ByName("filepath")
 
 	// Collect metadata information about the file.
@@ -25,14 +35,47 @@ func PUT(ctx *web.Context) {
 		Path:        filePath,
 		ContentType: ctx.R.Header.Get(web.ContentType),
 		Uploader:    ctx.User,
+		Size:        ctx.R.ContentLength,
+	}
+
+	// Collect checksums the contents are expected to match.
+	var err error
+	if meta.MD5, err = checksumHeader(ctx.R.Header.Get(ContentMD5), 16); nil != err {
+		ctx.Respond().Status(http.StatusBadRequest).With(err).Do()
+		return
+	}
+	if meta.SHA256, err = checksumHeader(ctx.R.Header.Get(ChecksumSHA256), 32); nil != err {
+		ctx.Respond().Status(http.StatusBadRequest).With(err).Do()
+		return
 	}
 
 	// Upload the file with the metadata.
-	if err := model.File.Upload(meta, ctx.Reader()); nil != err {
-		ctx.Respond().Status(http.StatusTeapot).With(err).Do()
+	if err = model.File.Upload(meta, ctx.Reader()); nil != err {
+		ctx.Respond().Status(errorStatus(err, http.StatusTeapot)).With(err).Do()
+		return
 	}
 
 	// Reply with success.
 	resp := &PutResponse{}
-	ctx.Respond().With(resp).Do()
+	ctx.Respond().Add(ETag, meta.ETag()).Add(VersionID, meta.VersionID).With(resp).Do()
+}
+
+// checksumHeader decodes a hex or Base64 encoded digest of the expected size
+// into lowercase hex. An empty header returns an empty checksum.
+func checksumHeader(value string, size int) (checksum string, err error) {
+	if "" == value {
+		return
+	}
+
+	// Accept hex first, as it is unambiguous at the expected length.
+	if raw, errX := hex.DecodeString(value); nil == errX && size == len(raw) {
+		checksum = hex.EncodeToString(raw)
+		return
+	}
+	if raw, errX := base64.StdEncoding.DecodeString(value); nil == errX && size == len(raw) {
+		checksum = hex.EncodeToString(raw)
+		return
+	}
+	err = fmt.Errorf("checksum %q is not a hex or Base64 encoded %d byte digest", value, size)
+	return
 }
@@ -0,0 +1,87 @@
+package file
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/halverneus/example/lib/web"
+	"github.com/halverneus/example/model"
+)
+
+// Files are kept from being replaced or deleted with the following calls on the
+// file path, which apply to the current version unless "&version=ID" is given.
+// Credentials required.
+//     POST "/api/latest/file/my/file.bin?retention"   Set retention.
+//     POST "/api/latest/file/my/file.bin?legal-hold"  Set or release a legal hold.
+// Retention in effect can only be extended, except by an admin listed in
+// "retention.admins" in governance mode. Downloads report the retention and
+// legal hold in the "X-Retention-Mode", "X-Retain-Until" and "X-Legal-Hold"
+// headers.
+
+// RetentionRequest sets the mode ("governance" or "compliance") and the time
+// until which the file is kept (ex: {"mode": "compliance", "retain-until":
+// "2030-01-01T00:00:00Z"}). Admins remove governance retention with an empty
+// mode and time.
+type RetentionRequest struct {
+	Mode        string    `json:"mode"`
+	RetainUntil time.Time `json:"retain-until"`
+}
+
+// RetentionResponse returns nothing.
+type RetentionResponse struct{}
+
+// LegalHoldRequest places or releases a legal hold (ex: {"legal-hold": true}).
+type LegalHoldRequest struct {
+	LegalHold bool `json:"legal-hold"`
+}
+
+// LegalHoldResponse returns nothing.
+type LegalHoldResponse struct{}
+
+// setRetention of a file version.
+func setRetention(ctx *web.Context) {
+	req := &RetentionRequest{}
+	if err := ctx.Decode(req); nil != err {
+		ctx.Respond().Status(http.StatusBadRequest).With(err).Do()
+		return
+	}
+
+	filePath, versionID := ctx.PS.ByName("filepath"), ctx.R.URL.Query().Get(VersionQuery)
+	if err := model.File.SetRetention(filePath, versionID, req.Mode, req.RetainUntil, ctx.User); nil != err {
+		ctx.Respond().Status(errorStatus(err, http.StatusNotFound)).With(err).Do()
+		return
+	}
+
+	// Reply with success.
+	resp := &RetentionResponse{}
+	ctx.Respond().With(resp).Do()
+}
+
+// setLegalHold on a file version.
+func setLegalHold(ctx *web.Context) {
+	req := &LegalHoldRequest{}
+	if err := ctx.Decode(req); nil != err {
+		ctx.Respond().Status(http.StatusBadRequest).With(err).Do()
+		return
+	}
+
+	filePath, versionID := ctx.PS.ByName("filepath"), ctx.R.URL.Query().Get(VersionQuery)
+	if err := model.File.SetLegalHold(filePath, versionID, req.LegalHold, ctx.User); nil != err {
+		ctx.Respond().Status(errorStatus(err, http.StatusNotFound)).With(err).Do()
+		return
+	}
+
+	// Reply with success.
+	resp := &LegalHoldResponse{}
+	ctx.Respond().With(resp).Do()
+}
+
+// lockHeaders reporting the retention and legal hold of a file, if any.
+func lockHeaders(resp *web.Response, meta *model.FileMetadata) {
+	if "" != meta.RetentionMode {
+		resp.Add(RetentionMode, meta.RetentionMode).Add(RetainUntil, meta.RetainUntil.Format(time.RFC3339))
+	}
+	if meta.LegalHold {
+		resp.Add(LegalHold, "on")
+	}
+}
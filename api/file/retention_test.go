@@ -0,0 +1,131 @@
+package file
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/halverneus/example/config"
+	"github.com/halverneus/example/database"
+	"github.com/halverneus/example/lib/web"
+	"github.com/halverneus/example/model"
+	"github.com/julienschmidt/httprouter"
+	"gopkg.in/yaml.v2"
+)
+
+// TestRetention and legal holds of files through /api/file.
+func TestRetention(t *testing.T) {
+	// Load the database and keep contents in memory, retaining everything under
+	// "/records/" in compliance mode. Delete everything on completion.
+	if err := database.Load("json", "retention.db"); nil != err {
+		t.Fatalf("While loading database: %v\n", err)
+	}
+	defer os.Remove("retention.db")
+	defer os.Remove("retention.db.journal")
+
+	config.Get.Storage.Driver = "memory"
+	defer func() {
+		config.Get.Storage.Driver = "filesystem"
+		config.Get.Retention.Admins = nil
+		config.Get.Retention.Rules = nil
+	}()
+	rules := `{admins: [admin], rules: [{prefix: /records/, mode: compliance, period: 1h}]}`
+	if err := yaml.Unmarshal([]byte("retention: "+rules), &config.Get); nil != err {
+		t.Fatalf("Failed to parse retention rules with: %v\n", err)
+	}
+	if err := model.Load(); nil != err {
+		t.Fatalf("While loading model: %v\n", err)
+	}
+
+	// Setup routes to API calls and start server.
+	router := httprouter.New()
+	router.DELETE("/api/file/*filepath", web.Wrap(DELETE))
+	router.GET("/api/file/*filepath", web.Wrap(GET))
+	router.POST("/api/file/*filepath", web.Wrap(POST))
+	router.PUT("/api/file/*filepath", web.Wrap(PUT))
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	// do a request to the path as the user, expecting the status.
+	do := func(user, method, path, body string, status int) *http.Response {
+		req, err := http.NewRequest(method, server.URL+"/api/file"+path, strings.NewReader(body))
+		if nil != err {
+			t.Fatalf("Failed to create request with: %v\n", err)
+		}
+		req.SetBasicAuth(user, "12345678")
+		resp, err := http.DefaultClient.Do(req)
+		if nil != err {
+			t.Fatalf("Failed to receive response with: %v\n", err)
+		}
+		defer resp.Body.Close()
+		raw, err := ioutil.ReadAll(resp.Body)
+		if nil != err {
+			t.Fatalf("Failed to read response with: %v\n", err)
+		}
+		if status != resp.StatusCode {
+			t.Errorf("%s %s: expected status %d and got %d (%s)\n", method, path, status, resp.StatusCode, raw)
+		}
+		return resp
+	}
+
+	// retain the file at the path until the time.
+	retain := func(user, path, mode string, until time.Time, status int) {
+		body := fmt.Sprintf(`{"mode": %q, "retain-until": %q}`, mode, until.Format(time.RFC3339))
+		if until.IsZero() {
+			body = `{}`
+		}
+		do(user, "POST", path+"?retention", body, status)
+	}
+
+	// Uploads under a retention rule are retained in compliance mode, which
+	// can't be shortened, even by an admin.
+	do("john", "PUT", "/records/a.txt", "one", http.StatusOK)
+	resp := do("john", "GET", "/records/a.txt", "", http.StatusOK)
+	until, err := time.Parse(time.RFC3339, resp.Header.Get(RetainUntil))
+	if "compliance" != resp.Header.Get(RetentionMode) || nil != err || until.Before(time.Now().Add(59*time.Minute)) {
+		t.Errorf("Unexpected retention %s until %s\n", resp.Header.Get(RetentionMode), resp.Header.Get(RetainUntil))
+	}
+	do("john", "PUT", "/records/a.txt", "two", http.StatusForbidden)
+	do("john", "DELETE", "/records/a.txt", "", http.StatusForbidden)
+	retain("admin", "/records/a.txt", "compliance", time.Now().Add(time.Minute), http.StatusForbidden)
+	retain("admin", "/records/a.txt", "governance", until.Add(time.Hour), http.StatusForbidden)
+	retain("admin", "/records/a.txt", "", time.Time{}, http.StatusForbidden)
+	retain("john", "/records/a.txt", "compliance", until.Add(time.Hour), http.StatusOK)
+
+	// Retention settings that make no sense.
+	do("john", "PUT", "/b.txt", "one", http.StatusOK)
+	retain("john", "/b.txt", "forever", time.Now().Add(time.Hour), http.StatusBadRequest)
+	retain("john", "/b.txt", "governance", time.Now().Add(-time.Hour), http.StatusBadRequest)
+	retain("john", "/missing.txt", "governance", time.Now().Add(time.Hour), http.StatusNotFound)
+
+	// Governance mode can only be shortened or removed by an admin.
+	retain("john", "/b.txt", "governance", time.Now().Add(time.Hour), http.StatusOK)
+	do("john", "DELETE", "/b.txt", "", http.StatusForbidden)
+	retain("john", "/b.txt", "governance", time.Now().Add(time.Minute), http.StatusForbidden)
+	retain("john", "/b.txt", "", time.Time{}, http.StatusForbidden)
+	retain("admin", "/b.txt", "", time.Time{}, http.StatusOK)
+	if resp := do("john", "GET", "/b.txt", "", http.StatusOK); "" != resp.Header.Get(RetentionMode) {
+		t.Errorf("Expected retention removed and got %s\n", resp.Header.Get(RetentionMode))
+	}
+
+	// A legal hold placed by an admin keeps the file until an admin releases it.
+	do("john", "POST", "/b.txt?legal-hold", `{"legal-hold": true}`, http.StatusForbidden)
+	do("admin", "POST", "/b.txt?legal-hold", `{"legal-hold": true}`, http.StatusOK)
+	if resp := do("john", "GET", "/b.txt", "", http.StatusOK); "on" != resp.Header.Get(LegalHold) {
+		t.Errorf("Expected legal hold on and got %q\n", resp.Header.Get(LegalHold))
+	}
+	do("john", "PUT", "/b.txt", "two", http.StatusForbidden)
+	do("john", "DELETE", "/b.txt", "", http.StatusForbidden)
+	do("john", "POST", "/b.txt?legal-hold", `{"legal-hold": false}`, http.StatusForbidden)
+	if resp := do("john", "GET", "/b.txt", "", http.StatusOK); "on" != resp.Header.Get(LegalHold) {
+		t.Errorf("Expected legal hold to stay on and got %q\n", resp.Header.Get(LegalHold))
+	}
+	do("admin", "POST", "/b.txt?legal-hold", `{"legal-hold": false}`, http.StatusOK)
+	do("john", "PUT", "/b.txt", "two", http.StatusOK)
+	do("john", "DELETE", "/b.txt", "", http.StatusOK)
+}
@@ -0,0 +1,120 @@
+package file
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/halverneus/example/config"
+	"github.com/halverneus/example/database"
+	"github.com/halverneus/example/lib/web"
+	"github.com/halverneus/example/model"
+	"github.com/halverneus/example/storage"
+	"github.com/julienschmidt/httprouter"
+	"gopkg.in/yaml.v2"
+)
+
+// TestTiering of files uploaded through /api/file.
+func TestTiering(t *testing.T) {
+	// Load the database and keep both tiers in memory, moving everything under
+	// "/tiered/" cold once left alone for an hour. Delete everything on
+	// completion.
+	if err := database.Load("json", "tiering.db"); nil != err {
+		t.Fatalf("While loading database: %v\n", err)
+	}
+	defer os.Remove("tiering.db")
+	defer os.Remove("tiering.db.journal")
+
+	config.Get.Storage.Driver = "memory"
+	defer func() {
+		config.Get.Storage.Driver = "filesystem"
+		config.Get.Tiering.ColdFolder = ""
+		config.Get.Tiering.Rules = nil
+	}()
+	tiering := `{cold_folder: cold, rules: [{prefix: /tiered/, cold_after: 1h}]}`
+	if err := yaml.Unmarshal([]byte("tiering: "+tiering), &config.Get); nil != err {
+		t.Fatalf("Failed to parse tiering rules with: %v\n", err)
+	}
+	if err := model.Load(); nil != err {
+		t.Fatalf("While loading model: %v\n", err)
+	}
+
+	// Setup routes to API calls and start server.
+	router := httprouter.New()
+	router.GET("/api/file/*filepath", web.Wrap(GET))
+	router.HEAD("/api/file/*filepath", web.Wrap(GET))
+	router.PUT("/api/file/*filepath", web.Wrap(PUT))
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	// do a request to the path, expecting the status.
+	do := func(method, path, body string, status int) (*http.Response, string) {
+		req, err := http.NewRequest(method, server.URL+"/api/file"+path, strings.NewReader(body))
+		if nil != err {
+			t.Fatalf("Failed to create request with: %v\n", err)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if nil != err {
+			t.Fatalf("Failed to receive response with: %v\n", err)
+		}
+		defer resp.Body.Close()
+		raw, err := ioutil.ReadAll(resp.Body)
+		if nil != err {
+			t.Fatalf("Failed to read response with: %v\n", err)
+		}
+		if status != resp.StatusCode {
+			t.Errorf("%s %s: expected status %d and got %d (%s)\n", method, path, status, resp.StatusCode, raw)
+		}
+		return resp, string(raw)
+	}
+
+	// expect the file in the tier, and the contents to download from it.
+	expect := func(tier string) {
+		if resp, _ := do("HEAD", "/tiered/a.txt", "", http.StatusOK); tier != resp.Header.Get(StorageTier) {
+			t.Errorf("Expected the %s tier on HEAD and got %q\n", tier, resp.Header.Get(StorageTier))
+		}
+		resp, raw := do("GET", "/tiered/a.txt", "", http.StatusOK)
+		if tier != resp.Header.Get(StorageTier) || "contents" != raw {
+			t.Errorf("Expected contents from the %s tier and got %q from %q\n", tier, raw, resp.Header.Get(StorageTier))
+		}
+	}
+
+	// move contents between tiers, expecting the number of objects moved.
+	move := func(expected int) {
+		if moved, err := model.Tiering.Move(); nil != err || expected != moved {
+			t.Errorf("Expected %d objects moved and got %d with: %v\n", expected, moved, err)
+		}
+	}
+
+	// New uploads are hot until left alone long enough.
+	do("PUT", "/tiered/a.txt", "contents", http.StatusOK)
+	expect("hot")
+	move(0)
+
+	// Idle contents go cold, leaving nothing in the hot tier.
+	config.Get.Tiering.Rules[0].ColdAfter = time.Nanosecond
+	move(1)
+	expect("cold")
+	if _, err := model.File.Storage.Stat("/tiered/a.txt"); storage.ErrNotFound != err {
+		t.Errorf("Expected the hot copy gone and got: %v\n", err)
+	}
+	report, err := model.Maintenance.Check(false)
+	if nil != err {
+		t.Fatalf("Failed to check storage with: %v\n", err)
+	}
+	for _, issue := range append(report.Orphans, report.Missing...) {
+		if "/tiered/a.txt" == issue.Key {
+			t.Errorf("Expected the cold copy found and got: %+v\n", issue)
+		}
+	}
+
+	// Contents downloaded recently go back to the hot tier.
+	config.Get.Tiering.Rules[0].ColdAfter = time.Hour
+	move(1)
+	expect("hot")
+	move(0)
+}
@@ -0,0 +1,85 @@
+package file
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/halverneus/example/lib/web"
+	"github.com/halverneus/example/model"
+)
+
+// Files under the prefixes in "versioning.prefixes" keep their past versions
+// when replaced or deleted. Every upload returns its version in the
+// "X-Version-Id" header, and deleting leaves a delete marker as the latest
+// version. The following calls on the file path work with versions.
+// Credentials required for all of them.
+//     GET    "/api/latest/file/my/file.json?versions"              List versions.
+//     GET    "/api/latest/file/my/file.json?version=ID"            Download a version.
+//     DELETE "/api/latest/file/my/file.json?version=ID"            Delete a version for good.
+//     POST   "/api/latest/file/my/file.json?restore&version=ID"    Restore a version.
+// Files uploaded without versioning have the version "null".
+
+// VersionsResponse lists the versions of a file, newest first.
+type VersionsResponse struct {
+	Path     string         `json:"path"`
+	Versions []*VersionItem `json:"versions"`
+}
+
+// VersionItem describes a version. Delete markers have no contents.
+type VersionItem struct {
+	VersionID    string    `json:"version-id"`
+	Latest       bool      `json:"latest"`
+	DeleteMarker bool      `json:"delete-marker"`
+	Uploader     string    `json:"uploader,omitempty"`
+	Modified     time.Time `json:"modified"`
+	Size         int64     `json:"size"`
+	ETag         string    `json:"etag,omitempty"`
+}
+
+// RestoreResponse returns nothing. The "X-Version-Id" header identifies the new
+// current version.
+type RestoreResponse struct{}
+
+// listVersions of a file.
+func listVersions(ctx *web.Context) {
+	filePath := ctx.PS.ByName("filepath")
+	versions, err := model.File.Versions(filePath)
+	if nil != err {
+		ctx.Respond().Status(errorStatus(err, http.StatusNotFound)).With(err).Do()
+		return
+	}
+
+	// Reply with the versions.
+	resp := &VersionsResponse{Path: versions[0].Path, Versions: []*VersionItem{}}
+	for i, meta := range versions {
+		resp.Versions = append(resp.Versions, &VersionItem{
+			VersionID:    meta.VersionID,
+			Latest:       0 == i,
+			DeleteMarker: meta.DeleteMarker,
+			Uploader:     meta.Uploader,
+			Modified:     meta.Modified,
+			Size:         meta.Size,
+			ETag:         meta.ETag(),
+		})
+	}
+	ctx.Respond().With(resp).Do()
+}
+
+// restoreVersion of a file as the current version.
+func restoreVersion(ctx *web.Context) {
+	versionID := ctx.R.URL.Query().Get(VersionQuery)
+	if "" == versionID {
+		ctx.Respond().Status(http.StatusBadRequest).With(errors.New("version to restore is required")).Do()
+		return
+	}
+	meta, err := model.File.Restore(ctx.PS.ByName("filepath"), versionID, ctx.User)
+	if nil != err {
+		ctx.Respond().Status(errorStatus(err, http.StatusNotFound)).With(err).Do()
+		return
+	}
+
+	// Reply with success.
+	resp := &RestoreResponse{}
+	ctx.Respond().Add(ETag, meta.ETag()).Add(VersionID, meta.VersionID).With(resp).Do()
+}
@@ -0,0 +1,151 @@
+package file
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/halverneus/example/config"
+	"github.com/halverneus/example/database"
+	"github.com/halverneus/example/lib/web"
+	"github.com/halverneus/example/model"
+	"github.com/julienschmidt/httprouter"
+)
+
+// TestVersions of files through /api/file.
+func TestVersions(t *testing.T) {
+	// Load the database and keep contents in memory at their paths, versioning
+	// everything under "/docs/". Delete everything on completion.
+	if err := database.Load("json", "versions.db"); nil != err {
+		t.Fatalf("While loading database: %v\n", err)
+	}
+	defer os.Remove("versions.db")
+	defer os.Remove("versions.db.journal")
+
+	config.Get.Storage.Driver = "memory"
+	config.Get.Versioning.Prefixes = []string{"/docs/"}
+	defer func() {
+		config.Get.Storage.Driver = "filesystem"
+		config.Get.Versioning.Prefixes = nil
+	}()
+	if err := model.Load(); nil != err {
+		t.Fatalf("While loading model: %v\n", err)
+	}
+
+	// Setup routes to API calls and start server.
+	router := httprouter.New()
+	router.DELETE("/api/file/*filepath", web.Wrap(DELETE))
+	router.GET("/api/file/*filepath", web.Wrap(GET))
+	router.POST("/api/file/*filepath", web.Wrap(POST))
+	router.PUT("/api/file/*filepath", web.Wrap(PUT))
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	// do a request to the path, expecting the status.
+	do := func(method, path, body string, status int) (*http.Response, string) {
+		req, err := http.NewRequest(method, server.URL+"/api/file"+path, strings.NewReader(body))
+		if nil != err {
+			t.Fatalf("Failed to create request with: %v\n", err)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if nil != err {
+			t.Fatalf("Failed to receive response with: %v\n", err)
+		}
+		defer resp.Body.Close()
+		raw, err := ioutil.ReadAll(resp.Body)
+		if nil != err {
+			t.Fatalf("Failed to read response with: %v\n", err)
+		}
+		if status != resp.StatusCode {
+			t.Errorf("%s %s: expected status %d and got %d (%s)\n", method, path, status, resp.StatusCode, raw)
+		}
+		return resp, string(raw)
+	}
+
+	// expect contents at the path.
+	expect := func(path, contents string) {
+		if _, raw := do("GET", path, "", http.StatusOK); contents != raw {
+			t.Errorf("GET %s: expected %q and got %q\n", path, contents, raw)
+		}
+	}
+
+	// versions of the path, newest first, with the latest marked.
+	versions := func(path string) (ids []string, markers []bool) {
+		_, raw := do("GET", path+"?versions", "", http.StatusOK)
+		list := &VersionsResponse{}
+		if err := json.Unmarshal([]byte(raw), list); nil != err {
+			t.Fatalf("Failed to parse versions %s with: %v\n", raw, err)
+		}
+		for i, version := range list.Versions {
+			if (0 == i) != version.Latest {
+				t.Errorf("Version %d of %s has latest set to %v\n", i, path, version.Latest)
+			}
+			ids = append(ids, version.VersionID)
+			markers = append(markers, version.DeleteMarker)
+		}
+		return
+	}
+
+	// Every upload is a new version, and past versions remain readable.
+	resp, _ := do("PUT", "/docs/a.txt", "one", http.StatusOK)
+	v1 := resp.Header.Get(VersionID)
+	resp, _ = do("PUT", "/docs/a.txt", "two", http.StatusOK)
+	v2 := resp.Header.Get(VersionID)
+	if "" == v1 || "null" == v1 || v1 == v2 {
+		t.Fatalf("Expected distinct version IDs and got %q and %q\n", v1, v2)
+	}
+	expect("/docs/a.txt", "two")
+	expect("/docs/a.txt?version="+v1, "one")
+	if ids, _ := versions("/docs/a.txt"); 2 != len(ids) || v2 != ids[0] || v1 != ids[1] {
+		t.Errorf("Expected versions [%s %s] and got %v\n", v2, v1, ids)
+	}
+
+	// Deleting leaves a delete marker in front of the past versions.
+	do("DELETE", "/docs/a.txt", "", http.StatusOK)
+	do("GET", "/docs/a.txt", "", http.StatusNotFound)
+	expect("/docs/a.txt?version="+v2, "two")
+	ids, markers := versions("/docs/a.txt")
+	if 3 != len(ids) || !markers[0] || v2 != ids[1] {
+		t.Fatalf("Expected a delete marker ahead of %s and got %v %v\n", v2, ids, markers)
+	}
+	marker := ids[0]
+	do("GET", "/docs/a.txt?version="+marker, "", http.StatusNotFound)
+
+	// Restoring makes a past version current again as a new version.
+	resp, _ = do("POST", "/docs/a.txt?restore&version="+v1, "", http.StatusOK)
+	v3 := resp.Header.Get(VersionID)
+	expect("/docs/a.txt", "one")
+	do("POST", "/docs/a.txt?restore&version="+marker, "", http.StatusNotFound)
+	do("POST", "/docs/a.txt?restore", "", http.StatusBadRequest)
+
+	// Deleting versions for good. The delete marker becomes the latest again,
+	// and deleting it brings the file back.
+	do("DELETE", "/docs/a.txt?version="+v3, "", http.StatusOK)
+	do("GET", "/docs/a.txt", "", http.StatusNotFound)
+	do("DELETE", "/docs/a.txt?version="+marker, "", http.StatusOK)
+	expect("/docs/a.txt", "two")
+	do("DELETE", "/docs/a.txt?version="+v1, "", http.StatusOK)
+	do("GET", "/docs/a.txt?version="+v1, "", http.StatusNotFound)
+	if ids, _ := versions("/docs/a.txt"); 1 != len(ids) || v2 != ids[0] {
+		t.Errorf("Expected only version %s and got %v\n", v2, ids)
+	}
+	do("DELETE", "/docs/a.txt?version="+v2, "", http.StatusOK)
+	do("GET", "/docs/a.txt?versions", "", http.StatusNotFound)
+
+	// Files outside versioned prefixes have the "null" version only.
+	resp, _ = do("PUT", "/b.txt", "one", http.StatusOK)
+	if "null" != resp.Header.Get(VersionID) {
+		t.Errorf("Expected null version and got %q\n", resp.Header.Get(VersionID))
+	}
+	do("PUT", "/b.txt", "two", http.StatusOK)
+	expect("/b.txt?version=null", "two")
+	if ids, _ := versions("/b.txt"); 1 != len(ids) || "null" != ids[0] {
+		t.Errorf("Expected only the null version and got %v\n", ids)
+	}
+	do("DELETE", "/b.txt", "", http.StatusOK)
+	do("GET", "/b.txt?versions", "", http.StatusNotFound)
+}
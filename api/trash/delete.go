@@ -0,0 +1,36 @@
+package trash
+
+import (
+	"github.com/halverneus/example/lib/web"
+	"github.com/halverneus/example/model"
+)
+
+// DeleteRequest is just a URL call. Deleting "/api/latest/trash" empties the
+// trash, while deleting "/api/latest/trash/ID" purges a single item. Purged
+// files are gone for good. Credentials required.
+
+// DeleteResponse returns the number of items purged.
+type DeleteResponse struct {
+	Purged int `json:"purged"`
+}
+
+// DELETE items from the trash for good.
+func DELETE(ctx *web.Context) {
+	var count int
+	var err error
+	if id := ctx.PS.ByName("id"); "" != id {
+		if err = model.Trash.Purge(id); nil == err {
+			count = 1
+		}
+	} else {
+		count, err = model.Trash.Empty()
+	}
+	if nil != err {
+		ctx.Respond().Status(errorStatus(err)).With(err).Do()
+		return
+	}
+
+	// Reply with success.
+	resp := &DeleteResponse{Purged: count}
+	ctx.Respond().With(resp).Do()
+}
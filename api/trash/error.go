@@ -0,0 +1,18 @@
+package trash
+
+import (
+	"net/http"
+
+	"github.com/halverneus/example/model"
+)
+
+// errorStatus for an error returned by the model.
+func errorStatus(err error) int {
+	switch err {
+	case model.ErrTrashNotFound:
+		return http.StatusNotFound
+	case model.ErrRestoreConflict:
+		return http.StatusConflict
+	}
+	return http.StatusInternalServerError
+}
@@ -0,0 +1,44 @@
+package trash
+
+import (
+	"time"
+
+	"github.com/halverneus/example/lib/web"
+	"github.com/halverneus/example/model"
+)
+
+// GetRequest is just a URL call to "/api/latest/trash", listing every file in
+// the trash, oldest first. Credentials required.
+
+// GetResponse lists the items in the trash.
+type GetResponse struct {
+	Items []*Item `json:"items"`
+}
+
+// Item in the trash, identified by its ID when restoring or purging.
+type Item struct {
+	ID          string    `json:"id"`
+	Path        string    `json:"path"`
+	DeletedBy   string    `json:"deleted-by"`
+	Deleted     time.Time `json:"deleted"`
+	Uploader    string    `json:"uploader"`
+	ContentType string    `json:"content-type"`
+	Size        int64     `json:"size"`
+}
+
+// GET the items in the trash.
+func GET(ctx *web.Context) {
+	resp := &GetResponse{Items: []*Item{}}
+	for _, item := range model.Trash.List() {
+		resp.Items = append(resp.Items, &Item{
+			ID:          item.ID,
+			Path:        item.File.Path,
+			DeletedBy:   item.DeletedBy,
+			Deleted:     item.Deleted,
+			Uploader:    item.File.Uploader,
+			ContentType: item.File.ContentType,
+			Size:        item.File.Size,
+		})
+	}
+	ctx.Respond().With(resp).Do()
+}
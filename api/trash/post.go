@@ -0,0 +1,28 @@
+package trash
+
+import (
+	"github.com/halverneus/example/lib/web"
+	"github.com/halverneus/example/model"
+)
+
+// PostRequest is just a URL call to an item, restoring it to the path it was
+// deleted from (ex: "/api/latest/trash/ID"). Credentials required. Fails with a
+// conflict when a file has since been uploaded to the path.
+
+// PostResponse returns the path of the restored file.
+type PostResponse struct {
+	Path string `json:"path"`
+}
+
+// POST restores an item from the trash.
+func POST(ctx *web.Context) {
+	meta, err := model.Trash.Restore(ctx.PS.ByName("id"))
+	if nil != err {
+		ctx.Respond().Status(errorStatus(err)).With(err).Do()
+		return
+	}
+
+	// Reply with success.
+	resp := &PostResponse{Path: meta.Path}
+	ctx.Respond().With(resp).Do()
+}
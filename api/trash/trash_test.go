@@ -0,0 +1,169 @@
+package trash
+
+import (
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/halverneus/example/config"
+	"github.com/halverneus/example/database"
+	"github.com/halverneus/example/lib/web"
+	"github.com/halverneus/example/model"
+	"github.com/julienschmidt/httprouter"
+)
+
+// TestTrash through /api/trash.
+func TestTrash(t *testing.T) {
+	// Load the database and keep contents in memory at their paths, with the
+	// trash enabled. Delete everything on completion.
+	if err := database.Load("json", "trash.db"); nil != err {
+		t.Fatalf("While loading database: %v\n", err)
+	}
+	defer os.Remove("trash.db")
+	defer os.Remove("trash.db.journal")
+
+	config.Get.Storage.Driver = "memory"
+	config.Get.Trash.Enabled = true
+	defer func() {
+		config.Get.Storage.Driver = "filesystem"
+		config.Get.Trash.Enabled = false
+		config.Get.Trash.Retention = 30 * 24 * time.Hour
+	}()
+	if err := model.Load(); nil != err {
+		t.Fatalf("While loading model: %v\n", err)
+	}
+
+	// Setup routes to API calls and start server.
+	router := httprouter.New()
+	router.DELETE("/api/trash", web.Wrap(DELETE))
+	router.GET("/api/trash", web.Wrap(GET))
+	router.DELETE("/api/trash/:id", web.Wrap(DELETE))
+	router.POST("/api/trash/:id", web.Wrap(POST))
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	// do a request to the path, expecting the status.
+	do := func(method, path string, status int) []byte {
+		req, err := http.NewRequest(method, server.URL+"/api/trash"+path, nil)
+		if nil != err {
+			t.Fatalf("Failed to create request with: %v\n", err)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if nil != err {
+			t.Fatalf("Failed to receive response with: %v\n", err)
+		}
+		defer resp.Body.Close()
+		raw, err := ioutil.ReadAll(resp.Body)
+		if nil != err {
+			t.Fatalf("Failed to read response with: %v\n", err)
+		}
+		if status != resp.StatusCode {
+			t.Errorf("%s %s: expected status %d and got %d (%s)\n", method, path, status, resp.StatusCode, raw)
+		}
+		return raw
+	}
+
+	// list the items in the trash.
+	list := func() []*Item {
+		resp := &GetResponse{}
+		if raw := do("GET", "", http.StatusOK); nil != json.Unmarshal(raw, resp) {
+			t.Fatalf("Failed to parse trash %s\n", raw)
+		}
+		return resp.Items
+	}
+
+	// upload contents to the path.
+	upload := func(filePath, contents string) {
+		meta := &model.FileMetadata{Path: filePath, Uploader: "admin"}
+		if err := model.File.Upload(meta, strings.NewReader(contents)); nil != err {
+			t.Fatalf("Failed to upload %s with: %v\n", filePath, err)
+		}
+	}
+
+	// remove the file at the path.
+	remove := func(filePath string) {
+		if err := model.File.Delete(filePath, "john"); nil != err {
+			t.Fatalf("Failed to delete %s with: %v\n", filePath, err)
+		}
+	}
+
+	// expect contents at the path.
+	expect := func(filePath, contents string) {
+		err := model.File.Download(filePath, nil, func(meta *model.FileMetadata, content io.ReadSeeker) error {
+			raw, err := ioutil.ReadAll(content)
+			if nil == err && contents != string(raw) {
+				t.Errorf("Expected %q at %s and got %q\n", contents, filePath, raw)
+			}
+			return err
+		})
+		if nil != err {
+			t.Errorf("Failed to download %s with: %v\n", filePath, err)
+		}
+	}
+
+	// Deleted files go to the trash, recording who deleted them.
+	upload("/a.txt", "one")
+	remove("/a.txt")
+	if _, err := model.File.Metadata("/a.txt"); nil == err {
+		t.Error("Expected deleted file to be gone")
+	}
+	items := list()
+	if 1 != len(items) || "/a.txt" != items[0].Path || "john" != items[0].DeletedBy || 3 != items[0].Size {
+		t.Fatalf("Unexpected trash after delete: %+v\n", items)
+	}
+	first := items[0].ID
+
+	// Uploading to the path again leaves the contents in the trash alone, and
+	// blocks restoring until deleted as well.
+	upload("/a.txt", "two")
+	do("POST", "/"+first, http.StatusConflict)
+	remove("/a.txt")
+	if items = list(); 2 != len(items) || first != items[0].ID {
+		t.Fatalf("Expected two items in the trash and got %+v\n", items)
+	}
+	second := items[1].ID
+
+	// Restoring puts the file back at its path.
+	if raw := do("POST", "/"+first, http.StatusOK); `{"path":"/a.txt"}` != strings.TrimSpace(string(raw)) {
+		t.Errorf("Unexpected restore response %s\n", raw)
+	}
+	expect("/a.txt", "one")
+	do("POST", "/"+first, http.StatusNotFound)
+
+	// Purging an item removes it for good.
+	if raw := do("DELETE", "/"+second, http.StatusOK); `{"purged":1}` != strings.TrimSpace(string(raw)) {
+		t.Errorf("Unexpected purge response %s\n", raw)
+	}
+	do("DELETE", "/"+second, http.StatusNotFound)
+	if items = list(); 0 != len(items) {
+		t.Errorf("Expected an empty trash and got %+v\n", items)
+	}
+
+	// Items are purged once kept for longer than the retention.
+	remove("/a.txt")
+	if count, err := model.Trash.Expire(); nil != err || 0 != count {
+		t.Errorf("Expected nothing to expire and got %d with: %v\n", count, err)
+	}
+	config.Get.Trash.Retention = time.Nanosecond
+	if count, err := model.Trash.Expire(); nil != err || 1 != count {
+		t.Errorf("Expected one item to expire and got %d with: %v\n", count, err)
+	}
+
+	// Emptying purges everything.
+	upload("/b.txt", "one")
+	upload("/c.txt", "two")
+	remove("/b.txt")
+	remove("/c.txt")
+	if raw := do("DELETE", "", http.StatusOK); `{"purged":2}` != strings.TrimSpace(string(raw)) {
+		t.Errorf("Unexpected empty response %s\n", raw)
+	}
+	if items = list(); 0 != len(items) {
+		t.Errorf("Expected an empty trash and got %+v\n", items)
+	}
+}
@@ -0,0 +1,24 @@
+package upload
+
+import (
+	"net/http"
+
+	"github.com/halverneus/example/lib/web"
+	"github.com/halverneus/example/model"
+)
+
+// DeleteRequest is just a URL call to an upload, discarding everything received
+// so far. Credentials required.
+
+// DELETE an upload.
+func DELETE(ctx *web.Context) {
+	if !resumable(ctx) {
+		return
+	}
+
+	if err := model.Uploads.Terminate(ctx.PS.ByName("id"), ctx.User); nil != err {
+		ctx.Respond().Status(errorStatus(err)).Add(TusResumable, tusVersion).With(err).Do()
+		return
+	}
+	ctx.Respond().Status(http.StatusNoContent).Add(TusResumable, tusVersion).Do()
+}
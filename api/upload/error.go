@@ -0,0 +1,45 @@
+package upload
+
+import (
+	"net/http"
+
+	"github.com/halverneus/example/lib/web"
+	"github.com/halverneus/example/model"
+)
+
+// errorStatus for an error returned by the model.
+func errorStatus(err error) int {
+	switch err.(type) {
+	case *model.KeyError:
+		return http.StatusBadRequest
+	case *model.OffsetError:
+		return http.StatusConflict
+	case *model.ChecksumError:
+		return StatusChecksumMismatch
+	case *model.QuotaError:
+		return http.StatusInsufficientStorage
+	}
+	switch err {
+	case model.ErrUploadNotFound:
+		return http.StatusNotFound
+	case model.ErrUploadTooLarge:
+		return http.StatusRequestEntityTooLarge
+	case model.ErrLocked:
+		return http.StatusForbidden
+	}
+	return http.StatusInternalServerError
+}
+
+// resumable checks the client speaks the supported protocol version, replying
+// with a failure if not.
+func resumable(ctx *web.Context) bool {
+	if tusVersion == ctx.R.Header.Get(TusResumable) {
+		return true
+	}
+	ctx.Respond().
+		Status(http.StatusPreconditionFailed).
+		Add(TusVersion, tusVersion).
+		With("unsupported protocol version").
+		Do()
+	return false
+}
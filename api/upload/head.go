@@ -0,0 +1,35 @@
+package upload
+
+import (
+	"strconv"
+
+	"github.com/halverneus/example/lib/web"
+	"github.com/halverneus/example/model"
+)
+
+// HeadRequest is just a URL call to an upload, returning how much has been
+// received in the "Upload-Offset" header. Clients resume from there after a
+// broken connection. Credentials required.
+
+// HEAD of an upload.
+func HEAD(ctx *web.Context) {
+	if !resumable(ctx) {
+		return
+	}
+
+	u, err := model.Uploads.Get(ctx.PS.ByName("id"), ctx.User)
+	if nil != err {
+		ctx.Respond().Status(errorStatus(err)).Add(TusResumable, tusVersion).With(err).Do()
+		return
+	}
+
+	resp := addExpires(ctx.Respond(), u).
+		Add(TusResumable, tusVersion).
+		Add(CacheControl, "no-store").
+		Add(UploadOffset, strconv.FormatInt(u.Offset, 10)).
+		Add(UploadLength, strconv.FormatInt(u.Length, 10))
+	if "" != u.Metadata {
+		resp.Add(UploadMetadata, u.Metadata)
+	}
+	resp.Do()
+}
@@ -0,0 +1,60 @@
+package upload
+
+import (
+	"net/http"
+
+	"github.com/halverneus/example/lib/web"
+	"github.com/halverneus/example/model"
+)
+
+const (
+	// TusResumable is the protocol version of a request or response.
+	TusResumable = "Tus-Resumable"
+	// TusVersion lists the protocol versions supported.
+	TusVersion = "Tus-Version"
+	// TusExtension lists the protocol extensions supported.
+	TusExtension = "Tus-Extension"
+	// TusChecksumAlgorithm lists the algorithms usable with UploadChecksum.
+	TusChecksumAlgorithm = "Tus-Checksum-Algorithm"
+	// UploadLength is the total size of an upload.
+	UploadLength = "Upload-Length"
+	// UploadDeferLength announces an upload of unknown size, which is not
+	// supported.
+	UploadDeferLength = "Upload-Defer-Length"
+	// UploadOffset is the amount of an upload received.
+	UploadOffset = "Upload-Offset"
+	// UploadMetadata holds comma-separated keys with Base64 encoded values.
+	UploadMetadata = "Upload-Metadata"
+	// UploadExpires is when an upload is aborted unless more contents arrive.
+	UploadExpires = "Upload-Expires"
+	// UploadChecksum is the algorithm and Base64 encoded checksum of a PATCH
+	// request body.
+	UploadChecksum = "Upload-Checksum"
+	// Location of a created upload.
+	Location = "Location"
+	// CacheControl of responses.
+	CacheControl = "Cache-Control"
+	// ETag of the file an upload became.
+	ETag = "ETag"
+
+	// tusVersion implemented.
+	tusVersion = "1.0.0"
+	// tusExtensions implemented.
+	tusExtensions = "creation,termination,checksum,expiration"
+	// tusChecksumAlgorithms supported.
+	tusChecksumAlgorithms = "md5,sha1,sha256"
+	// offsetContent is the only content type accepted when appending.
+	offsetContent = "application/offset+octet-stream"
+
+	// StatusChecksumMismatch is returned when appended contents don't match the
+	// checksum sent with them.
+	StatusChecksumMismatch = 460
+)
+
+// addExpires to a response about the upload, unless it never expires.
+func addExpires(resp *web.Response, u *model.ResumableUpload) *web.Response {
+	if !u.Expires.IsZero() {
+		resp.Add(UploadExpires, u.Expires.Format(http.TimeFormat))
+	}
+	return resp
+}
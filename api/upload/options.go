@@ -0,0 +1,22 @@
+package upload
+
+import (
+	"net/http"
+
+	"github.com/halverneus/example/lib/web"
+)
+
+// OptionsRequest is just a URL call, used by clients to discover what the
+// server supports.
+
+// OPTIONS describes the supported protocol version, extensions and checksum
+// algorithms.
+func OPTIONS(ctx *web.Context) {
+	ctx.Respond().
+		Status(http.StatusNoContent).
+		Add(TusResumable, tusVersion).
+		Add(TusVersion, tusVersion).
+		Add(TusExtension, tusExtensions).
+		Add(TusChecksumAlgorithm, tusChecksumAlgorithms).
+		Do()
+}
@@ -0,0 +1,92 @@
+package upload
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/halverneus/example/lib/web"
+	"github.com/halverneus/example/model"
+)
+
+// PatchRequest appends the request body to an upload. Set the "Content-Type"
+// header to "application/offset+octet-stream" and "Upload-Offset" to the
+// offset returned by the last request. Optionally, set "Upload-Checksum" to the
+// algorithm and Base64 encoded checksum of the body to have it discarded if
+// altered (ex: "sha1 Kq5sNclPz7QV2+lfQIuc6R7oRu0="). The upload becomes a file
+// once complete. Credentials required.
+
+// PATCH an upload with more contents.
+func PATCH(ctx *web.Context) {
+	if !resumable(ctx) {
+		return
+	}
+
+	// reject the request as malformed.
+	reject := func(status int, err error) {
+		ctx.Respond().Status(status).Add(TusResumable, tusVersion).With(err).Do()
+	}
+
+	if offsetContent != ctx.R.Header.Get(web.ContentType) {
+		reject(http.StatusUnsupportedMediaType, errors.New("content type must be "+offsetContent))
+		return
+	}
+	offset, err := strconv.ParseInt(ctx.R.Header.Get(UploadOffset), 10, 64)
+	if nil != err || 0 > offset {
+		reject(http.StatusBadRequest, errors.New("upload offset is required"))
+		return
+	}
+	algorithm, checksum, err := checksumHeader(ctx.R.Header.Get(UploadChecksum))
+	if nil != err {
+		reject(http.StatusBadRequest, err)
+		return
+	}
+
+	// Append the contents.
+	u, meta, err := model.Uploads.Append(ctx.PS.ByName("id"), ctx.User, offset, ctx.Reader(), algorithm, checksum)
+	if nil != err {
+		reject(errorStatus(err), err)
+		return
+	}
+
+	// Reply with the new offset, identifying the file once complete and
+	// otherwise when the upload expires.
+	resp := ctx.Respond().
+		Status(http.StatusNoContent).
+		Add(TusResumable, tusVersion).
+		Add(UploadOffset, strconv.FormatInt(u.Offset, 10))
+	if nil != meta {
+		resp.Add(ETag, meta.ETag())
+	} else {
+		addExpires(resp, u)
+	}
+	resp.Do()
+}
+
+// checksumHeader split into the algorithm and hex encoded checksum. An empty
+// header returns an empty algorithm.
+func checksumHeader(header string) (algorithm, checksum string, err error) {
+	if "" == header {
+		return
+	}
+	fields := strings.Fields(header)
+	if 2 != len(fields) {
+		err = errors.New("upload checksum must be an algorithm and a Base64 encoded checksum")
+		return
+	}
+	if !model.Uploads.ChecksumAlgorithm(fields[0]) {
+		err = errors.New("unsupported checksum algorithm: " + fields[0])
+		return
+	}
+
+	var raw []byte
+	if raw, err = base64.StdEncoding.DecodeString(fields[1]); nil != err {
+		err = errors.New("upload checksum must be Base64 encoded")
+		return
+	}
+	algorithm, checksum = fields[0], hex.EncodeToString(raw)
+	return
+}
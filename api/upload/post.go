@@ -0,0 +1,90 @@
+package upload
+
+import (
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/halverneus/example/lib/web"
+	"github.com/halverneus/example/model"
+)
+
+// PostRequest creates a resumable upload following the tus 1.0 protocol. Set
+// the "Upload-Length" header to the size of the file and "Upload-Metadata" to
+// comma-separated keys, each followed by a space and a Base64 encoded value.
+// The "path" key is required and names the file to create, while "filetype"
+// sets its content type. For example, to upload "my/folder/file.json":
+//     Upload-Metadata: path bXkvZm9sZGVyL2ZpbGUuanNvbg==,filetype YXBwbGljYXRpb24vanNvbg==
+// The new upload is at the URL in the "Location" header. Credentials required.
+
+// POST a new upload.
+func POST(ctx *web.Context) {
+	if !resumable(ctx) {
+		return
+	}
+
+	// Collect the upload description.
+	u := &model.ResumableUpload{
+		Uploader: ctx.User,
+		Metadata: ctx.R.Header.Get(UploadMetadata),
+	}
+	var err error
+	if "" != ctx.R.Header.Get(UploadDeferLength) {
+		err = errors.New("deferred upload length is not supported")
+	} else if u.Length, err = strconv.ParseInt(ctx.R.Header.Get(UploadLength), 10, 64); nil != err {
+		err = errors.New("upload length is required")
+	}
+	if nil != err {
+		ctx.Respond().Status(http.StatusBadRequest).Add(TusResumable, tusVersion).With(err).Do()
+		return
+	}
+	var metadata map[string]string
+	if metadata, err = parseMetadata(u.Metadata); nil != err {
+		ctx.Respond().Status(http.StatusBadRequest).Add(TusResumable, tusVersion).With(err).Do()
+		return
+	}
+	u.Path, u.ContentType = metadata["path"], metadata["filetype"]
+
+	// Create the upload.
+	if err = model.Uploads.Create(u); nil != err {
+		ctx.Respond().Status(errorStatus(err)).Add(TusResumable, tusVersion).With(err).Do()
+		return
+	}
+
+	// Reply with the location of the new upload.
+	addExpires(ctx.Respond(), u).
+		Status(http.StatusCreated).
+		Add(TusResumable, tusVersion).
+		Add(Location, strings.TrimSuffix(ctx.R.URL.Path, "/")+"/"+u.ID).
+		Add(UploadOffset, "0").
+		Do()
+}
+
+// parseMetadata from the "Upload-Metadata" header.
+func parseMetadata(header string) (metadata map[string]string, err error) {
+	metadata = map[string]string{}
+	for _, pair := range strings.Split(header, ",") {
+		fields := strings.Fields(pair)
+		switch len(fields) {
+		case 0:
+			continue
+		case 1:
+			metadata[fields[0]] = ""
+			continue
+		case 2:
+		default:
+			err = errors.New("malformed upload metadata")
+			return
+		}
+
+		var value []byte
+		if value, err = base64.StdEncoding.DecodeString(fields[1]); nil != err {
+			err = errors.New("upload metadata values must be Base64 encoded")
+			return
+		}
+		metadata[fields[0]] = string(value)
+	}
+	return
+}
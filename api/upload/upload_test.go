@@ -0,0 +1,268 @@
+package upload
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/halverneus/example/config"
+	"github.com/halverneus/example/database"
+	"github.com/halverneus/example/lib/web"
+	"github.com/halverneus/example/model"
+	"github.com/julienschmidt/httprouter"
+)
+
+// TestAll methods for /api/uploads.
+func TestAll(t *testing.T) {
+	const (
+		// metadata naming "/a/b.txt" as "text/plain".
+		metadata = "path L2EvYi50eHQ=,filetype dGV4dC9wbGFpbg=="
+		// sha1 of ", " and of "world", Base64 encoded.
+		sha1Comma = "sha1 07yaN42qod3bobGcGqZB0+loPEY="
+		sha1World = "sha1 fCEUM/AgcVl3Qeb/Wo6jR4mrv0M="
+		etag      = `"09ca7e4eaa6e8ae9c7d261167129184883644d07dfba7cbfbc4c8a2e08360d5b"`
+	)
+
+	// tus headers sent with most requests.
+	tus := func(headers ...string) map[string]string {
+		h := map[string]string{TusResumable: tusVersion}
+		for i := 0; i+1 < len(headers); i += 2 {
+			h[headers[i]] = headers[i+1]
+		}
+		return h
+	}
+
+	// All test cases to be performed, in order. "{id}" is replaced with the ID
+	// of the last upload created.
+	testCases := []struct {
+		name    string
+		method  string
+		path    string
+		body    string
+		headers map[string]string
+		status  int
+		expect  map[string]string
+	}{
+		{"Options", "OPTIONS", "", "", nil, http.StatusNoContent, map[string]string{TusExtension: tusExtensions}},
+		{"Create without version", "POST", "", "", map[string]string{UploadLength: "12", UploadMetadata: metadata}, http.StatusPreconditionFailed, map[string]string{TusVersion: tusVersion}},
+		{"Create without length", "POST", "", "", tus(UploadMetadata, metadata), http.StatusBadRequest, nil},
+		{"Create deferred length", "POST", "", "", tus(UploadDeferLength, "1", UploadMetadata, metadata), http.StatusBadRequest, nil},
+		{"Create without path", "POST", "", "", tus(UploadLength, "12"), http.StatusBadRequest, nil},
+		{"Create over quota", "POST", "", "", tus(UploadLength, "65", UploadMetadata, metadata), http.StatusInsufficientStorage, nil},
+		{"Create", "POST", "", "", tus(UploadLength, "12", UploadMetadata, metadata), http.StatusCreated, map[string]string{UploadOffset: "0"}},
+		{"Head new upload", "HEAD", "/{id}", "", tus(), http.StatusOK, map[string]string{UploadOffset: "0", UploadLength: "12", UploadMetadata: metadata}},
+		{"Patch wrong content type", "PATCH", "/{id}", "hello", tus(web.ContentType, "text/plain", UploadOffset, "0"), http.StatusUnsupportedMediaType, nil},
+		{"Patch without offset", "PATCH", "/{id}", "hello", tus(web.ContentType, offsetContent), http.StatusBadRequest, nil},
+		{"Patch first part", "PATCH", "/{id}", "hello", tus(web.ContentType, offsetContent, UploadOffset, "0"), http.StatusNoContent, map[string]string{UploadOffset: "5"}},
+		{"Patch stale offset", "PATCH", "/{id}", "hello", tus(web.ContentType, offsetContent, UploadOffset, "0"), http.StatusConflict, nil},
+		{"Head partial upload", "HEAD", "/{id}", "", tus(), http.StatusOK, map[string]string{UploadOffset: "5"}},
+		{"Patch unknown algorithm", "PATCH", "/{id}", ", ", tus(web.ContentType, offsetContent, UploadOffset, "5", UploadChecksum, "crc32 AAAAAA=="), http.StatusBadRequest, nil},
+		{"Patch with checksum", "PATCH", "/{id}", ", ", tus(web.ContentType, offsetContent, UploadOffset, "5", UploadChecksum, sha1Comma), http.StatusNoContent, map[string]string{UploadOffset: "7"}},
+		{"Patch checksum mismatch", "PATCH", "/{id}", "wrong", tus(web.ContentType, offsetContent, UploadOffset, "7", UploadChecksum, sha1World), StatusChecksumMismatch, nil},
+		{"Head after mismatch", "HEAD", "/{id}", "", tus(), http.StatusOK, map[string]string{UploadOffset: "7"}},
+		{"Patch too large", "PATCH", "/{id}", "world!", tus(web.ContentType, offsetContent, UploadOffset, "7"), http.StatusRequestEntityTooLarge, nil},
+		{"Patch last part", "PATCH", "/{id}", "world", tus(web.ContentType, offsetContent, UploadOffset, "7", UploadChecksum, sha1World), http.StatusNoContent, map[string]string{UploadOffset: "12", ETag: etag}},
+		{"Head completed upload", "HEAD", "/{id}", "", tus(), http.StatusNotFound, nil},
+		{"Create second", "POST", "", "", tus(UploadLength, "5", UploadMetadata, "path L2MudHh0"), http.StatusCreated, nil},
+		{"Terminate", "DELETE", "/{id}", "", tus(), http.StatusNoContent, nil},
+		{"Terminate again", "DELETE", "/{id}", "", tus(), http.StatusNotFound, nil},
+		{"Head unknown upload", "HEAD", "/0123", "", tus(), http.StatusNotFound, nil},
+	}
+
+	// Load the database, keep contents in memory and limit storage to 64 bytes.
+	// Delete everything on completion.
+	if err := database.Load("json", "example.db"); nil != err {
+		t.Fatalf("While loading database: %v\n", err)
+	}
+	defer os.Remove("example.db")
+	defer os.Remove("example.db.journal")
+
+	const uploadsFolder = "testuploads"
+	config.Get.Storage.Driver = "memory"
+	config.Get.Uploads.Folder = uploadsFolder
+	config.Get.Quota.Global.MaxBytes = 64
+	defer func() {
+		config.Get.Storage.Driver = "filesystem"
+		config.Get.Uploads.Folder = "uploads"
+		config.Get.Quota.Global.MaxBytes = 0
+		os.RemoveAll(uploadsFolder)
+	}()
+	if err := model.Load(); nil != err {
+		t.Fatalf("While loading model: %v\n", err)
+	}
+
+	// Setup routes to API calls.
+	router := httprouter.New()
+	router.OPTIONS("/api/uploads", web.Wrap(OPTIONS))
+	router.POST("/api/uploads", web.Wrap(POST))
+	router.DELETE("/api/uploads/:id", web.Wrap(DELETE))
+	router.HEAD("/api/uploads/:id", web.Wrap(HEAD))
+	router.PATCH("/api/uploads/:id", web.Wrap(PATCH))
+
+	// Start server.
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	// Run all test cases.
+	id := ""
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			url := server.URL + "/api/uploads" + strings.Replace(tc.path, "{id}", id, 1)
+			req, err := http.NewRequest(tc.method, url, strings.NewReader(tc.body))
+			if nil != err {
+				t.Fatalf("Failed to create request with: %v\n", err)
+			}
+			for k, v := range tc.headers {
+				req.Header.Set(k, v)
+			}
+
+			// Perform request, not forgetting to close the response body.
+			var resp *http.Response
+			if resp, err = http.DefaultClient.Do(req); nil != err {
+				t.Fatalf("Failed to receive response with: %v\n", err)
+			}
+			defer resp.Body.Close()
+
+			var rawResp []byte
+			if rawResp, err = ioutil.ReadAll(resp.Body); nil != err {
+				t.Fatalf("Failed to read response with: %v\n", err)
+			}
+			if tc.status != resp.StatusCode {
+				t.Fatalf(
+					"Status code mismatch. Expected %d and got %d (%s)\n",
+					tc.status,
+					resp.StatusCode,
+					string(rawResp),
+				)
+			}
+			for k, v := range tc.expect {
+				if got := resp.Header.Get(k); v != got {
+					t.Errorf("Header %s mismatch. Expected %s and got %s\n", k, v, got)
+				}
+			}
+
+			// Remember created uploads.
+			if http.StatusCreated == resp.StatusCode {
+				id = path.Base(resp.Header.Get(Location))
+			}
+		})
+	}
+
+	// The completed upload is a file with every part in order.
+	var contents []byte
+	err := model.File.Download("/a/b.txt", nil, func(meta *model.FileMetadata, content io.ReadSeeker) (err error) {
+		contents, err = ioutil.ReadAll(content)
+		return
+	})
+	if nil != err {
+		t.Fatalf("Failed to download completed upload with: %v\n", err)
+	}
+	if "hello, world" != string(contents) {
+		t.Errorf("Contents mismatch. Expected %q and got %q\n", "hello, world", contents)
+	}
+}
+
+// TestExpiry of uploads left idle, which are announced and then aborted.
+func TestExpiry(t *testing.T) {
+	// Load the database and keep contents in memory, expiring uploads after an
+	// hour. Delete everything on completion.
+	if err := database.Load("json", "expiry.db"); nil != err {
+		t.Fatalf("While loading database: %v\n", err)
+	}
+	defer os.Remove("expiry.db")
+	defer os.Remove("expiry.db.journal")
+
+	const uploadsFolder = "expiryuploads"
+	config.Get.Storage.Driver = "memory"
+	config.Get.Uploads.Folder = uploadsFolder
+	config.Get.Uploads.Expiry = time.Hour
+	defer func() {
+		config.Get.Storage.Driver = "filesystem"
+		config.Get.Uploads.Folder = "uploads"
+		config.Get.Uploads.Expiry = 0
+		os.RemoveAll(uploadsFolder)
+	}()
+	if err := model.Load(); nil != err {
+		t.Fatalf("While loading model: %v\n", err)
+	}
+
+	// Setup routes to API calls and start server.
+	router := httprouter.New()
+	router.POST("/api/uploads", web.Wrap(POST))
+	router.HEAD("/api/uploads/:id", web.Wrap(HEAD))
+	router.PATCH("/api/uploads/:id", web.Wrap(PATCH))
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	// request the upload, expecting the status and returning the response.
+	request := func(method, id, body string, status int, headers ...string) *http.Response {
+		req, err := http.NewRequest(method, server.URL+"/api/uploads"+id, strings.NewReader(body))
+		if nil != err {
+			t.Fatalf("Failed to create request with: %v\n", err)
+		}
+		req.Header.Set(TusResumable, tusVersion)
+		for i := 0; i+1 < len(headers); i += 2 {
+			req.Header.Set(headers[i], headers[i+1])
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if nil != err {
+			t.Fatalf("Failed to receive response with: %v\n", err)
+		}
+		resp.Body.Close()
+		if status != resp.StatusCode {
+			t.Fatalf("Expected %s %s to return %d and got %d\n", method, id, status, resp.StatusCode)
+		}
+		return resp
+	}
+
+	// expires within a minute of an hour from now.
+	expires := func(resp *http.Response) {
+		at, err := http.ParseTime(resp.Header.Get(UploadExpires))
+		if nil != err {
+			t.Fatalf("Expected an expiry and got %q\n", resp.Header.Get(UploadExpires))
+		}
+		if at.Before(time.Now().Add(59*time.Minute)) || at.After(time.Now().Add(61*time.Minute)) {
+			t.Errorf("Expected the upload to expire in an hour and got %s\n", at)
+		}
+	}
+
+	// sweep uploads, expecting the number aborted.
+	sweep := func(expected int) {
+		if count, err := model.Uploads.Sweep(); nil != err || expected != count {
+			t.Errorf("Expected %d uploads to be aborted and got %d (%v)\n", expected, count, err)
+		}
+	}
+
+	// Every response about an upload in progress tells when it expires.
+	create := func(metadata string) string {
+		resp := request("POST", "", "", http.StatusCreated, UploadLength, "5", UploadMetadata, metadata)
+		expires(resp)
+		return "/" + path.Base(resp.Header.Get(Location))
+	}
+	idle := create("path L2lkbGUudHh0")
+	active := create("path L2FjdGl2ZS50eHQ=")
+	expires(request("PATCH", idle, "he", http.StatusNoContent, web.ContentType, offsetContent, UploadOffset, "0"))
+	expires(request("HEAD", active, "", http.StatusOK))
+
+	// Only the upload idle for longer than the expiry is aborted.
+	past := time.Now().Add(-2 * time.Hour)
+	for _, name := range []string{idle[1:], idle[1:] + ".json"} {
+		if err := os.Chtimes(path.Join(uploadsFolder, name), past, past); nil != err {
+			t.Fatalf("Failed to age %s with: %v\n", name, err)
+		}
+	}
+	sweep(1)
+	request("HEAD", idle, "", http.StatusNotFound)
+	request("HEAD", active, "", http.StatusOK)
+
+	// An expired upload is gone even before being swept.
+	config.Get.Uploads.Expiry = time.Nanosecond
+	request("HEAD", active, "", http.StatusNotFound)
+	sweep(1)
+}
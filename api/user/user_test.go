@@ -72,11 +72,12 @@ func TestAll(t *testing.T) {
 
 	// Load the database. Use default configuration values. Delete database and
 	// storage folder on completion.
-	if err := database.Load("example.db"); nil != err {
+	if err := database.Load("json", "example.db"); nil != err {
 		t.Errorf("While loading database: %v\n", err)
 		return
 	}
 	defer os.Remove("example.db")
+	defer os.Remove("example.db.journal")
 	defer os.RemoveAll("storage")
 
 	// Setup routes to API calls.
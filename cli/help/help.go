@@ -25,13 +25,59 @@ OPTIONS
     -v, --version    Print version information.
 
 ENVIRONMENT VARIABLES
+    EXAMPLE_DATABASE_DRIVER      Database driver ("json", "bolt" or
+                                 "sqlite").
     EXAMPLE_DATABASE_FILENAME    Location of database file.
+    EXAMPLE_STORAGE_DRIVER       Storage backend ("filesystem", "memory" or
+                                 "s3").
     EXAMPLE_STORAGE_FOLDER       Folder for storing files.
+    EXAMPLE_STORAGE_FOLDERS      Comma-separated folders, each holding a full
+                                 copy of every file (replaces folder).
+    EXAMPLE_STORAGE_LAYOUT       Where contents are kept ("path" or "hashed").
+    EXAMPLE_STORAGE_DEDUPLICATE  Store identical contents once ("true" or
+                                 "false").
+    EXAMPLE_STORAGE_ENCRYPTION_KEY_FILE  Master key file enabling encryption
+                                 at rest.
+    EXAMPLE_STORAGE_S3_ENDPOINT  S3-compatible endpoint (example:
+                                 "http://127.0.0.1:9000").
+    EXAMPLE_STORAGE_S3_REGION    S3 signing region (default: "us-east-1").
+    EXAMPLE_STORAGE_S3_BUCKET    S3 bucket for storing files.
+    EXAMPLE_STORAGE_S3_ACCESS_KEY  S3 access key.
+    EXAMPLE_STORAGE_S3_SECRET_KEY  S3 secret key.
+    EXAMPLE_UPLOADS_FOLDER       Folder for uploads in progress.
+    EXAMPLE_UPLOADS_EXPIRY       Idle time before resumable and multipart
+                                 uploads are aborted (default: "24h", 0 is
+                                 never).
+    EXAMPLE_VERSIONING_PREFIXES  Comma-separated path prefixes keeping past
+                                 versions of files.
+    EXAMPLE_TRASH_ENABLED        Move deleted files to the trash ("true" or
+                                 "false").
+    EXAMPLE_TRASH_RETENTION      Time in the trash before files are purged
+                                 (default: "720h", 0 is never).
+    EXAMPLE_RETENTION_ADMINS     Comma-separated users allowed to shorten
+                                 governance mode retention and place or
+                                 release legal holds.
+    EXAMPLE_TIERING_COLD_FOLDER  Folder for contents not downloaded in a
+                                 while (empty disables tiering).
+    EXAMPLE_BACKUP_ADMINS        Comma-separated users allowed to download
+                                 backups of the server.
+    EXAMPLE_BACKUP_SERVER        URL of the running server to back up
+                                 (default: "http://" and the bind address).
+    EXAMPLE_QUOTA_GLOBAL_MAX_BYTES    Total bytes stored (0 is unlimited).
+    EXAMPLE_QUOTA_GLOBAL_MAX_OBJECTS  Total files stored (0 is unlimited).
+    EXAMPLE_QUOTA_USER_MAX_BYTES      Bytes stored per user (0 is unlimited).
+    EXAMPLE_QUOTA_USER_MAX_OBJECTS    Files stored per user (0 is unlimited).
     EXAMPLE_EXAMPLE_BIND         Bind to IP address (examples: ":8080",
                                  "10.0.5.6:8080").
 
 MANAGEMENT COMMANDS
+    backup    Back up the running server to an archive.
+    db        Maintain the database. Server must be stopped!
     init      Creates an empty configuration file. Server must be stopped!
+    keys      Manage encryption keys. Server must be stopped!
+    restore   Restore an archive. Server must be stopped!
+    storage   Maintain stored contents. Server must be stopped!
+    trash     Restore or purge deleted files. Server must be stopped!
     user      Modify users. Server must be stopped!
 
 COMMANDS
@@ -46,14 +92,69 @@ FILES
 
         EXAMPLE
             database:
-              filename: example.db    // Path to the JSON database file.
+              driver: json            // "json", "bolt" or "sqlite".
+              filename: example.db    // Path to the database file.
             storage:
+              driver: filesystem      // Storage backend ("filesystem", "memory", "s3").
               folder: ./storage       // Path to the file storage folder.
+              folders: []             // Mirror copies across folders instead.
+              layout: path            // "path" mirrors file paths, "hashed" uses IDs.
+              deduplicate: false      // Store identical contents once.
+              compression:            // Compress at rest; first match wins.
+                - codec: zstd         // "gzip" or "zstd".
+                  prefixes: [/logs/]  // Optional path prefixes.
+                  content_types: []   // Optional types ("text/*" allowed).
+              encryption:
+                key_file: ""          // Master key file; enables encryption.
+              s3:
+                endpoint: ""          // S3-compatible endpoint URL.
+                region: us-east-1     // S3 signing region.
+                bucket: ""            // Bucket for storing files.
+                access_key: ""        // S3 access key.
+                secret_key: ""        // S3 secret key.
+            uploads:
+              folder: ./uploads       // Uploads in progress until complete.
+              expiry: 24h             // Abort idle uploads.
+            versioning:
+              prefixes: []            // Keep past versions under prefixes.
+            trash:
+              enabled: false          // Move deleted files to the trash.
+              retention: 720h         // Purge files trashed for longer.
+            lifecycle:                // Delete files once old enough.
+              - prefix: /builds/      // Path prefix of the files.
+                min_age: 336h         // Age since uploading.
+                content_types: []     // Optional types ("text/*" allowed).
+            retention:
+              admins: []              // Users shortening governance retention
+                                      // and placing legal holds.
+              rules:                  // Retain new uploads; first match wins.
+                - prefix: /records/   // Path prefix of the files.
+                  mode: compliance    // "governance" or "compliance".
+                  period: 8760h       // Time the files are retained.
+            tiering:
+              cold_folder: ""         // Folder for idle contents.
+              rules:                  // Move idle contents; first match wins.
+                - prefix: /reports/   // Path prefix of the files.
+                  cold_after: 720h    // Time since the last download.
+            backup:
+              admins: []              // Users allowed to download backups.
+              server: ""              // URL of the server to back up.
+            quota:                    // Zero is unlimited.
+              global:
+                max_bytes: 0          // Total bytes stored.
+                max_objects: 0        // Total files stored.
+              user:
+                max_bytes: 0          // Bytes stored by each uploader.
+                max_objects: 0        // Files stored by each uploader.
+              users: {}               // Limits replacing "user" by name.
             example:
               bind: ":8080"           // Bind to IP address.
 
     database (ex: example.db)
-        JSON formatted database file that stores users and file object metadata.
+        Database file that stores users and file object metadata, either as
+        JSON or as an embedded bbolt or SQLite database. The JSON database
+        keeps recent changes in a journal next to it (ex: example.db.journal)
+        replayed on start after a crash.
 `
 
 	// ExampleInit help documentation.
@@ -93,6 +194,8 @@ OPTIONS
     -h, --help       Print usage.
 
 ENVIRONMENT VARIABLES
+    EXAMPLE_DATABASE_DRIVER      Database driver ("json", "bolt" or
+                                 "sqlite").
     EXAMPLE_DATABASE_FILENAME    Location of database file.
 
 MANAGEMENT COMMANDS
@@ -119,8 +222,296 @@ OPTIONS
     -h, --help       Print usage.
 
 ENVIRONMENT VARIABLES
+    EXAMPLE_DATABASE_DRIVER      Database driver ("json", "bolt" or
+                                 "sqlite").
     EXAMPLE_DATABASE_FILENAME    Location of database file.
 
+COMMANDS
+    help      Print usage.
+`
+
+	// ExampleKeys help documentation.
+	ExampleKeys = `
+NAME
+    example [ OPTIONS ] keys
+
+USAGE
+    example keys COMMAND
+
+DESCRIPTION
+    Allows an administrator to manage the keys used to encrypt contents at rest.
+    Server must be stopped before running this command.
+
+OPTIONS
+    -c, --config     Location of configuration file (default: "").
+    -h, --help       Print usage.
+
+ENVIRONMENT VARIABLES
+    EXAMPLE_DATABASE_DRIVER      Database driver ("json", "bolt" or
+                                 "sqlite").
+    EXAMPLE_DATABASE_FILENAME    Location of database file.
+    EXAMPLE_STORAGE_ENCRYPTION_KEY_FILE  Current master key file.
+
+MANAGEMENT COMMANDS
+    rotate   Rewrap every data key under a new master key.
+
+COMMANDS
+    help      Print usage.
+`
+
+	// ExampleKeysRotate help documentation.
+	ExampleKeysRotate = `
+NAME
+    example [ OPTIONS ] keys rotate
+
+USAGE
+    example keys rotate [ new key file ]
+
+DESCRIPTION
+    Rewraps the data key of every encrypted file under the master key in the new
+    key file, which is created with a random key if it doesn't exist. Contents
+    are not rewritten. Afterwards, set "storage.encryption.key_file" to the new
+    key file. Running again after an interruption is safe. Server must be
+    stopped before running this command.
+
+OPTIONS
+    -c, --config     Location of configuration file (default: "").
+    -h, --help       Print usage.
+
+ENVIRONMENT VARIABLES
+    EXAMPLE_DATABASE_DRIVER      Database driver ("json", "bolt" or
+                                 "sqlite").
+    EXAMPLE_DATABASE_FILENAME    Location of database file.
+    EXAMPLE_STORAGE_ENCRYPTION_KEY_FILE  Current master key file.
+
+COMMANDS
+    help      Print usage.
+`
+
+	// ExampleStorage help documentation.
+	ExampleStorage = `
+NAME
+    example [ OPTIONS ] storage
+
+USAGE
+    example storage COMMAND
+
+DESCRIPTION
+    Allows an administrator to maintain the contents in storage. Server must be
+    stopped before running this command.
+
+OPTIONS
+    -c, --config     Location of configuration file (default: "").
+    -h, --help       Print usage.
+
+ENVIRONMENT VARIABLES
+    EXAMPLE_DATABASE_DRIVER      Database driver ("json", "bolt" or
+                                 "sqlite").
+    EXAMPLE_DATABASE_FILENAME    Location of database file.
+    EXAMPLE_STORAGE_DRIVER       Storage backend.
+    EXAMPLE_STORAGE_FOLDER       Folder for storing files.
+
+MANAGEMENT COMMANDS
+    check    Check storage against the database, optionally repairing it.
+    convert  Move contents stored at file paths to the hashed layout.
+
+COMMANDS
+    help      Print usage.
+`
+
+	// ExampleStorageCheck help documentation.
+	ExampleStorageCheck = `
+NAME
+    example [ OPTIONS ] storage check
+
+USAGE
+    example storage check [ --repair ]
+
+DESCRIPTION
+    Checks storage against the database and writes a JSON report listing:
+      orphans        Objects in storage that no file refers to.
+      missing        Files whose contents are missing from storage.
+      mismatched     Files whose contents don't match their size or checksum,
+                     or can't be read at all.
+      empty-folders  Folders holding nothing.
+    With "--repair", orphans and empty folders are removed and files with
+    missing contents are removed from the database. Mismatched and unreadable
+    contents are only reported, to be restored from a backup. Exits with an
+    error while any problem remains unresolved. Server must be stopped before
+    running this command, which refuses to run while the database is in use.
+
+OPTIONS
+    -c, --config     Location of configuration file (default: "").
+    -h, --help       Print usage.
+    --repair         Fix the problems found.
+
+ENVIRONMENT VARIABLES
+    EXAMPLE_DATABASE_DRIVER      Database driver ("json", "bolt" or
+                                 "sqlite").
+    EXAMPLE_DATABASE_FILENAME    Location of database file.
+    EXAMPLE_STORAGE_DRIVER       Storage backend.
+    EXAMPLE_STORAGE_FOLDER       Folder for storing files.
+
+COMMANDS
+    help      Print usage.
+`
+
+	// ExampleStorageConvert help documentation.
+	ExampleStorageConvert = `
+NAME
+    example [ OPTIONS ] storage convert
+
+USAGE
+    example storage convert
+
+DESCRIPTION
+    Moves the contents of every file stored at its path to a random ID under
+    hash-sharded folders (".objects/ab/cd/<id>"), leaving the path only in the
+    database. Afterwards, set "storage.layout" to "hashed" so new uploads follow.
+    Running again after an interruption is safe. Server must be stopped before
+    running this command.
+
+OPTIONS
+    -c, --config     Location of configuration file (default: "").
+    -h, --help       Print usage.
+
+ENVIRONMENT VARIABLES
+    EXAMPLE_DATABASE_DRIVER      Database driver ("json", "bolt" or
+                                 "sqlite").
+    EXAMPLE_DATABASE_FILENAME    Location of database file.
+    EXAMPLE_STORAGE_DRIVER       Storage backend.
+    EXAMPLE_STORAGE_FOLDER       Folder for storing files.
+
+COMMANDS
+    help      Print usage.
+`
+
+	// ExampleDB help documentation.
+	ExampleDB = `
+NAME
+    example [ OPTIONS ] db
+
+USAGE
+    example db migrate
+
+DESCRIPTION
+    Allows an administrator to maintain the database. "migrate" upgrades a
+    database written by an older version of example to the current schema,
+    copying its files next to it first (ex: example.db.backup-20060102T150405).
+    Older schemas are also migrated whenever the database is loaded, while the
+    server refuses to start with a schema newer than it supports. Server must
+    be stopped before running this command.
+
+OPTIONS
+    -c, --config     Location of configuration file (default: "").
+    -h, --help       Print usage.
+
+ENVIRONMENT VARIABLES
+    EXAMPLE_DATABASE_DRIVER      Database driver ("json", "bolt" or
+                                 "sqlite").
+    EXAMPLE_DATABASE_FILENAME    Location of database file.
+
+COMMANDS
+    help      Print usage.
+`
+
+	// ExampleBackup help documentation.
+	ExampleBackup = `
+NAME
+    example [ OPTIONS ] backup
+
+USAGE
+    example backup [ archive ]
+
+DESCRIPTION
+    Downloads a backup of the running server to a tar archive, compressed with
+    zstd when named "*.zst" (ex: example.tar.zst). The archive holds the
+    database and every stored object as they were when the backup started,
+    while uploads and deletions carry on, followed by a manifest of SHA-256
+    checksums. The archive is verified before being saved. The user must be
+    listed in "backup.admins". The username and password are read from the
+    environment, or prompted for when missing. Encrypted contents are kept
+    encrypted and need the same master key once restored.
+
+OPTIONS
+    -c, --config     Location of configuration file (default: "").
+    -h, --help       Print usage.
+
+ENVIRONMENT VARIABLES
+    EXAMPLE_BACKUP_USERNAME      User to download the backup as.
+    EXAMPLE_BACKUP_PASSWORD      Password of the user.
+    EXAMPLE_BACKUP_SERVER        URL of the running server, such as a TLS
+                                 proxy in front of it (example:
+                                 "https://files.example.com").
+    EXAMPLE_EXAMPLE_BIND         Address of the running server when no URL is
+                                 given (examples: ":8080", "10.0.5.6:8080").
+
+COMMANDS
+    help      Print usage.
+`
+
+	// ExampleRestore help documentation.
+	ExampleRestore = `
+NAME
+    example [ OPTIONS ] restore
+
+USAGE
+    example restore [ archive ]
+
+DESCRIPTION
+    Restores a backup made with "example backup". Every checksum in the archive
+    is verified before anything changes. The objects are written to storage,
+    then the database is replaced, keeping the existing one next to it (ex:
+    example.db.backup-20060102T150405). Run "example storage check --repair"
+    afterwards to remove contents the backup didn't know of. Server must be
+    stopped before running this command.
+
+OPTIONS
+    -c, --config     Location of configuration file (default: "").
+    -h, --help       Print usage.
+
+ENVIRONMENT VARIABLES
+    EXAMPLE_DATABASE_DRIVER      Database driver ("json", "bolt" or
+                                 "sqlite").
+    EXAMPLE_DATABASE_FILENAME    Location of database file.
+    EXAMPLE_STORAGE_DRIVER       Storage backend.
+    EXAMPLE_STORAGE_FOLDER       Folder for storing files.
+    EXAMPLE_TIERING_COLD_FOLDER  Folder for contents not downloaded in a
+                                 while (empty disables tiering).
+
+COMMANDS
+    help      Print usage.
+`
+
+	// ExampleTrash help documentation.
+	ExampleTrash = `
+NAME
+    example [ OPTIONS ] trash
+
+USAGE
+    example trash list
+    example trash restore [ id ]
+    example trash empty
+
+DESCRIPTION
+    Allows an administrator to manage the files deleted while "trash.enabled" is
+    set. "list" prints the ID, deletion time, deleting user and path of every
+    file in the trash, oldest first. "restore" moves a file back to the path it
+    was deleted from, unless a file has since been uploaded there. "empty"
+    deletes every file in the trash for good. Server must be stopped before
+    running this command.
+
+OPTIONS
+    -c, --config     Location of configuration file (default: "").
+    -h, --help       Print usage.
+
+ENVIRONMENT VARIABLES
+    EXAMPLE_DATABASE_DRIVER      Database driver ("json", "bolt" or
+                                 "sqlite").
+    EXAMPLE_DATABASE_FILENAME    Location of database file.
+    EXAMPLE_STORAGE_DRIVER       Storage backend.
+    EXAMPLE_STORAGE_FOLDER       Folder for storing files.
+
 COMMANDS
     help      Print usage.
 `
@@ -141,8 +532,48 @@ OPTIONS
     -h, --help       Print usage.
 
 ENVIRONMENT VARIABLES
+    EXAMPLE_DATABASE_DRIVER      Database driver ("json", "bolt" or
+                                 "sqlite").
     EXAMPLE_DATABASE_FILENAME    Location of database file.
+    EXAMPLE_STORAGE_DRIVER       Storage backend ("filesystem", "memory" or
+                                 "s3").
     EXAMPLE_STORAGE_FOLDER       Folder for storing files.
+    EXAMPLE_STORAGE_FOLDERS      Comma-separated folders, each holding a full
+                                 copy of every file (replaces folder).
+    EXAMPLE_STORAGE_LAYOUT       Where contents are kept ("path" or "hashed").
+    EXAMPLE_STORAGE_DEDUPLICATE  Store identical contents once ("true" or
+                                 "false").
+    EXAMPLE_STORAGE_ENCRYPTION_KEY_FILE  Master key file enabling encryption
+                                 at rest.
+    EXAMPLE_STORAGE_S3_ENDPOINT  S3-compatible endpoint (example:
+                                 "http://127.0.0.1:9000").
+    EXAMPLE_STORAGE_S3_REGION    S3 signing region (default: "us-east-1").
+    EXAMPLE_STORAGE_S3_BUCKET    S3 bucket for storing files.
+    EXAMPLE_STORAGE_S3_ACCESS_KEY  S3 access key.
+    EXAMPLE_STORAGE_S3_SECRET_KEY  S3 secret key.
+    EXAMPLE_UPLOADS_FOLDER       Folder for uploads in progress.
+    EXAMPLE_UPLOADS_EXPIRY       Idle time before resumable and multipart
+                                 uploads are aborted (default: "24h", 0 is
+                                 never).
+    EXAMPLE_VERSIONING_PREFIXES  Comma-separated path prefixes keeping past
+                                 versions of files.
+    EXAMPLE_TRASH_ENABLED        Move deleted files to the trash ("true" or
+                                 "false").
+    EXAMPLE_TRASH_RETENTION      Time in the trash before files are purged
+                                 (default: "720h", 0 is never).
+    EXAMPLE_RETENTION_ADMINS     Comma-separated users allowed to shorten
+                                 governance mode retention and place or
+                                 release legal holds.
+    EXAMPLE_TIERING_COLD_FOLDER  Folder for contents not downloaded in a
+                                 while (empty disables tiering).
+    EXAMPLE_BACKUP_ADMINS        Comma-separated users allowed to download
+                                 backups of the server.
+    EXAMPLE_BACKUP_SERVER        URL of the running server to back up
+                                 (default: "http://" and the bind address).
+    EXAMPLE_QUOTA_GLOBAL_MAX_BYTES    Total bytes stored (0 is unlimited).
+    EXAMPLE_QUOTA_GLOBAL_MAX_OBJECTS  Total files stored (0 is unlimited).
+    EXAMPLE_QUOTA_USER_MAX_BYTES      Bytes stored per user (0 is unlimited).
+    EXAMPLE_QUOTA_USER_MAX_OBJECTS    Files stored per user (0 is unlimited).
     EXAMPLE_EXAMPLE_BIND         Bind to IP address (examples: ":8080",
                                  "10.0.5.6:8080").
 
@@ -1,10 +1,18 @@
 package cli
 
 import (
+	"bufio"
+	"encoding/json"
 	"flag"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/exec"
 	"runtime"
 	"strings"
+	"time"
 
 	"github.com/halverneus/example/cli/help"
 	"github.com/halverneus/example/cli/initialize"
@@ -80,6 +88,60 @@ func checkForHelp(args Args) {
 	case args.Matches("user", "add", "help"):
 		exit.With(help.ExampleUserAdd)
 
+		// "example keys" help.
+	case args.Matches("keys") && config.Help:
+		fallthrough
+	case args.Matches("keys", "help"):
+		exit.With(help.ExampleKeys)
+
+		// "example keys rotate" help.
+	case args.Matches("keys", "rotate") && config.Help:
+		fallthrough
+	case args.Matches("keys", "rotate", "help"):
+		exit.With(help.ExampleKeysRotate)
+
+		// "example storage" help.
+	case args.Matches("storage") && config.Help:
+		fallthrough
+	case args.Matches("storage", "help"):
+		exit.With(help.ExampleStorage)
+
+		// "example storage convert" help.
+	case args.Matches("storage", "convert") && config.Help:
+		fallthrough
+	case args.Matches("storage", "convert", "help"):
+		exit.With(help.ExampleStorageConvert)
+
+		// "example storage check" help.
+	case args.Matches("storage", "check") && config.Help:
+		fallthrough
+	case args.Matches("storage", "check", "help"):
+		exit.With(help.ExampleStorageCheck)
+
+		// "example db" help.
+	case args.Matches("db") && config.Help:
+		fallthrough
+	case args.Matches("db", "help"):
+		exit.With(help.ExampleDB)
+
+		// "example backup" help.
+	case args.Matches("backup") && config.Help:
+		fallthrough
+	case args.Matches("backup", "help"):
+		exit.With(help.ExampleBackup)
+
+		// "example restore" help.
+	case args.Matches("restore") && config.Help:
+		fallthrough
+	case args.Matches("restore", "help"):
+		exit.With(help.ExampleRestore)
+
+		// "example trash" help.
+	case args.Matches("trash") && config.Help:
+		fallthrough
+	case args.Matches("trash", "help"):
+		exit.With(help.ExampleTrash)
+
 		// "example run" help
 	case args.Matches("run") && config.Help:
 		fallthrough
@@ -110,6 +172,102 @@ func executeCommand(args Args) (err error) {
 			args[passwordIndex],
 		)
 
+	case args.Matches("keys", "rotate", "*"):
+		const keyFileIndex = 2
+		err = withDB(
+			func(a ...string) (err error) {
+				var count int
+				if count, err = model.Keys.Rotate(a[0]); nil != err {
+					return
+				}
+				fmt.Printf("Rewrapped %d data keys under %s.\n", count, a[0])
+				fmt.Println(`Set "storage.encryption.key_file" to the new key file.`)
+				return
+			},
+			args[keyFileIndex],
+		)
+
+	case args.Matches("storage", "convert"):
+		err = withDB(
+			func(a ...string) (err error) {
+				var count int
+				if count, err = model.Maintenance.Convert(); nil != err {
+					return
+				}
+				fmt.Printf("Converted %d files to the hashed layout.\n", count)
+				fmt.Println(`Set "storage.layout" to "hashed" for new uploads.`)
+				return
+			},
+		)
+
+	case args.Matches("storage", "check"):
+		err = withDB(
+			func(a ...string) error { return checkStorage(false) },
+		)
+
+	case args.Matches("storage", "check", "--repair"):
+		err = withDB(
+			func(a ...string) error { return checkStorage(true) },
+		)
+
+	case args.Matches("db", "migrate"):
+		err = migrateDB()
+
+	case args.Matches("backup", "*"):
+		const archiveIndex = 1
+		err = backupServer(args[archiveIndex])
+
+	case args.Matches("restore", "*"):
+		const archiveIndex = 1
+		err = restoreBackup(args[archiveIndex])
+
+	case args.Matches("trash", "list"):
+		err = withDB(
+			func(a ...string) error {
+				for _, item := range model.Trash.List() {
+					fmt.Printf(
+						"%s  %s  %s  %s\n",
+						item.ID,
+						item.Deleted.Format(time.RFC3339),
+						item.DeletedBy,
+						item.File.Path,
+					)
+				}
+				return nil
+			},
+		)
+
+	case args.Matches("trash", "restore", "*"):
+		const idIndex = 2
+		err = withDB(
+			func(a ...string) (err error) {
+				var meta *model.FileMetadata
+				if meta, err = model.Trash.Restore(a[0]); nil != err {
+					return
+				}
+				fmt.Printf("Restored %s.\n", meta.Path)
+				return
+			},
+			args[idIndex],
+		)
+
+	case args.Matches("trash", "empty"):
+		err = withDB(
+			func(a ...string) (err error) {
+				// Purging releases contents through the deletion worker.
+				wg := model.Start()
+				defer wg.Wait()
+				defer database.Shutdown()
+
+				var count int
+				if count, err = model.Trash.Empty(); nil != err {
+					return
+				}
+				fmt.Printf("Purged %d files from the trash.\n", count)
+				return
+			},
+		)
+
 	case args.Matches("run"):
 		// Start the server.
 		err = withDB(
@@ -126,14 +284,198 @@ func executeCommand(args Args) (err error) {
 	return
 }
 
-// withDB loads the configuration file and the database file before calling the
-// handler with the desired variables and returning a consolidated error.
+// withDB loads the configuration file, the database file and the storage
+// backend before calling the handler with the desired variables and returning a
+// consolidated error.
 func withDB(handler func(a ...string) error, a ...string) (err error) {
 	if err = config.Load(config.ConfigPath); nil != err {
 		return
 	}
-	if err = database.Load(config.Get.Database.Filename); nil != err {
+	if err = database.Load(config.Get.Database.Driver, config.Get.Database.Filename); nil != err {
+		return
+	}
+	if err = model.Load(); nil != err {
 		return
 	}
 	return handler(a...)
 }
+
+// migrateDB to the current schema version, reporting each migration.
+func migrateDB() (err error) {
+	if err = config.Load(config.ConfigPath); nil != err {
+		return
+	}
+
+	var from int
+	var backup string
+	if from, backup, err = database.Migrate(
+		config.Get.Database.Driver,
+		config.Get.Database.Filename,
+		func(version int, description string) {
+			fmt.Printf("Migrating to schema version %d: %s.\n", version, description)
+		},
+	); nil != err {
+		return
+	}
+	if "" == backup {
+		fmt.Printf("Database is already at schema version %d.\n", from)
+		return
+	}
+	fmt.Printf("Migrated from schema version %d to %d.\n", from, database.SchemaVersion)
+	fmt.Printf("Kept a backup of the database at %s.\n", backup)
+	return
+}
+
+// backupServer to an archive downloaded from the running server, verified
+// before being moved into place. Archives named "*.zst" are compressed. The
+// credentials come from the environment, or are prompted for, so that they
+// never show up in the process list or shell history.
+func backupServer(archive string) (err error) {
+	if err = config.Load(config.ConfigPath); nil != err {
+		return
+	}
+
+	in := bufio.NewReader(os.Stdin)
+	user := os.Getenv("EXAMPLE_BACKUP_USERNAME")
+	if "" == user {
+		if user, err = prompt(in, "Username: ", false); nil != err {
+			return
+		}
+	}
+	password := os.Getenv("EXAMPLE_BACKUP_PASSWORD")
+	if "" == password {
+		if password, err = prompt(in, "Password: ", true); nil != err {
+			return
+		}
+	}
+
+	// Without a URL, the server is reached directly at the bind address,
+	// listening on every interface when no host is bound.
+	server := strings.TrimSuffix(config.Get.Backup.Server, "/")
+	if "" == server {
+		host := config.Get.Example.Bind
+		if strings.HasPrefix(host, ":") {
+			host = "127.0.0.1" + host
+		}
+		server = "http://" + host
+	}
+	url := server + "/api/latest/backup"
+	if strings.HasSuffix(archive, ".zst") {
+		url += "?zstd"
+	}
+	var req *http.Request
+	if req, err = http.NewRequest(http.MethodGet, url, nil); nil != err {
+		return
+	}
+	req.SetBasicAuth(user, password)
+	var resp *http.Response
+	if resp, err = http.DefaultClient.Do(req); nil != err {
+		return
+	}
+	defer resp.Body.Close()
+	if http.StatusOK != resp.StatusCode {
+		msg, _ := ioutil.ReadAll(resp.Body)
+		err = fmt.Errorf("backup failed with %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+		return
+	}
+
+	// Download next to the archive, which is only replaced once verified.
+	tmp := archive + ".tmp"
+	var f *os.File
+	if f, err = os.Create(tmp); nil != err {
+		return
+	}
+	defer os.Remove(tmp)
+	if _, err = io.Copy(f, resp.Body); nil == err {
+		err = f.Sync()
+	}
+	if errX := f.Close(); nil == err {
+		err = errX
+	}
+	if nil != err {
+		return
+	}
+
+	var manifest *model.BackupManifest
+	if manifest, err = model.Backup.Verify(tmp); nil != err {
+		return
+	}
+	if err = os.Rename(tmp, archive); nil != err {
+		return
+	}
+	fmt.Printf("Backed up the database and %d objects to %s.\n", len(manifest.Members)-1, archive)
+	return
+}
+
+// prompt for a line on the terminal, without echoing it when hidden.
+func prompt(in *bufio.Reader, label string, hidden bool) (line string, err error) {
+	fmt.Fprint(os.Stderr, label)
+	if info, errX := os.Stdin.Stat(); hidden && nil == errX && 0 != info.Mode()&os.ModeCharDevice {
+		if nil == stty("-echo") {
+			defer func() {
+				stty("echo")
+				fmt.Fprintln(os.Stderr)
+			}()
+		}
+	}
+	if line, err = in.ReadString('\n'); io.EOF == err && "" != line {
+		err = nil
+	}
+	line = strings.TrimRight(line, "\r\n")
+	return
+}
+
+// stty changes a setting of the terminal attached to standard input.
+func stty(setting string) error {
+	cmd := exec.Command("stty", setting)
+	cmd.Stdin = os.Stdin
+	return cmd.Run()
+}
+
+// restoreBackup from an archive, verified before anything changes. The server
+// must be stopped.
+func restoreBackup(archive string) (err error) {
+	if err = config.Load(config.ConfigPath); nil != err {
+		return
+	}
+	if err = model.Load(); nil != err {
+		return
+	}
+
+	var backup string
+	if backup, err = model.Backup.Restore(archive); nil != err {
+		return
+	}
+	fmt.Printf("Restored the database and contents from %s.\n", archive)
+	if "" != backup {
+		fmt.Printf("Kept the replaced database at %s.\n", backup)
+	}
+	fmt.Println(`Run "example storage check --repair" to remove contents the backup didn't know of.`)
+	return
+}
+
+// checkStorage against the database, writing the report to standard output as
+// JSON. Problems left unresolved are returned as an error.
+func checkStorage(repair bool) (err error) {
+	// Repairs remove files, which needs the deletion worker.
+	if repair {
+		wg := model.Start()
+		defer wg.Wait()
+		defer database.Shutdown()
+	}
+
+	var report *model.CheckReport
+	if report, err = model.Maintenance.Check(repair); nil != err {
+		return
+	}
+	var raw []byte
+	if raw, err = json.MarshalIndent(report, "", "  "); nil != err {
+		return
+	}
+	fmt.Println(string(raw))
+
+	if count := report.Unresolved(); 0 < count {
+		err = fmt.Errorf("storage check found %d unresolved problems", count)
+	}
+	return
+}
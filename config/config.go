@@ -3,6 +3,9 @@ package config
 import (
 	"io/ioutil"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"gopkg.in/yaml.v2"
 )
@@ -24,14 +27,110 @@ var (
 
 		// Database connection parameters.
 		Database struct {
+			Driver   string `yaml:"driver"`
 			Filename string `yaml:"filename"`
 		} `yaml:"database"`
 
 		// Storage for files.
 		Storage struct {
-			Folder string `yaml:"folder"`
+			Driver      string   `yaml:"driver"`
+			Folder      string   `yaml:"folder"`
+			Folders     []string `yaml:"folders"`
+			Layout      string   `yaml:"layout"`
+			Deduplicate bool     `yaml:"deduplicate"`
+
+			// Compression at rest. The first rule matching an upload picks the
+			// codec. Empty lists match everything.
+			Compression []struct {
+				Codec        string   `yaml:"codec"`
+				Prefixes     []string `yaml:"prefixes"`
+				ContentTypes []string `yaml:"content_types"`
+			} `yaml:"compression"`
+
+			// Encryption at rest. New uploads are encrypted when a master key
+			// file is set.
+			Encryption struct {
+				KeyFile string `yaml:"key_file"`
+			} `yaml:"encryption"`
+
+			// S3-compatible object store used by the "s3" driver.
+			S3 struct {
+				Endpoint  string `yaml:"endpoint"`
+				Region    string `yaml:"region"`
+				Bucket    string `yaml:"bucket"`
+				AccessKey string `yaml:"access_key"`
+				SecretKey string `yaml:"secret_key"`
+			} `yaml:"s3"`
 		} `yaml:"storage"`
 
+		// Uploads in progress, kept until complete. Resumable and multipart
+		// uploads left idle for longer than the expiry are aborted.
+		Uploads struct {
+			Folder string        `yaml:"folder"`
+			Expiry time.Duration `yaml:"expiry"`
+		} `yaml:"uploads"`
+
+		// Versioning keeps the previous versions of files under the prefixes
+		// when replaced or deleted.
+		Versioning struct {
+			Prefixes []string `yaml:"prefixes"`
+		} `yaml:"versioning"`
+
+		// Trash keeps deleted files until restored, emptied or kept for longer
+		// than the retention. Zero retention keeps them until emptied.
+		Trash struct {
+			Enabled   bool          `yaml:"enabled"`
+			Retention time.Duration `yaml:"retention"`
+		} `yaml:"trash"`
+
+		// Lifecycle rules deleting files under the prefix once older than the
+		// minimum age. An empty list of content types matches everything.
+		Lifecycle []struct {
+			Prefix       string        `yaml:"prefix"`
+			MinAge       time.Duration `yaml:"min_age"`
+			ContentTypes []string      `yaml:"content_types"`
+		} `yaml:"lifecycle"`
+
+		// Retention keeps new uploads under the prefix of the first matching
+		// rule from being replaced or deleted for the period. Admins may
+		// shorten or remove retention in governance mode and place or release
+		// legal holds.
+		Retention struct {
+			Admins []string `yaml:"admins"`
+			Rules  []struct {
+				Prefix string        `yaml:"prefix"`
+				Mode   string        `yaml:"mode"`
+				Period time.Duration `yaml:"period"`
+			} `yaml:"rules"`
+		} `yaml:"retention"`
+
+		// Tiering moves contents not downloaded for longer than the period of
+		// the first rule matching their path to the cold folder, and back once
+		// downloaded again. Disabled without a cold folder.
+		Tiering struct {
+			ColdFolder string `yaml:"cold_folder"`
+			Rules      []struct {
+				Prefix    string        `yaml:"prefix"`
+				ColdAfter time.Duration `yaml:"cold_after"`
+			} `yaml:"rules"`
+		} `yaml:"tiering"`
+
+		// Backup of the database and contents, which only the admins may
+		// download from the server. The "backup" command downloads from the
+		// server URL, which defaults to plain HTTP at the bind address.
+		Backup struct {
+			Admins []string `yaml:"admins"`
+			Server string   `yaml:"server"`
+		} `yaml:"backup"`
+
+		// Quota limits on stored files, overall and for each uploader. Zero is
+		// unlimited.
+		Quota struct {
+			Global Limit            `yaml:"global"`
+			User   Limit            `yaml:"user"`
+			Users  map[string]Limit `yaml:"users"`
+		} `yaml:"quota"`
+
 		// Example configuration values.
 		Example struct {
 			Bind string `yaml:"bind"`
@@ -39,10 +138,23 @@ var (
 	}
 )
 
+// Limit on the bytes and number of files stored. Zero is unlimited.
+type Limit struct {
+	MaxBytes   int64 `yaml:"max_bytes"`
+	MaxObjects int64 `yaml:"max_objects"`
+}
+
 func init() {
 	// Pre-populate with default values.
+	Get.Database.Driver = "json"
 	Get.Database.Filename = "example.db"
+	Get.Storage.Driver = "filesystem"
 	Get.Storage.Folder = "storage"
+	Get.Storage.Layout = "path"
+	Get.Storage.S3.Region = "us-east-1"
+	Get.Uploads.Folder = "uploads"
+	Get.Uploads.Expiry = 24 * time.Hour
+	Get.Trash.Retention = 30 * 24 * time.Hour
 	Get.Example.Bind = ":8080"
 }
 
@@ -90,8 +202,64 @@ func overrideWithEnvVars() {
 		return original
 	}
 
+	// resolveBool returns value of a "true"/"false" envvar if set.
+	resolveBool := func(key string, original bool) bool {
+		if value, err := strconv.ParseBool(os.Getenv(key)); nil == err {
+			return value
+		}
+		return original
+	}
+
+	// resolveInt returns value of an integer envvar if set.
+	resolveInt := func(key string, original int64) int64 {
+		if value, err := strconv.ParseInt(os.Getenv(key), 10, 64); nil == err {
+			return value
+		}
+		return original
+	}
+
+	// resolveDuration returns value of a duration envvar (ex: "24h") if set.
+	resolveDuration := func(key string, original time.Duration) time.Duration {
+		if value, err := time.ParseDuration(os.Getenv(key)); nil == err {
+			return value
+		}
+		return original
+	}
+
+	// resolveList returns the comma-separated values of an envvar if set.
+	resolveList := func(key string, original []string) []string {
+		if value := os.Getenv(key); "" != value {
+			return strings.Split(value, ",")
+		}
+		return original
+	}
+
 	// Assign envvars, if set.
+	Get.Database.Driver = resolve("EXAMPLE_DATABASE_DRIVER", Get.Database.Driver)
 	Get.Database.Filename = resolve("EXAMPLE_DATABASE_FILENAME", Get.Database.Filename)
+	Get.Storage.Driver = resolve("EXAMPLE_STORAGE_DRIVER", Get.Storage.Driver)
 	Get.Storage.Folder = resolve("EXAMPLE_STORAGE_FOLDER", Get.Storage.Folder)
+	Get.Storage.Folders = resolveList("EXAMPLE_STORAGE_FOLDERS", Get.Storage.Folders)
+	Get.Storage.Layout = resolve("EXAMPLE_STORAGE_LAYOUT", Get.Storage.Layout)
+	Get.Storage.Deduplicate = resolveBool("EXAMPLE_STORAGE_DEDUPLICATE", Get.Storage.Deduplicate)
+	Get.Storage.Encryption.KeyFile = resolve("EXAMPLE_STORAGE_ENCRYPTION_KEY_FILE", Get.Storage.Encryption.KeyFile)
+	Get.Storage.S3.Endpoint = resolve("EXAMPLE_STORAGE_S3_ENDPOINT", Get.Storage.S3.Endpoint)
+	Get.Storage.S3.Region = resolve("EXAMPLE_STORAGE_S3_REGION", Get.Storage.S3.Region)
+	Get.Storage.S3.Bucket = resolve("EXAMPLE_STORAGE_S3_BUCKET", Get.Storage.S3.Bucket)
+	Get.Storage.S3.AccessKey = resolve("EXAMPLE_STORAGE_S3_ACCESS_KEY", Get.Storage.S3.AccessKey)
+	Get.Storage.S3.SecretKey = resolve("EXAMPLE_STORAGE_S3_SECRET_KEY", Get.Storage.S3.SecretKey)
+	Get.Uploads.Folder = resolve("EXAMPLE_UPLOADS_FOLDER", Get.Uploads.Folder)
+	Get.Uploads.Expiry = resolveDuration("EXAMPLE_UPLOADS_EXPIRY", Get.Uploads.Expiry)
+	Get.Versioning.Prefixes = resolveList("EXAMPLE_VERSIONING_PREFIXES", Get.Versioning.Prefixes)
+	Get.Trash.Enabled = resolveBool("EXAMPLE_TRASH_ENABLED", Get.Trash.Enabled)
+	Get.Trash.Retention = resolveDuration("EXAMPLE_TRASH_RETENTION", Get.Trash.Retention)
+	Get.Retention.Admins = resolveList("EXAMPLE_RETENTION_ADMINS", Get.Retention.Admins)
+	Get.Tiering.ColdFolder = resolve("EXAMPLE_TIERING_COLD_FOLDER", Get.Tiering.ColdFolder)
+	Get.Backup.Admins = resolveList("EXAMPLE_BACKUP_ADMINS", Get.Backup.Admins)
+	Get.Backup.Server = resolve("EXAMPLE_BACKUP_SERVER", Get.Backup.Server)
+	Get.Quota.Global.MaxBytes = resolveInt("EXAMPLE_QUOTA_GLOBAL_MAX_BYTES", Get.Quota.Global.MaxBytes)
+	Get.Quota.Global.MaxObjects = resolveInt("EXAMPLE_QUOTA_GLOBAL_MAX_OBJECTS", Get.Quota.Global.MaxObjects)
+	Get.Quota.User.MaxBytes = resolveInt("EXAMPLE_QUOTA_USER_MAX_BYTES", Get.Quota.User.MaxBytes)
+	Get.Quota.User.MaxObjects = resolveInt("EXAMPLE_QUOTA_USER_MAX_OBJECTS", Get.Quota.User.MaxObjects)
 	Get.Example.Bind = resolve("EXAMPLE_EXAMPLE_BIND", Get.Example.Bind)
 }
@@ -0,0 +1,114 @@
+package database
+
+import (
+	"encoding/binary"
+	"strconv"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	// boltMetaBucket holds the schema version.
+	boltMetaBucket = "meta"
+)
+
+// boltStore keeps records in an embedded bbolt file, one bucket each, keyed by
+// big-endian ID so they are read back in order.
+type boltStore struct {
+	db *bolt.DB
+}
+
+// openBolt file, creating the buckets when missing.
+func openBolt(filename string) (s *boltStore, err error) {
+	var db *bolt.DB
+	if db, err = bolt.Open(filename, 0666, &bolt.Options{Timeout: time.Second}); nil != err {
+		if bolt.ErrTimeout == err {
+			err = ErrInUse
+		}
+		return
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, bucket := range []string{usersBucket, filesBucket, versionsBucket, trashBucket, boltMetaBucket} {
+			if _, err := tx.CreateBucketIfNotExists([]byte(bucket)); nil != err {
+				return err
+			}
+		}
+		return nil
+	})
+	if nil != err {
+		db.Close()
+		return
+	}
+	s = &boltStore{db: db}
+	return
+}
+
+// each record in the bucket, in order.
+func (s *boltStore) each(bucket string, fn func(id uint64, value []byte) error) error {
+	return s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(bucket)).ForEach(func(k, v []byte) error {
+			return fn(binary.BigEndian.Uint64(k), v)
+		})
+	})
+}
+
+// commit the writes in one transaction.
+func (s *boltStore) commit(writes []*write) (err error) {
+	ids := make([]uint64, len(writes))
+	err = s.db.Update(func(tx *bolt.Tx) (err error) {
+		for i, w := range writes {
+			b := tx.Bucket([]byte(w.bucket))
+			if ids[i] = w.id; nil == w.value {
+				err = b.Delete(boltKey(w.id))
+			} else {
+				if 0 == w.id {
+					if ids[i], err = b.NextSequence(); nil != err {
+						return
+					}
+				}
+				err = b.Put(boltKey(ids[i]), w.value)
+			}
+			if nil != err {
+				return
+			}
+		}
+		return
+	})
+	if nil == err {
+		for i, w := range writes {
+			w.id = ids[i]
+		}
+	}
+	return
+}
+
+// schema version kept in the meta bucket.
+func (s *boltStore) schema() (version int, err error) {
+	err = s.db.View(func(tx *bolt.Tx) (err error) {
+		if value := tx.Bucket([]byte(boltMetaBucket)).Get([]byte("schema")); nil != value {
+			version, err = strconv.Atoi(string(value))
+		}
+		return
+	})
+	return
+}
+
+// setSchema version in the meta bucket.
+func (s *boltStore) setSchema(version int) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(boltMetaBucket)).Put([]byte("schema"), []byte(strconv.Itoa(version)))
+	})
+}
+
+// close the file.
+func (s *boltStore) close() error {
+	return s.db.Close()
+}
+
+// boltKey of a record ID.
+func boltKey(id uint64) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, id)
+	return key
+}
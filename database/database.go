@@ -1,9 +1,6 @@
 package database
 
 import (
-	"encoding/json"
-	"fmt"
-	"io/ioutil"
 	"sync"
 )
 
@@ -17,52 +14,59 @@ var (
 
 		// Files stored on the system.
 		Files []*File `json:"files"`
-	}
 
-	// dbFilename is the last loaded database.
-	dbFilename string
+		// Versions of files replaced or deleted while versioning, and delete
+		// markers, oldest first.
+		Versions []*File `json:"versions"`
+
+		// Trash holding deleted files until restored or purged, oldest first.
+		Trash []*TrashItem `json:"trash"`
+	}
 )
 
-// load the database from disk.
-func load(filename string) (err error) {
+// load the database from the store of the driver at the filename.
+func load(driver, filename string) (err error) {
 	getMtx.Lock()
 	defer getMtx.Unlock()
 
-	// Read contents from configuration file.
-	var contents []byte
-	if contents, err = ioutil.ReadFile(filename); nil != err {
-		dbFilename = filename // Write to database failed. Try saving.
-		if errX := save(); nil != errX {
-			err = fmt.Errorf("Multiple errors: %v & %v", err, errX)
-			return
-		}
-		// Was able to perform initial write to database file. Retry read.
-		if contents, err = ioutil.ReadFile(filename); nil != err {
-			return
-		}
+	// Close the previously loaded database first, as stores lock their file.
+	if nil != db {
+		db.close()
+		db = nil
 	}
 
-	// Parse contents into 'Get' database.
-	if err = json.Unmarshal(contents, &get); nil != err {
+	var s store
+	if s, err = openStore(driver, filename); nil != err {
 		return
 	}
 
-	// Assign successfully parsed filename.
-	dbFilename = filename
-
-	// Populate index.
-	refreshIndex()
-	return
-}
+	// Bring the schema up to date before reading anything.
+	if err = checkSchema(s, filename); nil != err {
+		s.close()
+		return
+	}
 
-// save the database to disk.
-func save() (err error) {
-	// Read contents from structure into slice.
-	var contents []byte
-	if contents, err = json.Marshal(&get); nil != err {
+	// Read every record from the store.
+	var users []*user
+	var files, versions []*File
+	var items []*TrashItem
+	if users, err = readUsers(s); nil == err {
+		if files, err = readFiles(s, filesBucket); nil == err {
+			if versions, err = readFiles(s, versionsBucket); nil == err {
+				items, err = readTrash(s)
+			}
+		}
+	}
+	if nil != err {
+		s.close()
 		return
 	}
 
-	// Save the contents to disk.
-	return ioutil.WriteFile(dbFilename, contents, 0666)
+	db = s
+	get.Users, get.Files, get.Versions, get.Trash = users, files, versions, items
+	pending, pendingAt = nil, map[interface{}]int{}
+
+	// Populate index.
+	refreshIndex()
+	return
 }
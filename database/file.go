@@ -2,16 +2,64 @@ package database
 
 import (
 	"errors"
+	"fmt"
 	"sync"
+	"time"
 )
 
 // File stored on the system.
 type File struct {
-	Path        string `json:"path"`
-	ContentType string `json:"content-type"`
-	Uploader    string `json:"uploader"`
-	Created     string `json:"created"`
-	mtx         sync.RWMutex
+	Path        string    `json:"path"`
+	ContentType string    `json:"content-type"`
+	Uploader    string    `json:"uploader"`
+	Created     string    `json:"created"`
+	Modified    time.Time `json:"modified"`
+	Size        int64     `json:"size"`
+	SHA256      string    `json:"sha256,omitempty"`
+	MD5         string    `json:"md5,omitempty"`
+	Encoding    string    `json:"encoding,omitempty"`
+	Key         string    `json:"key,omitempty"`
+	WrappedKey  string    `json:"wrapped-key,omitempty"`
+	KeyID       string    `json:"key-id,omitempty"`
+
+	// VersionID of the file, empty when uploaded without versioning. Delete
+	// markers are versions recording a deletion and have no contents.
+	VersionID    string `json:"version-id,omitempty"`
+	DeleteMarker bool   `json:"delete-marker,omitempty"`
+
+	// Retention and legal hold keep the file from being replaced or deleted.
+	RetentionMode string    `json:"retention-mode,omitempty"`
+	RetainUntil   time.Time `json:"retain-until"`
+	LegalHold     bool      `json:"legal-hold,omitempty"`
+
+	// Tier holding the contents, shared by every file with the same storage
+	// key, and when the file was last downloaded.
+	Tier     string    `json:"tier,omitempty"`
+	Accessed time.Time `json:"accessed"`
+
+	mtx    sync.RWMutex
+	stored location
+}
+
+// Usage of storage by files.
+type Usage struct {
+	Bytes   int64 `json:"bytes"`
+	Objects int64 `json:"objects"`
+}
+
+// add a file to the usage, or remove it when sign is negative.
+func (u *Usage) add(f *File, sign int64) {
+	u.Bytes += sign * f.Size
+	u.Objects += sign
+}
+
+// StorageKey where the contents are kept. Files without a key are stored at
+// their path.
+func (f *File) StorageKey() string {
+	if "" != f.Key {
+		return f.Key
+	}
+	return f.Path
 }
 
 // Wait for all downloaders to complete before deleting from file system.
@@ -31,23 +79,59 @@ func (f *File) Deleted() {
 
 // addFile information to the database.
 func addFile(meta *File) (err error) {
+	var replaced *File
+	replaced, err = addFileHolding(meta)
+	releaseFile(replaced)
+	return
+}
+
+// addFileHolding information to the database. If a file exists, it is
+// overwritten. The replaced file becomes part of the history when the new file
+// is a version of it, and is otherwise returned for the caller to release once
+// any downloads complete, or to bring back with revertFile.
+func addFileHolding(meta *File) (replaced *File, err error) {
 	getMtx.Lock()
 	defer getMtx.Unlock()
 
-	// If file exists, overwrite.
 	var orig *File
 	if orig, err = getFileFromIndex(meta.Path); nil == err {
+		if orig.Locked() {
+			err = ErrLocked
+			return
+		}
+
 		// File exists. Replace.
-		if !replaceFile(orig, meta) {
-			refreshIndex()
+		if replaceFile(orig, meta) {
+			removeFileFromIndex(orig)
+			addFileToIndex(meta)
+			if "" != meta.VersionID {
+				addVersion(orig)
+			} else {
+				replaced = orig
+			}
+			changed(orig, meta)
+			err = save()
+			return
 		}
+		refreshIndex()
 	}
+	err = nil
 
 	// File doesn't exist. Add file.
 	get.Files = append(get.Files, meta)
 	addFileToIndex(meta)
+	changed(meta)
 
-	return save()
+	err = save()
+	return
+}
+
+// releaseFile replaced by another, deleting its contents once any downloads
+// complete. Nothing happens for a nil file.
+func releaseFile(f *File) {
+	if nil != f {
+		queueDeletion(f)
+	}
 }
 
 // replaceFile with a new file.
@@ -62,6 +146,38 @@ func replaceFile(origFile, newFile *File) bool {
 	return false
 }
 
+// revertFile added to the database whose contents never made it to storage,
+// taking it out again and bringing back the file it replaced, if any, as
+// returned by addFileHolding. Nothing changes once the added file has been
+// replaced or deleted in turn, except that the replaced file is released.
+func revertFile(added, replaced *File) (err error) {
+	getMtx.Lock()
+	defer getMtx.Unlock()
+
+	if files[added.Path] != added {
+		releaseFile(replaced)
+		return
+	}
+	get.Files = removeFromList(get.Files, added)
+	removeFileFromIndex(added)
+	changed(added)
+
+	// A version replaced by the added file is the latest in the history.
+	if nil == replaced && "" != added.VersionID {
+		if versions := history[added.Path]; 0 < len(versions) && !versions[len(versions)-1].DeleteMarker {
+			replaced = versions[len(versions)-1]
+			get.Versions = removeFromList(get.Versions, replaced)
+			removeVersionFromIndex(replaced)
+		}
+	}
+	if nil != replaced {
+		get.Files = append(get.Files, replaced)
+		addFileToIndex(replaced)
+		changed(replaced)
+	}
+	return save()
+}
+
 // getMetadata that is safe to return.
 func getMetadata(filePath string) (file *File, err error) {
 	getMtx.RLock()
@@ -74,12 +190,111 @@ func getMetadata(filePath string) (file *File, err error) {
 	}
 
 	// Copy file object to prevent risk of race conditions.
-	file = &File{
+	file = copyFile(f)
+	return
+}
+
+// getMetadataByKey of any file with contents at the storage key.
+func getMetadataByKey(key string) (file *File, err error) {
+	getMtx.RLock()
+	defer getMtx.RUnlock()
+
+	if sharing := stored[key]; 0 < len(sharing) {
+		file = copyFile(sharing[0])
+		return
+	}
+	err = fmt.Errorf("no file stored at key %s", key)
+	return
+}
+
+// listFiles returns a copy of the metadata of every file.
+func listFiles() (list []*File) {
+	getMtx.RLock()
+	defer getMtx.RUnlock()
+
+	list = make([]*File, len(get.Files))
+	for i, f := range get.Files {
+		list[i] = copyFile(f)
+	}
+	return
+}
+
+// setStorageKeys of files still stored at their path, mapped by path. Every
+// version sharing the contents at the path moves with it.
+func setStorageKeys(keys map[string]string) (err error) {
+	getMtx.Lock()
+	defer getMtx.Unlock()
+
+	for _, f := range allFiles() {
+		if key, found := keys[f.Path]; found && "" == f.Key && !f.DeleteMarker {
+			f.Key = key
+			changed(f)
+		}
+	}
+	refreshIndex()
+	return save()
+}
+
+// copyFile metadata without the download lock.
+func copyFile(f *File) *File {
+	return &File{
 		Path:        f.Path,
 		ContentType: f.ContentType,
 		Uploader:    f.Uploader,
 		Created:     f.Created,
+		Modified:    f.Modified,
+		Size:        f.Size,
+		SHA256:      f.SHA256,
+		MD5:         f.MD5,
+		Encoding:    f.Encoding,
+		Key:         f.Key,
+		WrappedKey:  f.WrappedKey,
+		KeyID:       f.KeyID,
+
+		VersionID:    f.VersionID,
+		DeleteMarker: f.DeleteMarker,
+
+		RetentionMode: f.RetentionMode,
+		RetainUntil:   f.RetainUntil,
+		LegalHold:     f.LegalHold,
+
+		Tier:     f.Tier,
+		Accessed: f.Accessed,
+	}
+}
+
+// rewrapKeys of every encrypted file. Nothing changes unless every key is
+// rewrapped successfully.
+func rewrapKeys(
+	rewrap func(keyID, wrapped string) (newKeyID, newWrapped string, err error),
+) (count int, err error) {
+	getMtx.Lock()
+	defer getMtx.Unlock()
+
+	// Rewrap everything before touching any file.
+	type result struct {
+		f              *File
+		keyID, wrapped string
+	}
+	results := []result{}
+	for _, f := range allFiles() {
+		if "" == f.WrappedKey {
+			continue
+		}
+		r := result{f: f}
+		if r.keyID, r.wrapped, err = rewrap(f.KeyID, f.WrappedKey); nil != err {
+			err = fmt.Errorf("unable to rewrap key of %s: %v", f.Path, err)
+			return
+		}
+		results = append(results, r)
 	}
+
+	for _, r := range results {
+		r.f.KeyID, r.f.WrappedKey = r.keyID, r.wrapped
+		changed(r.f)
+	}
+	count = len(results)
+	err = save()
 	return
 }
 
@@ -109,45 +324,79 @@ func removeFile(filePath string) (err error) {
 		return
 	}
 
-	// Find index of file.
-	index := 0
-	found := false
-	for i, fil := range get.Files {
-		if f == fil {
-			index = i
-			found = true
-			break
-		}
-	}
-
 	// Found in index, but not in database.
-	if !found {
+	if indexOf(get.Files, f) < 0 {
 		refreshIndex()
 		return
 	}
 
-	// By locking here, assures deletion completes before channel closes.
-	fileDeletionMtx.RLock()
-	if fileDeletionClosed {
-		// Channel is closed and program is exiting.
-		fileDeletionMtx.RUnlock()
+	if f.Locked() {
+		err = ErrLocked
+		return
+	}
+
+	// Refuse once the deletion channel has closed and program is exiting.
+	if !queueDeletion(f) {
 		err = errors.New("application is shutting down")
 		return
 	}
+	get.Files = removeFromList(get.Files, f)
+
+	// Update index.
+	removeFileFromIndex(f)
+	changed(f)
+
+	return save()
+}
+
+// indexOf a file in a list, or -1 when missing.
+func indexOf(list []*File, f *File) int {
+	for i, fil := range list {
+		if f == fil {
+			return i
+		}
+	}
+	return -1
+}
+
+// removeFromList the file, keeping the order of the remaining files.
+func removeFromList(list []*File, f *File) []*File {
+	index := indexOf(list, f)
+	if index < 0 {
+		return list
+	}
 
 	// Memory-leak-safe implementation for removing an item from a list.
-	copy(get.Files[index:], get.Files[index+1:]) // Shift left to remove file.
-	get.Files[len(get.Files)-1] = nil            // Garbage collect the trailing item.
-	get.Files = get.Files[:len(get.Files)-1]     // Slice the tail from the slice.
+	copy(list[index:], list[index+1:]) // Shift left to remove file.
+	list[len(list)-1] = nil            // Garbage collect the trailing item.
+	return list[:len(list)-1]          // Slice the tail from the slice.
+}
 
-	// Update index.
-	removeFileFromIndex(filePath)
+// allFiles in the database, current versions first, followed by the history
+// and the trash.
+func allFiles() []*File {
+	all := make([]*File, 0, len(get.Files)+len(get.Versions)+len(get.Trash))
+	all = append(append(all, get.Files...), get.Versions...)
+	for _, item := range get.Trash {
+		all = append(all, item.File)
+	}
+	return all
+}
+
+// queueDeletion of a file's contents once all downloaders are done. Returns
+// false when the application is shutting down and nothing was queued.
+func queueDeletion(f *File) bool {
+	// By locking here, assures deletion completes before channel closes.
+	fileDeletionMtx.RLock()
+	if fileDeletionClosed {
+		fileDeletionMtx.RUnlock()
+		return false
+	}
 
 	go func() {
 		f.Wait()
 		FileDeletionChan <- f
 		fileDeletionMtx.RUnlock() // Free FileDeletionChan for closing.
 	}()
-
-	return save()
+	return true
 }
@@ -8,6 +8,25 @@ import (
 var (
 	users map[string]*user
 	files map[string]*File
+
+	// paths of the files in order, for listing under a prefix.
+	paths *pathTree
+
+	// stored files sharing each storage key, leaving out delete markers.
+	stored map[string][]*File
+
+	// history of each path, oldest first, leaving out the current version.
+	history map[string][]*File
+
+	// trash items by ID, the number of items deleted from each path, and the
+	// item holding each file in the trash.
+	trash   map[string]*TrashItem
+	trashed map[string]int
+	trashOf map[*File]*TrashItem
+
+	// usage of storage by each uploader and overall.
+	usage      map[string]*Usage
+	totalUsage Usage
 )
 
 // refreshIndex used for quick access.
@@ -23,8 +42,22 @@ func refreshIndex() {
 
 	// Refresh files.
 	files = map[string]*File{}
+	paths = &pathTree{}
+	stored = map[string][]*File{}
+	history = map[string][]*File{}
+	trash = map[string]*TrashItem{}
+	trashed = map[string]int{}
+	trashOf = map[*File]*TrashItem{}
+	usage = map[string]*Usage{}
+	totalUsage = Usage{}
 	for _, f := range get.Files {
-		files[f.Path] = f
+		addFileToIndex(f)
+	}
+	for _, f := range get.Versions {
+		addVersionToIndex(f)
+	}
+	for _, item := range get.Trash {
+		addTrashToIndex(item)
 	}
 }
 
@@ -60,11 +93,127 @@ func updateUserPasswordInIndex(name, password string) (err error) {
 // addFileToIndex for a new upload.
 func addFileToIndex(f *File) {
 	files[f.Path] = f
+	paths.set(f)
+	addContentsToIndex(f)
+}
+
+// removeFileFromIndex for a delete or replacement.
+func removeFileFromIndex(f *File) {
+	if files[f.Path] == f {
+		delete(files, f.Path)
+		paths.remove(f.Path)
+	}
+	removeContentsFromIndex(f)
+}
+
+// addVersionToIndex for a file added to the history of its path.
+func addVersionToIndex(f *File) {
+	history[f.Path] = append(history[f.Path], f)
+	if !f.DeleteMarker {
+		addContentsToIndex(f)
+	}
+}
+
+// removeVersionFromIndex for a version deleted or made current again.
+func removeVersionFromIndex(f *File) {
+	if versions := removeFromList(history[f.Path], f); 0 < len(versions) {
+		history[f.Path] = versions
+	} else {
+		delete(history, f.Path)
+	}
+	if !f.DeleteMarker {
+		removeContentsFromIndex(f)
+	}
+}
+
+// addTrashToIndex for a file moved to the trash. The contents still count.
+func addTrashToIndex(item *TrashItem) {
+	trash[item.ID] = item
+	trashed[item.File.Path]++
+	trashOf[item.File] = item
+	addContentsToIndex(item.File)
+}
+
+// removeTrashFromIndex for an item restored or purged.
+func removeTrashFromIndex(item *TrashItem) {
+	delete(trash, item.ID)
+	delete(trashOf, item.File)
+	if trashed[item.File.Path]--; 0 >= trashed[item.File.Path] {
+		delete(trashed, item.File.Path)
+	}
+	removeContentsFromIndex(item.File)
 }
 
-// removeFileFromIndex for a delete.
-func removeFileFromIndex(filePath string) {
-	delete(files, filePath)
+// getTrashFromIndex for restoring or purging.
+func getTrashFromIndex(id string) (item *TrashItem, err error) {
+	found := false
+	if item, found = trash[id]; !found {
+		err = ErrTrashNotFound
+	}
+	return
+}
+
+// addContentsToIndex, adding the file to those at its storage key and counting
+// the usage.
+func addContentsToIndex(f *File) {
+	key := f.StorageKey()
+	stored[key] = append(stored[key], f)
+
+	u, found := usage[f.Uploader]
+	if !found {
+		u = &Usage{}
+		usage[f.Uploader] = u
+	}
+	u.add(f, 1)
+	totalUsage.add(f, 1)
+}
+
+// removeContentsFromIndex, taking the file out of those at its storage key and
+// no longer counting the usage.
+func removeContentsFromIndex(f *File) {
+	key := f.StorageKey()
+	if sharing := removeFromList(stored[key], f); 0 < len(sharing) {
+		stored[key] = sharing
+	} else {
+		delete(stored, key)
+	}
+
+	if u, found := usage[f.Uploader]; found {
+		if u.add(f, -1); 0 >= u.Objects {
+			delete(usage, f.Uploader)
+		}
+	}
+	totalUsage.add(f, -1)
+}
+
+// countReferences to a storage key.
+func countReferences(key string) int {
+	return len(stored[key])
+}
+
+// getUsage of an uploader and overall.
+func getUsage(uploader string) (user, total Usage) {
+	if u, found := usage[uploader]; found {
+		user = *u
+	}
+	total = totalUsage
+	return
+}
+
+// getVersionFromIndex, which is either the current version or one in the
+// history of the path.
+func getVersionFromIndex(filePath, versionID string) (f *File, err error) {
+	if f, err = getFileFromIndex(filePath); nil == err && f.IsVersion(versionID) {
+		return
+	}
+	for _, f = range history[filePath] {
+		if f.IsVersion(versionID) {
+			err = nil
+			return
+		}
+	}
+	f, err = nil, fmt.Errorf("version %s of %s not found", versionID, filePath)
+	return
 }
 
 // getFileFromIndex for metadata.
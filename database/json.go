@@ -0,0 +1,362 @@
+package database
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"syscall"
+)
+
+const (
+	// jsonCompactAfter commits appended to the journal before its records are
+	// compacted into a new snapshot.
+	jsonCompactAfter = 1000
+)
+
+// jsonStore keeps every record in a JSON snapshot file. Commits are appended to
+// a journal next to it and synced before returning, and the journal is
+// compacted into a new snapshot every so often. The snapshot is only ever
+// replaced by renaming a complete copy over it, so a crash leaves either the
+// old or the new snapshot, and the journal replays whatever came after.
+type jsonStore struct {
+	filename string
+	buckets  map[string]map[uint64]json.RawMessage
+	next     uint64
+	version  int
+
+	// journal open for appending, its size and the number of commits in it.
+	journal   *os.File
+	size      int64
+	journaled int
+}
+
+// jsonSnapshot of every record. Record IDs are kept apart from the records,
+// which snapshots written before the journal existed didn't keep, so they are
+// numbered in order when missing.
+type jsonSnapshot struct {
+	Users    []json.RawMessage   `json:"users"`
+	Files    []json.RawMessage   `json:"files"`
+	Versions []json.RawMessage   `json:"versions"`
+	Trash    []json.RawMessage   `json:"trash"`
+	IDs      map[string][]uint64 `json:"ids,omitempty"`
+	Next     uint64              `json:"next,omitempty"`
+	Schema   int                 `json:"schema,omitempty"`
+}
+
+// jsonEntry in the journal, written as a single line for each commit.
+type jsonEntry struct {
+	Writes []jsonWrite `json:"writes"`
+}
+
+// jsonWrite of a record in a journal entry. A null value deletes the record.
+type jsonWrite struct {
+	Bucket string          `json:"bucket"`
+	ID     uint64          `json:"id"`
+	Value  json.RawMessage `json:"value"`
+}
+
+// openJSON snapshot, replaying the journal left by an unclean shutdown. An empty
+// snapshot is created when missing.
+func openJSON(filename string) (s *jsonStore, err error) {
+	s = &jsonStore{filename: filename, buckets: map[string]map[uint64]json.RawMessage{}}
+	for _, bucket := range []string{usersBucket, filesBucket, versionsBucket, trashBucket} {
+		s.buckets[bucket] = map[uint64]json.RawMessage{}
+	}
+
+	// The journal is locked while open, keeping other processes out. It is
+	// only ever truncated, never replaced, so the lock holds throughout.
+	if s.journal, err = os.OpenFile(s.journalName(), os.O_CREATE|os.O_WRONLY, 0666); nil != err {
+		return
+	}
+	if err = syscall.Flock(int(s.journal.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); nil != err {
+		s.journal.Close()
+		if syscall.EWOULDBLOCK == err {
+			err = ErrInUse
+		}
+		return
+	}
+	defer func() {
+		if nil != err {
+			s.journal.Close()
+		}
+	}()
+
+	// A leftover temporary snapshot was never renamed into place.
+	os.Remove(filename + ".tmp")
+
+	if err = s.readSnapshot(); nil != err {
+		return
+	}
+	if err = s.replay(); nil != err {
+		return
+	}
+	if 0 < s.journaled {
+		log.Printf("Replayed %d journaled changes to %s.\n", s.journaled, filename)
+	}
+
+	// Start over with a snapshot of everything and an empty journal, unless
+	// written with another schema. Newer ones would lose what this version
+	// can't read, and older ones are left alone until migrated.
+	if SchemaVersion != s.version {
+		return
+	}
+	err = s.compact()
+	return
+}
+
+// readSnapshot of every record, if any.
+func (s *jsonStore) readSnapshot() (err error) {
+	var contents []byte
+	if contents, err = ioutil.ReadFile(s.filename); os.IsNotExist(err) {
+		return nil
+	} else if nil != err {
+		return
+	}
+
+	snapshot := &jsonSnapshot{}
+	if err = json.Unmarshal(contents, snapshot); nil != err {
+		return fmt.Errorf("unable to read database %s: %v", s.filename, err)
+	}
+	for _, list := range []struct {
+		bucket  string
+		records []json.RawMessage
+	}{
+		{usersBucket, snapshot.Users},
+		{filesBucket, snapshot.Files},
+		{versionsBucket, snapshot.Versions},
+		{trashBucket, snapshot.Trash},
+	} {
+		ids := snapshot.IDs[list.bucket]
+		for i, value := range list.records {
+			id := s.next + 1
+			if i < len(ids) {
+				id = ids[i]
+			}
+			s.buckets[list.bucket][id] = value
+			if s.next < id {
+				s.next = id
+			}
+		}
+	}
+	if s.next < snapshot.Next {
+		s.next = snapshot.Next
+	}
+	s.version = snapshot.Schema
+	return
+}
+
+// replay the journal onto the snapshot. Every entry is complete unless the
+// crash happened while appending it, so only a torn last line is skipped, and
+// later overwritten. Entries already compacted into the snapshot replay to the
+// same records.
+func (s *jsonStore) replay() (err error) {
+	var contents []byte
+	if contents, err = ioutil.ReadFile(s.journalName()); os.IsNotExist(err) {
+		return nil
+	} else if nil != err {
+		return
+	}
+
+	// The last line is empty unless the crash happened while appending it.
+	lines := bytes.Split(contents, []byte("\n"))
+	if torn := lines[len(lines)-1]; 0 < len(torn) {
+		log.Printf("Skipping incomplete change at the end of %s.\n", s.journalName())
+	}
+	for i, line := range lines[:len(lines)-1] {
+		entry := &jsonEntry{}
+		if err = json.Unmarshal(line, entry); nil != err {
+			err = fmt.Errorf("unable to replay line %d of %s: %v", i+1, s.journalName(), err)
+			return
+		}
+		for _, w := range entry.Writes {
+			s.apply(w.Bucket, w.ID, w.Value)
+		}
+		s.size += int64(len(line)) + 1
+		s.journaled++
+	}
+	return
+}
+
+// each record in the bucket, in order.
+func (s *jsonStore) each(bucket string, fn func(id uint64, value []byte) error) error {
+	for _, id := range sortedIDs(s.buckets[bucket]) {
+		if err := fn(id, s.buckets[bucket][id]); nil != err {
+			return err
+		}
+	}
+	return nil
+}
+
+// commit the writes by appending them to the journal, compacting it once it
+// holds enough commits. Nothing changes unless synced to disk.
+func (s *jsonStore) commit(writes []*write) (err error) {
+	next := s.next
+	entry := jsonEntry{Writes: make([]jsonWrite, len(writes))}
+	for i, w := range writes {
+		entry.Writes[i] = jsonWrite{Bucket: w.bucket, ID: w.id, Value: w.value}
+		if nil != w.value && 0 == w.id {
+			next++
+			entry.Writes[i].ID = next
+		}
+	}
+
+	var line []byte
+	if line, err = json.Marshal(entry); nil != err {
+		return
+	}
+	if err = s.append(append(line, '\n')); nil != err {
+		return
+	}
+
+	s.next = next
+	for i, w := range writes {
+		w.id = entry.Writes[i].ID
+		s.apply(w.bucket, w.id, w.value)
+	}
+	if s.journaled++; jsonCompactAfter <= s.journaled {
+		if errX := s.compact(); nil != errX {
+			log.Printf("Unable to compact %s, keeping the journal: %v\n", s.filename, errX)
+		}
+	}
+	return
+}
+
+// append a line to the journal and sync it. A partly written line is cut off
+// so the journal stays readable.
+func (s *jsonStore) append(line []byte) (err error) {
+	if _, err = s.journal.WriteAt(line, s.size); nil == err {
+		err = s.journal.Sync()
+	}
+	if nil != err {
+		s.journal.Truncate(s.size)
+		return
+	}
+	s.size += int64(len(line))
+	return
+}
+
+// apply a write to the records. A null value deletes the record.
+func (s *jsonStore) apply(bucket string, id uint64, value json.RawMessage) {
+	records, found := s.buckets[bucket]
+	if !found {
+		return
+	}
+	if 0 == len(value) || "null" == string(value) {
+		delete(records, id)
+		return
+	}
+	records[id] = value
+	if s.next < id {
+		s.next = id
+	}
+}
+
+// compact every record into a new snapshot, written to a temporary file and
+// renamed over the old one, then empty the journal.
+func (s *jsonStore) compact() (err error) {
+	snapshot := &jsonSnapshot{IDs: map[string][]uint64{}, Next: s.next, Schema: s.version}
+	for _, list := range []struct {
+		bucket  string
+		records *[]json.RawMessage
+	}{
+		{usersBucket, &snapshot.Users},
+		{filesBucket, &snapshot.Files},
+		{versionsBucket, &snapshot.Versions},
+		{trashBucket, &snapshot.Trash},
+	} {
+		ids := sortedIDs(s.buckets[list.bucket])
+		*list.records = make([]json.RawMessage, len(ids))
+		for i, id := range ids {
+			(*list.records)[i] = s.buckets[list.bucket][id]
+		}
+		snapshot.IDs[list.bucket] = ids
+	}
+
+	var contents []byte
+	if contents, err = json.Marshal(snapshot); nil != err {
+		return
+	}
+	if err = writeSynced(s.filename+".tmp", contents); nil != err {
+		return
+	}
+	if err = os.Rename(s.filename+".tmp", s.filename); nil != err {
+		return
+	}
+	if err = syncDir(filepath.Dir(s.filename)); nil != err {
+		return
+	}
+
+	// The snapshot holds everything journaled so far.
+	if err = s.journal.Truncate(0); nil == err {
+		err = s.journal.Sync()
+	}
+	if nil == err {
+		s.size, s.journaled = 0, 0
+	}
+	return
+}
+
+// schema version kept in the snapshot.
+func (s *jsonStore) schema() (int, error) {
+	return s.version, nil
+}
+
+// setSchema version by writing a new snapshot.
+func (s *jsonStore) setSchema(version int) (err error) {
+	previous := s.version
+	s.version = version
+	if err = s.compact(); nil != err {
+		s.version = previous
+	}
+	return
+}
+
+// close the journal, releasing the lock.
+func (s *jsonStore) close() error {
+	return s.journal.Close()
+}
+
+// journalName of the journal kept next to the snapshot.
+func (s *jsonStore) journalName() string {
+	return s.filename + ".journal"
+}
+
+// writeSynced contents to a new file, syncing them to disk before closing.
+func writeSynced(filename string, contents []byte) (err error) {
+	var f *os.File
+	if f, err = os.OpenFile(filename, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0666); nil != err {
+		return
+	}
+	if _, err = f.Write(contents); nil == err {
+		err = f.Sync()
+	}
+	if errX := f.Close(); nil == err {
+		err = errX
+	}
+	return
+}
+
+// syncDir so a file renamed into it survives a crash.
+func syncDir(dir string) (err error) {
+	var d *os.File
+	if d, err = os.Open(dir); nil != err {
+		return
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+// sortedIDs of the records.
+func sortedIDs(records map[uint64]json.RawMessage) []uint64 {
+	ids := make([]uint64, 0, len(records))
+	for id := range records {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
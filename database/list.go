@@ -0,0 +1,83 @@
+package database
+
+import (
+	"strings"
+)
+
+// Listing of the files under a prefix, ordered by path. With a delimiter, paths
+// holding it after the prefix are rolled up into common prefixes ending with
+// it, listed once in place of their files. Truncated listings continue after
+// Next, which is the last path or common prefix listed.
+type Listing struct {
+	Files     []*File
+	Prefixes  []string
+	Truncated bool
+	Next      string
+}
+
+// listPrefix returns a copy of the files under the prefix after the start,
+// listing up to max files and common prefixes. Zero max lists everything.
+func listPrefix(prefix, delimiter, startAfter string, max int) (listing *Listing) {
+	getMtx.RLock()
+	defer getMtx.RUnlock()
+
+	listing = &Listing{}
+	from := prefix
+	if from <= startAfter {
+		from = startAfter + "\x00" // First path after the start.
+	}
+
+	// Every common prefix starts the scan over after its last path, rather than
+	// going through all of them.
+	for scan := true; scan; {
+		scan = false
+		paths.ascend(from, func(f *File) bool {
+			if !strings.HasPrefix(f.Path, prefix) {
+				return false
+			}
+
+			entry, common := f.Path, false
+			if "" != delimiter {
+				if i := strings.Index(f.Path[len(prefix):], delimiter); 0 <= i {
+					entry, common = f.Path[:len(prefix)+i+len(delimiter)], true
+				}
+			}
+
+			// A common prefix holding the start was listed already.
+			if !common || startAfter < entry {
+				if 0 < max && max <= len(listing.Files)+len(listing.Prefixes) {
+					listing.Truncated = true
+					return false
+				}
+				if common {
+					listing.Prefixes = append(listing.Prefixes, entry)
+				} else {
+					listing.Files = append(listing.Files, copyFile(f))
+				}
+				listing.Next = entry
+			}
+			if common {
+				from, scan = prefixEnd(entry)
+				return false
+			}
+			return true
+		})
+	}
+	if !listing.Truncated {
+		listing.Next = ""
+	}
+	return
+}
+
+// prefixEnd is the first string after every string starting with the prefix.
+// Returns false when there is none.
+func prefixEnd(prefix string) (end string, found bool) {
+	b := []byte(prefix)
+	for i := len(b) - 1; 0 <= i; i-- {
+		if 0xff > b[i] {
+			b[i]++
+			return string(b[:i+1]), true
+		}
+	}
+	return
+}
@@ -1,6 +1,9 @@
 package database
 
-import "sync"
+import (
+	"sync"
+	"time"
+)
 
 var (
 	// FileDeletionChan is a pipe for files awaiting deletion.
@@ -26,9 +29,31 @@ func Shutdown() {
 	fileDeletionMtx.Unlock()
 }
 
-// Load the database from disk.
-func Load(filename string) (err error) {
-	return load(filename)
+// Load the database kept by the driver ("json", "bolt" or "sqlite") at the
+// filename, creating it when missing.
+func Load(driver, filename string) (err error) {
+	return load(driver, filename)
+}
+
+// Migrate the database kept by the driver at the filename to the current schema
+// version, copying its files to a backup first. The progress function is called
+// before each migration. Returns the version migrated from and the name of the
+// backup, which is empty when already current.
+func Migrate(driver, filename string, progress func(version int, description string)) (from int, backup string, err error) {
+	return migrate(driver, filename, progress)
+}
+
+// TakeSnapshot of every record for a backup. The contents of every file are kept
+// until the snapshot is released.
+func TakeSnapshot() (*Snapshot, error) {
+	return takeSnapshot()
+}
+
+// RestoreSnapshot as the database kept by the driver at the filename, moving the
+// existing database to a backup. Returns the name of the backup, which is empty
+// when there was no database.
+func RestoreSnapshot(driver, filename string, snapshot *Snapshot) (backup string, err error) {
+	return restoreSnapshot(driver, filename, snapshot)
 }
 
 // AddUser to the database.
@@ -60,6 +85,26 @@ func AddFile(file *File) error {
 	return addFile(file)
 }
 
+// AddFileHolding like AddFile, except that a file replaced without becoming a
+// version is handed back rather than deleted. It must either be released with
+// ReleaseFile once the new contents are in place, or brought back with
+// RevertFile.
+func AddFileHolding(file *File) (replaced *File, err error) {
+	return addFileHolding(file)
+}
+
+// ReleaseFile replaced by AddFileHolding, deleting its contents once any
+// downloads complete. Nothing happens for a nil file.
+func ReleaseFile(file *File) {
+	releaseFile(file)
+}
+
+// RevertFile added by AddFileHolding whose contents failed to reach storage,
+// bringing back the file it replaced.
+func RevertFile(added, replaced *File) error {
+	return revertFile(added, replaced)
+}
+
 // GetMetadata return a copy of the metadata.
 func GetMetadata(filePath string) (file *File, err error) {
 	return getMetadata(filePath)
@@ -70,7 +115,161 @@ func GetFileForDownload(filePath string) (file *File, err error) {
 	return getFileForDownload(filePath)
 }
 
+// GetMetadataByKey returns a copy of the metadata of any file with contents at
+// the storage key.
+func GetMetadataByKey(key string) (file *File, err error) {
+	return getMetadataByKey(key)
+}
+
+// ListFiles returns a copy of the metadata of every file.
+func ListFiles() []*File {
+	return listFiles()
+}
+
+// ListPrefix returns a copy of up to max files under the prefix after the start,
+// in order, rolling paths up into common prefixes at the delimiter when given.
+// Zero max lists everything.
+func ListPrefix(prefix, delimiter, startAfter string, max int) *Listing {
+	return listPrefix(prefix, delimiter, startAfter, max)
+}
+
+// SetStorageKeys of files stored at their path, mapped by path, once their
+// contents have been copied to the new keys. Other files are left alone.
+func SetStorageKeys(keys map[string]string) error {
+	return setStorageKeys(keys)
+}
+
+// RewrapKeys of every encrypted file with the rewrap function, which receives
+// and returns the master key ID and the wrapped data key. Returns the number of
+// keys rewrapped.
+func RewrapKeys(
+	rewrap func(keyID, wrapped string) (newKeyID, newWrapped string, err error),
+) (int, error) {
+	return rewrapKeys(rewrap)
+}
+
 // RemoveFile from the database.
 func RemoveFile(filePath string) error {
 	return removeFile(filePath)
 }
+
+// AddDeleteMarker as the latest version of a path, keeping the current version
+// in its history.
+func AddDeleteMarker(marker *File) error {
+	return addDeleteMarker(marker)
+}
+
+// RemoveVersion of a file for good. The next newest version becomes current
+// when the latest is removed.
+func RemoveVersion(filePath, versionID string) error {
+	return removeVersion(filePath, versionID)
+}
+
+// ListVersions of a path, newest first, starting with the current version.
+func ListVersions(filePath string) ([]*File, error) {
+	return listVersions(filePath)
+}
+
+// ListHistory returns a copy of the metadata of every version that is not
+// current, leaving out delete markers.
+func ListHistory() []*File {
+	return listHistory()
+}
+
+// GetVersionForDownload so that the contents are not deleted in progress.
+func GetVersionForDownload(filePath, versionID string) (*File, error) {
+	return getVersionForDownload(filePath, versionID)
+}
+
+// HasHistory returns true when the path has versions other than the current.
+func HasHistory(filePath string) bool {
+	return hasHistory(filePath)
+}
+
+// TrashFile at the path, keeping it in the trash as the item until restored or
+// purged.
+func TrashFile(filePath string, item *TrashItem) error {
+	return trashFile(filePath, item)
+}
+
+// RestoreTrash item to its path, returning a copy of the restored file.
+func RestoreTrash(id string) (*File, error) {
+	return restoreTrash(id)
+}
+
+// PurgeTrash item for good.
+func PurgeTrash(id string) (err error) {
+	var count int
+	if count, err = purgeTrash(func(item *TrashItem) bool { return id == item.ID }); nil == err && 0 == count {
+		err = ErrTrashNotFound
+	}
+	return
+}
+
+// PurgeTrashDeletedBefore the time, returning the number of items purged.
+func PurgeTrashDeletedBefore(t time.Time) (int, error) {
+	return purgeTrash(func(item *TrashItem) bool { return item.Deleted.Before(t) })
+}
+
+// EmptyTrash of every item, returning the number of items purged.
+func EmptyTrash() (int, error) {
+	return purgeTrash(func(item *TrashItem) bool { return true })
+}
+
+// ListTrash returns a copy of every item in the trash, oldest first.
+func ListTrash() []*TrashItem {
+	return listTrash()
+}
+
+// InTrash returns true when a file deleted from the path is in the trash.
+func InTrash(filePath string) bool {
+	return inTrash(filePath)
+}
+
+// SetRetention of a file version until the time. Retention still in effect can
+// only be extended, unless bypassing governance mode retention.
+func SetRetention(filePath, versionID, mode string, until time.Time, bypassGovernance bool) error {
+	return setRetention(filePath, versionID, mode, until, bypassGovernance)
+}
+
+// SetLegalHold of a file version on or off.
+func SetLegalHold(filePath, versionID string, hold bool) error {
+	return setLegalHold(filePath, versionID, hold)
+}
+
+// Touch the file being downloaded, recording the access.
+func Touch(f *File) {
+	touch(f)
+}
+
+// TierOf a file being downloaded.
+func TierOf(f *File) string {
+	return tierOf(f)
+}
+
+// SetTier of every file with contents at the storage key once moved. Returns
+// the number of files updated, which is zero when none refer to the key
+// anymore.
+func SetTier(key, tier string) (int, error) {
+	return setTier(key, tier)
+}
+
+// SaveAccessed times recorded by downloads since the last change.
+func SaveAccessed() error {
+	return saveAccessed()
+}
+
+// GetUsage of storage by an uploader and overall.
+func GetUsage(uploader string) (user, total Usage) {
+	getMtx.RLock()
+	defer getMtx.RUnlock()
+	return getUsage(uploader)
+}
+
+// References to a storage key by files in the database. Contents are safe to
+// delete from storage when there are none.
+func References(key string) int {
+	getMtx.RLock()
+	defer getMtx.RUnlock()
+	return countReferences(key)
+}
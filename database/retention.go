@@ -0,0 +1,76 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	// GovernanceMode retention can be shortened or removed by an admin.
+	GovernanceMode = "governance"
+	// ComplianceMode retention can't be shortened or removed by anyone.
+	ComplianceMode = "compliance"
+)
+
+var (
+	// ErrLocked is returned when replacing or deleting a file under retention or
+	// legal hold.
+	ErrLocked = errors.New("file is under retention or legal hold")
+
+	// ErrRetentionShortened is returned when shortening or removing retention
+	// without being allowed to.
+	ErrRetentionShortened = errors.New("retention can only be extended")
+)
+
+// Locked files can't be replaced or deleted until the retention date passes
+// and the legal hold is released.
+func (f *File) Locked() bool {
+	return f.LegalHold || time.Now().Before(f.RetainUntil)
+}
+
+// setRetention of a file version. Retention still in effect can only be
+// extended, and compliance mode can't be changed, unless bypassing governance
+// mode retention.
+func setRetention(filePath, versionID, mode string, until time.Time, bypassGovernance bool) (err error) {
+	getMtx.Lock()
+	defer getMtx.Unlock()
+
+	var f *File
+	if f, err = getVersionFromIndex(filePath, versionID); nil != err {
+		return
+	}
+	if f.DeleteMarker {
+		err = fmt.Errorf("version %s of %s is a delete marker", versionID, filePath)
+		return
+	}
+	if time.Now().Before(f.RetainUntil) {
+		bypass := bypassGovernance && GovernanceMode == f.RetentionMode
+		shortened := until.Before(f.RetainUntil) || (ComplianceMode == f.RetentionMode && ComplianceMode != mode)
+		if shortened && !bypass {
+			err = ErrRetentionShortened
+			return
+		}
+	}
+	f.RetentionMode, f.RetainUntil = mode, until
+	changed(f)
+	return save()
+}
+
+// setLegalHold of a file version on or off.
+func setLegalHold(filePath, versionID string, hold bool) (err error) {
+	getMtx.Lock()
+	defer getMtx.Unlock()
+
+	var f *File
+	if f, err = getVersionFromIndex(filePath, versionID); nil != err {
+		return
+	}
+	if f.DeleteMarker {
+		err = fmt.Errorf("version %s of %s is a delete marker", versionID, filePath)
+		return
+	}
+	f.LegalHold = hold
+	changed(f)
+	return save()
+}
@@ -0,0 +1,282 @@
+package database
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"time"
+
+	"github.com/halverneus/example/storage"
+)
+
+const (
+	// SchemaVersion of the records written by this version, which is the
+	// version of the last migration.
+	SchemaVersion = 2
+
+	// legacyCreatedFormat of the creation time files were recorded with before
+	// modification times, in local time.
+	legacyCreatedFormat = "Jan 2, 2006 3:04 PM"
+)
+
+// migration of the records in a store from the previous schema version.
+type migration struct {
+	version     int
+	description string
+	migrate     func(s store) error
+}
+
+// migrations in order, each upgrading the records from the previous version.
+// Databases written before schema versioning are version 0.
+var migrations = []migration{
+	{
+		version:     1,
+		description: "record the schema version",
+		migrate:     func(s store) error { return nil }, // Records are unchanged.
+	},
+	{
+		version:     2,
+		description: "record when files written before modification times were modified and their sizes",
+		migrate:     migrateLegacyFiles,
+	},
+}
+
+// migrateLegacyFiles, which have no modification time, taking it from their
+// creation time and their size from storage. Legacy files are stored at their
+// path in the hot tier, uncompressed and unencrypted. Sizes of contents missing
+// from storage stay zero.
+func migrateLegacyFiles(s store) (err error) {
+	var backend storage.Backend
+
+	// upgrade a legacy file, returning false when there is nothing to do.
+	upgrade := func(f *File) (upgraded bool, err error) {
+		if !f.Modified.IsZero() || f.DeleteMarker {
+			return
+		}
+		if created, errX := time.ParseInLocation(legacyCreatedFormat, f.Created, time.Local); nil == errX {
+			f.Modified, upgraded = created.UTC(), true
+		}
+		if 0 != f.Size || "" != f.SHA256 {
+			return
+		}
+		if nil == backend {
+			if backend, err = storage.Open(); nil != err {
+				return
+			}
+		}
+		var info *storage.Info
+		if info, err = backend.Stat(f.StorageKey()); storage.ErrNotFound == err {
+			log.Printf("Contents of %s are missing from storage, leaving its size unknown.\n", f.Path)
+			err = nil
+			return
+		} else if nil != err {
+			return
+		}
+		f.Size, upgraded = info.Size, true
+		return
+	}
+
+	// Gather every change before writing, as stores can't be written while
+	// going through their records.
+	var writes []*write
+	for _, bucket := range []string{filesBucket, versionsBucket, trashBucket} {
+		err = s.each(bucket, func(id uint64, value []byte) (err error) {
+			var record interface{}
+			var f *File
+			if trashBucket == bucket {
+				item := &TrashItem{}
+				if err = json.Unmarshal(value, item); nil != err || nil == item.File {
+					return
+				}
+				record, f = item, item.File
+			} else {
+				f = &File{}
+				if err = json.Unmarshal(value, f); nil != err {
+					return
+				}
+				record = f
+			}
+
+			var upgraded bool
+			if upgraded, err = upgrade(f); nil != err || !upgraded {
+				return
+			}
+			w := &write{bucket: bucket, id: id, at: &location{}}
+			if w.value, err = json.Marshal(record); nil == err {
+				writes = append(writes, w)
+			}
+			return
+		})
+		if nil != err {
+			return
+		}
+	}
+	if 0 < len(writes) {
+		err = s.commit(writes)
+	}
+	return
+}
+
+// errStoreNotEmpty stops going through the records of a store at the first.
+var errStoreNotEmpty = errors.New("store is not empty")
+
+// checkSchema of the store at the filename, refusing versions written by a newer
+// version. Older ones are migrated, keeping a backup, and an empty store starts
+// at the current version.
+func checkSchema(s store, filename string) (err error) {
+	var version int
+	if version, err = s.schema(); nil != err {
+		return
+	}
+	switch {
+	case SchemaVersion < version:
+		return newerSchemaError(version)
+	case SchemaVersion == version:
+		return
+	}
+
+	// Only a store holding records has anything to migrate.
+	empty := true
+	for _, bucket := range []string{usersBucket, filesBucket, versionsBucket, trashBucket} {
+		if err = s.each(bucket, func(uint64, []byte) error { return errStoreNotEmpty }); errStoreNotEmpty == err {
+			empty, err = false, nil
+			break
+		} else if nil != err {
+			return
+		}
+	}
+	if empty {
+		return s.setSchema(SchemaVersion)
+	}
+
+	var backup string
+	if _, backup, err = migrateStore(s, filename, func(version int, description string) {
+		log.Printf("Migrating %s to schema version %d: %s.\n", filename, version, description)
+	}); nil == err {
+		log.Printf("Kept %s as it was before migrating at %s.\n", filename, backup)
+	}
+	return
+}
+
+// migrate the store of the driver at the filename to the current schema,
+// copying its files to a backup first. There is no backup when there is
+// nothing to migrate.
+func migrate(driver, filename string, progress func(version int, description string)) (from int, backup string, err error) {
+	if _, err = os.Stat(filename); nil != err {
+		return
+	}
+	var s store
+	if s, err = openStore(driver, filename); nil != err {
+		return
+	}
+	defer s.close()
+	return migrateStore(s, filename, progress)
+}
+
+// migrateStore open at the filename to the current schema, copying its files to
+// a backup first. The progress function is called before each migration.
+func migrateStore(s store, filename string, progress func(version int, description string)) (from int, backup string, err error) {
+	if from, err = s.schema(); nil != err {
+		return
+	}
+	switch {
+	case SchemaVersion < from:
+		err = newerSchemaError(from)
+		return
+	case SchemaVersion == from:
+		return
+	}
+	if backup, err = backupFiles(filename); nil != err {
+		return
+	}
+
+	// Record each version as it completes, so an interrupted migration resumes
+	// where it stopped.
+	for _, m := range migrations {
+		if m.version <= from {
+			continue
+		}
+		progress(m.version, m.description)
+		if err = m.migrate(s); nil != err {
+			err = fmt.Errorf("unable to migrate to schema version %d: %v (backup kept at %s)", m.version, err, backup)
+			return
+		}
+		if err = s.setSchema(m.version); nil != err {
+			err = fmt.Errorf("%v (backup kept at %s)", err, backup)
+			return
+		}
+	}
+	return
+}
+
+// newerSchemaError for a database written by a newer version of example.
+func newerSchemaError(version int) error {
+	return fmt.Errorf(
+		"database schema version %d is newer than version %d supported by this version of example",
+		version, SchemaVersion,
+	)
+}
+
+// databaseSuffixes of the files a store may keep next to its filename.
+var databaseSuffixes = []string{"", ".journal", "-wal", "-shm"}
+
+// backupFiles of the database at the filename, named after it and the time,
+// never replacing an earlier backup. Returns the name of the backup.
+func backupFiles(filename string) (backup string, err error) {
+	if backup, err = backupName(filename); nil != err {
+		return
+	}
+	for _, suffix := range databaseSuffixes {
+		if err = copyDatabaseFile(filename+suffix, backup+suffix); os.IsNotExist(err) {
+			err = nil
+		} else if nil != err {
+			removeFiles(backup)
+			return
+		}
+	}
+	return
+}
+
+// backupName for the database at the filename, named after it and the time and
+// unused by any earlier backup.
+func backupName(filename string) (backup string, err error) {
+	stamp := fmt.Sprintf("%s.backup-%s", filename, time.Now().UTC().Format("20060102T150405"))
+	backup = stamp
+	for i := 2; ; i++ {
+		if _, err = os.Stat(backup); os.IsNotExist(err) {
+			return backup, nil
+		} else if nil != err {
+			return
+		}
+		backup = fmt.Sprintf("%s-%d", stamp, i)
+	}
+}
+
+// removeFiles of a database backup.
+func removeFiles(backup string) {
+	for _, suffix := range databaseSuffixes {
+		os.Remove(backup + suffix)
+	}
+}
+
+// copyDatabaseFile to a new file synced to disk.
+func copyDatabaseFile(from, to string) (err error) {
+	var src, dst *os.File
+	if src, err = os.Open(from); nil != err {
+		return
+	}
+	defer src.Close()
+	if dst, err = os.OpenFile(to, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0666); nil != err {
+		return
+	}
+	if _, err = io.Copy(dst, src); nil == err {
+		err = dst.Sync()
+	}
+	if errX := dst.Close(); nil == err {
+		err = errX
+	}
+	return
+}
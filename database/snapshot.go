@@ -0,0 +1,168 @@
+package database
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// Snapshot of every record in the database at one point in time, as written to
+// backups.
+type Snapshot struct {
+	Schema   int               `json:"schema"`
+	Users    []json.RawMessage `json:"users"`
+	Files    []json.RawMessage `json:"files"`
+	Versions []json.RawMessage `json:"versions"`
+	Trash    []json.RawMessage `json:"trash"`
+
+	// pinned files, whose contents aren't deleted until released.
+	pinned []*File
+}
+
+// takeSnapshot of every record, pinning the contents of every file like a
+// download does until the snapshot is released.
+func takeSnapshot() (snapshot *Snapshot, err error) {
+	getMtx.RLock()
+	defer getMtx.RUnlock()
+
+	snapshot = &Snapshot{Schema: SchemaVersion}
+	if err = marshalRecords(&snapshot.Users, get.Users); nil == err {
+		if err = marshalRecords(&snapshot.Files, get.Files); nil == err {
+			if err = marshalRecords(&snapshot.Versions, get.Versions); nil == err {
+				err = marshalRecords(&snapshot.Trash, get.Trash)
+			}
+		}
+	}
+	if nil != err {
+		return
+	}
+
+	for _, f := range allFiles() {
+		if !f.DeleteMarker {
+			f.mtx.RLock()
+			snapshot.pinned = append(snapshot.pinned, f)
+		}
+	}
+	return
+}
+
+// marshalRecords of a list into raw JSON records.
+func marshalRecords(records *[]json.RawMessage, list interface{}) (err error) {
+	var raw []byte
+	if raw, err = json.Marshal(list); nil != err {
+		return
+	}
+	return json.Unmarshal(raw, records)
+}
+
+// Contents returns a copy of every file in the snapshot with contents, current
+// versions first, followed by the history and the trash.
+func (s *Snapshot) Contents() (list []*File, err error) {
+	var files, versions []*File
+	var items []*TrashItem
+	if err = unmarshalRecords(s.Files, &files); nil != err {
+		return
+	}
+	if err = unmarshalRecords(s.Versions, &versions); nil != err {
+		return
+	}
+	if err = unmarshalRecords(s.Trash, &items); nil != err {
+		return
+	}
+	for _, item := range items {
+		versions = append(versions, item.File)
+	}
+	for _, f := range append(files, versions...) {
+		if !f.DeleteMarker {
+			list = append(list, f)
+		}
+	}
+	return
+}
+
+// unmarshalRecords of a snapshot into a list.
+func unmarshalRecords(records []json.RawMessage, list interface{}) (err error) {
+	var raw []byte
+	if raw, err = json.Marshal(records); nil != err {
+		return
+	}
+	return json.Unmarshal(raw, list)
+}
+
+// Release the contents pinned by the snapshot.
+func (s *Snapshot) Release() {
+	for _, f := range s.pinned {
+		f.mtx.RUnlock()
+	}
+	s.pinned = nil
+}
+
+// restoreSnapshot as the database kept by the driver at the filename. The new
+// database is written next to it before moving the existing files to a backup
+// and the new ones in their place. Returns the name of the backup, which is
+// empty when there was no database.
+func restoreSnapshot(driver, filename string, snapshot *Snapshot) (backup string, err error) {
+	if SchemaVersion < snapshot.Schema {
+		err = fmt.Errorf(
+			"backup schema version %d is newer than version %d supported by this version of example",
+			snapshot.Schema, SchemaVersion,
+		)
+		return
+	}
+
+	// Write every record as new, keeping their order.
+	restored := filename + ".restore"
+	removeFiles(restored)
+	var s store
+	if s, err = openStore(driver, restored); nil != err {
+		return
+	}
+	writes := []*write{}
+	for _, list := range []struct {
+		bucket  string
+		records []json.RawMessage
+	}{
+		{usersBucket, snapshot.Users},
+		{filesBucket, snapshot.Files},
+		{versionsBucket, snapshot.Versions},
+		{trashBucket, snapshot.Trash},
+	} {
+		for _, value := range list.records {
+			writes = append(writes, &write{bucket: list.bucket, value: value, at: &location{}})
+		}
+	}
+	if err = s.commit(writes); nil == err {
+		err = s.setSchema(snapshot.Schema)
+	}
+	if errX := s.close(); nil == err {
+		err = errX
+	}
+	if nil != err {
+		removeFiles(restored)
+		return
+	}
+
+	// Swap the restored database in, keeping the existing one as a backup.
+	if _, errX := os.Stat(filename); nil == errX {
+		if backup, err = backupName(filename); nil != err {
+			return
+		}
+		if err = renameFiles(filename, backup); nil != err {
+			return
+		}
+	}
+	err = renameFiles(restored, filename)
+	return
+}
+
+// renameFiles of a database, including those kept next to it.
+func renameFiles(from, to string) (err error) {
+	for _, suffix := range databaseSuffixes {
+		if err = os.Rename(from+suffix, to+suffix); os.IsNotExist(err) {
+			err = nil
+		} else if nil != err {
+			return
+		}
+	}
+	return
+}
@@ -0,0 +1,128 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+
+	// Pure Go SQLite driver registered as "sqlite".
+	"modernc.org/sqlite"
+)
+
+const (
+	// sqliteBusy is the result code of a database locked by another
+	// connection.
+	sqliteBusy = 5
+)
+
+// sqliteStore keeps records in an embedded SQLite file, in a single table
+// keyed by bucket and ID.
+type sqliteStore struct {
+	db *sql.DB
+}
+
+// openSQLite file, creating the table when missing.
+func openSQLite(filename string) (s *sqliteStore, err error) {
+	var db *sql.DB
+	if db, err = sql.Open("sqlite", filename); nil != err {
+		return
+	}
+
+	// A single connection serializes writers, which already hold the lock. It
+	// keeps the database locked until closed, waiting a second for others.
+	db.SetMaxOpenConns(1)
+	for _, stmt := range []string{
+		`PRAGMA busy_timeout = 1000`,
+		`PRAGMA locking_mode = EXCLUSIVE`,
+		`PRAGMA journal_mode = WAL`,
+		`CREATE TABLE IF NOT EXISTS records (
+			bucket TEXT NOT NULL,
+			id INTEGER NOT NULL,
+			value BLOB NOT NULL,
+			PRIMARY KEY (bucket, id)
+		)`,
+	} {
+		if _, err = db.Exec(stmt); nil != err {
+			if e, ok := err.(*sqlite.Error); ok && sqliteBusy == e.Code()&0xff {
+				err = ErrInUse
+			}
+			db.Close()
+			return
+		}
+	}
+	s = &sqliteStore{db: db}
+	return
+}
+
+// each record in the bucket, in order.
+func (s *sqliteStore) each(bucket string, fn func(id uint64, value []byte) error) (err error) {
+	var rows *sql.Rows
+	if rows, err = s.db.Query(`SELECT id, value FROM records WHERE bucket = ? ORDER BY id`, bucket); nil != err {
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id uint64
+		var value []byte
+		if err = rows.Scan(&id, &value); nil != err {
+			return
+		}
+		if err = fn(id, value); nil != err {
+			return
+		}
+	}
+	return rows.Err()
+}
+
+// commit the writes in one transaction. New records follow the last record of
+// their bucket.
+func (s *sqliteStore) commit(writes []*write) (err error) {
+	var tx *sql.Tx
+	if tx, err = s.db.Begin(); nil != err {
+		return
+	}
+	ids := make([]uint64, len(writes))
+	for i, w := range writes {
+		switch ids[i] = w.id; {
+		case nil == w.value:
+			_, err = tx.Exec(`DELETE FROM records WHERE bucket = ? AND id = ?`, w.bucket, w.id)
+		case 0 == w.id:
+			err = tx.QueryRow(
+				`SELECT COALESCE(MAX(id), 0) + 1 FROM records WHERE bucket = ?`, w.bucket,
+			).Scan(&ids[i])
+			if nil == err {
+				_, err = tx.Exec(`INSERT INTO records (bucket, id, value) VALUES (?, ?, ?)`, w.bucket, ids[i], w.value)
+			}
+		default:
+			_, err = tx.Exec(`UPDATE records SET value = ? WHERE bucket = ? AND id = ?`, w.value, w.bucket, w.id)
+		}
+		if nil != err {
+			tx.Rollback()
+			return
+		}
+	}
+	if err = tx.Commit(); nil != err {
+		return
+	}
+	for i, w := range writes {
+		w.id = ids[i]
+	}
+	return
+}
+
+// schema version kept as the user version of the file.
+func (s *sqliteStore) schema() (version int, err error) {
+	err = s.db.QueryRow(`PRAGMA user_version`).Scan(&version)
+	return
+}
+
+// setSchema version as the user version of the file.
+func (s *sqliteStore) setSchema(version int) (err error) {
+	_, err = s.db.Exec(fmt.Sprintf(`PRAGMA user_version = %d`, version))
+	return
+}
+
+// close the file.
+func (s *sqliteStore) close() error {
+	return s.db.Close()
+}
@@ -0,0 +1,213 @@
+package database
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+const (
+	// usersBucket, filesBucket, versionsBucket and trashBucket group the
+	// records kept by a store.
+	usersBucket    = "users"
+	filesBucket    = "files"
+	versionsBucket = "versions"
+	trashBucket    = "trash"
+)
+
+var (
+	// ErrInUse is returned when opening a database another process has open.
+	// Stores hold an exclusive lock on their files while open.
+	ErrInUse = errors.New("database is in use by another process")
+
+	// db keeping the records on disk.
+	db store
+
+	// pending records changed since the last save, in the order changed, and
+	// where each is in the list.
+	pending   []interface{}
+	pendingAt = map[interface{}]int{}
+)
+
+// store keeps the records of the database on disk. Records are grouped in
+// buckets, each kept in the order records were added to it.
+type store interface {
+	// each record in the bucket, in order. The value is only valid during the
+	// call.
+	each(bucket string, fn func(id uint64, value []byte) error) error
+
+	// commit the writes as one transaction. New records are given the next ID
+	// of their bucket, which is only set once committed.
+	commit(writes []*write) error
+
+	// schema version of the records, which is 0 when never set.
+	schema() (int, error)
+
+	// setSchema version of the records.
+	setSchema(version int) error
+
+	// close the store.
+	close() error
+}
+
+// location of a record in a store. The bucket is empty when not stored.
+type location struct {
+	bucket string
+	id     uint64
+}
+
+// write of a record to a bucket. A zero ID adds a new record and a nil value
+// deletes the record.
+type write struct {
+	bucket string
+	id     uint64
+	value  []byte
+
+	// at is the location kept by the record, updated once committed.
+	at *location
+}
+
+// openStore of the driver at the filename.
+func openStore(driver, filename string) (s store, err error) {
+	switch driver {
+	case "", "json":
+		s, err = openJSON(filename)
+	case "bolt":
+		s, err = openBolt(filename)
+	case "sqlite":
+		s, err = openSQLite(filename)
+	default:
+		err = fmt.Errorf("unknown database driver: %s", driver)
+	}
+	return
+}
+
+// changed records are written with the next save. A record changed again moves
+// to the end, so records added to a bucket keep their order.
+func changed(records ...interface{}) {
+	for _, record := range records {
+		if i, found := pendingAt[record]; found {
+			pending[i] = nil
+		}
+		pendingAt[record] = len(pending)
+		pending = append(pending, record)
+	}
+}
+
+// save the pending changes as one transaction.
+func save() (err error) {
+	if 0 == len(pending) {
+		return
+	}
+
+	// Files in the trash are kept by their trash item.
+	records := []interface{}{}
+	seen := map[interface{}]bool{}
+	for _, record := range pending {
+		if f, ok := record.(*File); ok && nil != trashOf[f] {
+			records = append(records, f)
+			record = trashOf[f]
+		}
+		if nil != record && !seen[record] {
+			seen[record] = true
+			records = append(records, record)
+		}
+	}
+
+	var writes []*write
+	for _, record := range records {
+		var more []*write
+		if more, err = writesOf(record); nil != err {
+			return
+		}
+		writes = append(writes, more...)
+	}
+	if err = db.commit(writes); nil != err {
+		return // Keep the changes pending to retry with the next save.
+	}
+	for _, w := range writes {
+		if nil == w.value {
+			*w.at = location{}
+		} else {
+			*w.at = location{bucket: w.bucket, id: w.id}
+		}
+	}
+	pending, pendingAt = nil, map[interface{}]int{}
+	return
+}
+
+// writesOf a changed record, which moves it to the bucket now holding it or
+// deletes it when gone.
+func writesOf(record interface{}) (writes []*write, err error) {
+	var bucket string
+	var at *location
+	switch r := record.(type) {
+	case *user:
+		at = &r.stored
+		if users[r.Username] == r {
+			bucket = usersBucket
+		}
+	case *File:
+		at = &r.stored
+		switch {
+		case files[r.Path] == r:
+			bucket = filesBucket
+		case 0 <= indexOf(history[r.Path], r):
+			bucket = versionsBucket
+		}
+	case *TrashItem:
+		at = &r.stored
+		if trash[r.ID] == r {
+			bucket = trashBucket
+		}
+	default:
+		err = fmt.Errorf("unable to save record of type %T", record)
+		return
+	}
+
+	// Records moving between buckets are deleted and added again.
+	if "" != at.bucket && bucket != at.bucket {
+		writes = append(writes, &write{bucket: at.bucket, id: at.id, at: at})
+	}
+	if "" != bucket {
+		w := &write{bucket: bucket, at: at}
+		if bucket == at.bucket {
+			w.id = at.id
+		}
+		if w.value, err = json.Marshal(record); nil != err {
+			return
+		}
+		writes = append(writes, w)
+	}
+	return
+}
+
+// readUsers kept by the store.
+func readUsers(s store) (list []*user, err error) {
+	err = s.each(usersBucket, func(id uint64, value []byte) error {
+		u := &user{stored: location{bucket: usersBucket, id: id}}
+		list = append(list, u)
+		return json.Unmarshal(value, u)
+	})
+	return
+}
+
+// readFiles kept by the store in the bucket.
+func readFiles(s store, bucket string) (list []*File, err error) {
+	err = s.each(bucket, func(id uint64, value []byte) error {
+		f := &File{stored: location{bucket: bucket, id: id}}
+		list = append(list, f)
+		return json.Unmarshal(value, f)
+	})
+	return
+}
+
+// readTrash items kept by the store.
+func readTrash(s store) (list []*TrashItem, err error) {
+	err = s.each(trashBucket, func(id uint64, value []byte) error {
+		item := &TrashItem{stored: location{bucket: trashBucket, id: id}}
+		list = append(list, item)
+		return json.Unmarshal(value, item)
+	})
+	return
+}
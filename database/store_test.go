@@ -0,0 +1,429 @@
+package database
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/halverneus/example/config"
+)
+
+// TestStores keep files, versions, the trash and users across reloads with
+// every database driver.
+func TestStores(t *testing.T) {
+	for _, driver := range []string{"json", "bolt", "sqlite"} {
+		t.Run(driver, func(t *testing.T) { testStore(t, driver) })
+	}
+}
+
+// testStore of the driver, reloading the database between changes.
+func testStore(t *testing.T, driver string) {
+	// Load the database. Delete everything on completion, including files kept
+	// by SQLite alongside the database.
+	filename := driver + ".db"
+	if err := Load(driver, filename); nil != err {
+		t.Fatalf("While loading database: %v\n", err)
+	}
+	defer func() {
+		matches, _ := filepath.Glob(filename + "*")
+		for _, match := range matches {
+			os.Remove(match)
+		}
+	}()
+
+	// reload the database from disk.
+	reload := func() {
+		if err := Load(driver, filename); nil != err {
+			t.Fatalf("While reloading database: %v\n", err)
+		}
+	}
+
+	// add a file with the contents to the path, stored at a key named after
+	// the contents. Files are versioned when given a version ID.
+	add := func(filePath, contents, versionID string) {
+		f := &File{
+			Path:      filePath,
+			Uploader:  "john",
+			Modified:  time.Now().UTC(),
+			Size:      int64(len(contents)),
+			Key:       "/objects/" + contents,
+			VersionID: versionID,
+		}
+		if err := AddFile(f); nil != err {
+			t.Fatalf("Failed to add %s with: %v\n", filePath, err)
+		}
+	}
+
+	// trash the file at the path.
+	trash := func(filePath string) {
+		item := &TrashItem{ID: "trash" + filePath, DeletedBy: "john", Deleted: time.Now().UTC()}
+		if err := TrashFile(filePath, item); nil != err {
+			t.Fatalf("Failed to trash %s with: %v\n", filePath, err)
+		}
+	}
+
+	// contents of the file at the path.
+	contents := func(filePath string) string {
+		f, err := GetMetadata(filePath)
+		if nil != err {
+			t.Errorf("Failed to find %s with: %v\n", filePath, err)
+			return ""
+		}
+		return strings.TrimPrefix(f.Key, "/objects/")
+	}
+
+	// versions of the path, newest first.
+	versions := func(filePath string) (ids []string) {
+		list, err := ListVersions(filePath)
+		if nil != err {
+			t.Fatalf("Failed to list versions of %s with: %v\n", filePath, err)
+		}
+		for _, f := range list {
+			ids = append(ids, f.Version())
+		}
+		return
+	}
+
+	// trashed items, oldest first.
+	trashed := func() (items []string) {
+		for _, item := range ListTrash() {
+			items = append(items, item.ID+" "+item.File.Path)
+		}
+		return
+	}
+
+	// Users, past versions, delete markers and the trash all survive a reload.
+	if err := AddUser("john", "12345678"); nil != err {
+		t.Fatalf("Failed to add user with: %v\n", err)
+	}
+	if err := UpdateUserPassword("john", "87654321"); nil != err {
+		t.Fatalf("Failed to change password with: %v\n", err)
+	}
+	for i, c := range []string{"one", "two", "three"} {
+		add("/docs/a.txt", c, fmt.Sprintf("v%d", i+1))
+	}
+	marker := &File{Path: "/docs/a.txt", Modified: time.Now().UTC(), VersionID: "v4", DeleteMarker: true}
+	if err := AddDeleteMarker(marker); nil != err {
+		t.Fatalf("Failed to add delete marker with: %v\n", err)
+	}
+	add("/b.txt", "one", "")
+	add("/b.txt", "two", "")
+	add("/c.txt", "one", "")
+	add("/d.txt", "one", "")
+	trash("/c.txt")
+	trash("/d.txt")
+	ids, items := versions("/docs/a.txt"), trashed()
+	if 4 != len(ids) || 2 != len(items) {
+		t.Fatalf("Expected 4 versions and 2 items in the trash and got %v and %v\n", ids, items)
+	}
+
+	reload()
+	if !AuthenticateUser("john", "87654321") {
+		t.Errorf("Expected the changed password to be kept\n")
+	}
+	if reloaded := versions("/docs/a.txt"); fmt.Sprint(ids) != fmt.Sprint(reloaded) {
+		t.Errorf("Expected versions %v and got %v\n", ids, reloaded)
+	}
+	if reloaded := trashed(); fmt.Sprint(items) != fmt.Sprint(reloaded) {
+		t.Errorf("Expected the trash %v and got %v\n", items, reloaded)
+	}
+	if c := contents("/b.txt"); "two" != c {
+		t.Errorf("Expected %q and got %q\n", "two", c)
+	}
+
+	// Records moving between files, versions and the trash are kept once.
+	if err := RemoveVersion("/docs/a.txt", ids[0]); nil != err {
+		t.Fatalf("Failed to remove the delete marker with: %v\n", err)
+	}
+	if _, err := RestoreTrash(strings.Fields(items[0])[0]); nil != err {
+		t.Fatalf("Failed to restore from the trash with: %v\n", err)
+	}
+	if err := AddUser("jane", "12345678"); nil != err {
+		t.Fatalf("Failed to add user with: %v\n", err)
+	}
+	if err := RemoveUser("jane"); nil != err {
+		t.Fatalf("Failed to remove user with: %v\n", err)
+	}
+
+	reload()
+	if AuthenticateUser("jane", "12345678") || !AuthenticateUser("john", "87654321") {
+		t.Errorf("Expected only the remaining user to be kept\n")
+	}
+	if c := contents("/docs/a.txt"); "three" != c {
+		t.Errorf("Expected %q and got %q\n", "three", c)
+	}
+	if reloaded := versions("/docs/a.txt"); fmt.Sprint(ids[1:]) != fmt.Sprint(reloaded) {
+		t.Errorf("Expected versions %v and got %v\n", ids[1:], reloaded)
+	}
+	if reloaded := trashed(); fmt.Sprint(items[1:]) != fmt.Sprint(reloaded) {
+		t.Errorf("Expected the trash %v and got %v\n", items[1:], reloaded)
+	}
+	if c := contents("/c.txt"); "one" != c {
+		t.Errorf("Expected %q and got %q\n", "one", c)
+	}
+	if 3 != len(ListFiles()) {
+		t.Errorf("Expected 3 files and got %d\n", len(ListFiles()))
+	}
+}
+
+// TestJournal replays changes to the JSON database after a crash.
+func TestJournal(t *testing.T) {
+	// Load the database. Delete everything on completion.
+	if err := Load("json", "journal.db"); nil != err {
+		t.Fatalf("While loading database: %v\n", err)
+	}
+	defer func() {
+		matches, _ := filepath.Glob("journal.db*")
+		for _, match := range matches {
+			os.Remove(match)
+		}
+	}()
+
+	// add a file with the contents to the path.
+	add := func(path, contents string) {
+		f := &File{Path: path, Uploader: "john", Modified: time.Now().UTC(), Size: int64(len(contents))}
+		if err := AddFile(f); nil != err {
+			t.Fatalf("Failed to add %s with: %v\n", path, err)
+		}
+	}
+
+	// expect the paths to be in the database.
+	expect := func(paths ...string) {
+		files := []string{}
+		for _, f := range ListFiles() {
+			files = append(files, f.Path)
+		}
+		if fmt.Sprint(paths) != fmt.Sprint(files) {
+			t.Errorf("Expected files %v and got %v\n", paths, files)
+		}
+	}
+
+	// Changes are journaled rather than rewriting the database, and replayed
+	// after a crash, which is loading again without closing.
+	add("/a.txt", "one")
+	add("/b.txt", "two")
+	if info, err := os.Stat("journal.db.journal"); nil != err || 0 == info.Size() {
+		t.Fatalf("Expected changes in the journal and got: %v\n", err)
+	}
+	snapshot, err := ioutil.ReadFile("journal.db")
+	if nil != err {
+		t.Fatalf("Failed to read snapshot with: %v\n", err)
+	}
+	journal, err := ioutil.ReadFile("journal.db.journal")
+	if nil != err {
+		t.Fatalf("Failed to read journal with: %v\n", err)
+	}
+	if err := Load("json", "journal.db"); nil != err {
+		t.Fatalf("While replaying journal: %v\n", err)
+	}
+	expect("/a.txt", "/b.txt")
+
+	// Loading compacts the journal into a new snapshot.
+	if info, err := os.Stat("journal.db.journal"); nil != err || 0 != info.Size() {
+		t.Errorf("Expected an empty journal and got: %v\n", err)
+	}
+
+	// A change torn by a crash while appending it is skipped, while one torn
+	// in the middle of the journal can't be trusted.
+	ioutil.WriteFile("journal.db", snapshot, 0666)
+	ioutil.WriteFile("journal.db.journal", append(journal, `{"writes": [{"buck`...), 0666)
+	if err := Load("json", "journal.db"); nil != err {
+		t.Fatalf("While replaying journal: %v\n", err)
+	}
+	expect("/a.txt", "/b.txt")
+	ioutil.WriteFile("journal.db", snapshot, 0666)
+	ioutil.WriteFile("journal.db.journal", append([]byte(`{"writes": [{"buck`+"\n"), journal...), 0666)
+	if err := Load("json", "journal.db"); nil == err {
+		t.Errorf("Expected an error replaying a corrupt journal\n")
+	}
+}
+
+// TestSchema versions migrated when loaded or on demand, refusing newer ones.
+func TestSchema(t *testing.T) {
+	defer func() {
+		matches, _ := filepath.Glob("schema.db*")
+		for _, match := range matches {
+			os.Remove(match)
+		}
+	}()
+
+	// expectBackup of the original database, removing it.
+	expectBackup := func(backup string, original []byte) {
+		if raw, err := ioutil.ReadFile(backup); nil != err || string(original) != string(raw) {
+			t.Errorf("Expected the original database backed up and got %s with: %v\n", raw, err)
+		}
+		for _, suffix := range []string{"", ".journal"} {
+			os.Remove(backup + suffix)
+		}
+	}
+
+	// Databases written before schema versioning are migrated when loaded,
+	// keeping a backup of the original.
+	legacy := []byte(`{"users": [], "files": [{"path": "/a.txt", "size": 3}], "versions": [], "trash": []}`)
+	if err := ioutil.WriteFile("schema.db", legacy, 0666); nil != err {
+		t.Fatalf("Failed to write database with: %v\n", err)
+	}
+	if err := Load("json", "schema.db"); nil != err || 1 != len(ListFiles()) {
+		t.Fatalf("Expected the legacy database to load and got: %v\n", err)
+	}
+	backups, _ := filepath.Glob("schema.db.backup-*[0-9]")
+	if 1 != len(backups) {
+		t.Fatalf("Expected a single backup and got %v\n", backups)
+	}
+	expectBackup(backups[0], legacy)
+
+	// Or on demand, with the database not loaded.
+	if err := Load("json", "other.db"); nil != err {
+		t.Fatalf("While loading database: %v\n", err)
+	}
+	defer os.Remove("other.db")
+	defer os.Remove("other.db.journal")
+	os.Remove("schema.db.journal")
+	if err := ioutil.WriteFile("schema.db", legacy, 0666); nil != err {
+		t.Fatalf("Failed to write database with: %v\n", err)
+	}
+	migrated, expected := []int{}, []int{}
+	for version := 1; version <= SchemaVersion; version++ {
+		expected = append(expected, version)
+	}
+	from, backup, err := Migrate("json", "schema.db", func(version int, description string) {
+		migrated = append(migrated, version)
+	})
+	if nil != err || 0 != from || fmt.Sprint(expected) != fmt.Sprint(migrated) {
+		t.Fatalf("Expected migrations %v from version 0 and got %v from %d with: %v\n", expected, migrated, from, err)
+	}
+	expectBackup(backup, legacy)
+
+	// Empty databases start at the current version without a backup.
+	if err := Load("json", "other.db"); nil != err {
+		t.Fatalf("While reloading database: %v\n", err)
+	}
+	if backups, _ = filepath.Glob("other.db.backup-*"); 0 != len(backups) {
+		t.Errorf("Expected no backup of an empty database and got %v\n", backups)
+	}
+
+	// Migrating again has nothing to do and keeps no backup.
+	if _, backup, err := Migrate("json", "schema.db", func(int, string) {}); nil != err || "" != backup {
+		t.Errorf("Expected nothing to migrate and got backup %q with: %v\n", backup, err)
+	}
+	if err := Load("json", "schema.db"); nil != err || 1 != len(ListFiles()) {
+		t.Fatalf("Expected the migrated database to load and got: %v\n", err)
+	}
+
+	// Databases in use aren't migrated.
+	if _, _, err := Migrate("json", "schema.db", func(int, string) {}); ErrInUse != err {
+		t.Errorf("Expected the database to be in use and got: %v\n", err)
+	}
+
+	// Databases written by newer versions are refused and left alone.
+	newer := []byte(`{"users": [], "files": [], "versions": [], "trash": [], "schema": 999}`)
+	if err := ioutil.WriteFile("schema.db", newer, 0666); nil != err {
+		t.Fatalf("Failed to write database with: %v\n", err)
+	}
+	if err := Load("json", "schema.db"); nil == err {
+		t.Errorf("Expected a newer schema to be refused\n")
+	}
+	if _, _, err := Migrate("json", "schema.db", func(int, string) {}); nil == err {
+		t.Errorf("Expected a newer schema not to migrate\n")
+	}
+	if raw, err := ioutil.ReadFile("schema.db"); nil != err || string(newer) != string(raw) {
+		t.Errorf("Expected the newer database left alone and got %s with: %v\n", raw, err)
+	}
+}
+
+// TestLegacy database written before this version, whose files are given a
+// modification time from their creation time and a size from storage.
+func TestLegacy(t *testing.T) {
+	// Keep contents in a folder. Delete everything on completion.
+	const folder = "legacy-storage"
+	original := config.Get.Storage.Folder
+	config.Get.Storage.Folder = folder
+	defer func() {
+		config.Get.Storage.Folder = original
+		os.RemoveAll(folder)
+		matches, _ := filepath.Glob("legacy.db*")
+		for _, match := range matches {
+			os.Remove(match)
+		}
+	}()
+
+	// A file created today with contents, one missing from storage and one with
+	// an unreadable creation time, as written by the first version.
+	if err := os.MkdirAll(folder, 0755); nil != err {
+		t.Fatalf("Failed to create %s with: %v\n", folder, err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(folder, "a.txt"), []byte("hello"), 0644); nil != err {
+		t.Fatalf("Failed to write contents with: %v\n", err)
+	}
+	created := time.Now().Format(legacyCreatedFormat)
+	legacy := `{"users": [], "files": [` +
+		`{"path": "/a.txt", "content-type": "text/plain", "uploader": "john", "created": "` + created + `"},` +
+		`{"path": "/b.txt", "content-type": "text/plain", "uploader": "john", "created": "` + created + `"},` +
+		`{"path": "/c.txt", "content-type": "text/plain", "uploader": "john", "created": "someday"}]}`
+	if err := ioutil.WriteFile("legacy.db", []byte(legacy), 0666); nil != err {
+		t.Fatalf("Failed to write database with: %v\n", err)
+	}
+
+	// expect the modification time and size of the file at the path.
+	expect := func(filePath string, modified time.Time, size int64) {
+		f, err := GetMetadata(filePath)
+		if nil != err {
+			t.Fatalf("Failed to find %s with: %v\n", filePath, err)
+		}
+		if !modified.Equal(f.Modified) || size != f.Size {
+			t.Errorf("Expected %s modified %s with %d bytes and got %s with %d bytes\n", filePath, modified, size, f.Modified, f.Size)
+		}
+	}
+
+	if err := Load("json", "legacy.db"); nil != err {
+		t.Fatalf("Expected the legacy database to load and got: %v\n", err)
+	}
+	today, _ := time.ParseInLocation(legacyCreatedFormat, created, time.Local)
+	expect("/a.txt", today, 5)
+	expect("/b.txt", today, 0)
+	expect("/c.txt", time.Time{}, 0)
+
+	// The upgrade is kept.
+	if err := Load("json", "legacy.db"); nil != err {
+		t.Fatalf("While reloading database: %v\n", err)
+	}
+	expect("/a.txt", today, 5)
+}
+
+// TestLock of every store, which only one may have open at a time.
+func TestLock(t *testing.T) {
+	for _, driver := range []string{"json", "bolt", "sqlite"} {
+		t.Run(driver, func(t *testing.T) {
+			// Delete everything on completion, including files kept by SQLite
+			// alongside the database.
+			filename := "lock-" + driver + ".db"
+			defer func() {
+				matches, _ := filepath.Glob(filename + "*")
+				for _, match := range matches {
+					os.Remove(match)
+				}
+			}()
+
+			s, err := openStore(driver, filename)
+			if nil != err {
+				t.Fatalf("Failed to open %s with: %v\n", filename, err)
+			}
+			if _, err = openStore(driver, filename); ErrInUse != err {
+				t.Errorf("Expected %s to be in use and got: %v\n", filename, err)
+			}
+
+			// Closing releases the lock.
+			if err = s.close(); nil != err {
+				t.Fatalf("Failed to close %s with: %v\n", filename, err)
+			}
+			if s, err = openStore(driver, filename); nil != err {
+				t.Fatalf("Failed to open %s again with: %v\n", filename, err)
+			}
+			s.close()
+		})
+	}
+}
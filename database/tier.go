@@ -0,0 +1,59 @@
+package database
+
+import "time"
+
+const (
+	// ColdTier holds contents moved off the fast storage. Files without a tier
+	// are in the hot tier.
+	ColdTier = "cold"
+)
+
+// LastAccess of the file, which is when it was uploaded until first
+// downloaded. Zero when unknown, for files recorded before modification times
+// with a creation time that couldn't be read.
+func (f *File) LastAccess() time.Time {
+	if f.Accessed.IsZero() {
+		return f.Modified
+	}
+	return f.Accessed
+}
+
+// touch the file, recording a download. Access times are saved along with the
+// next change to the database rather than on every download.
+func touch(f *File) {
+	getMtx.Lock()
+	defer getMtx.Unlock()
+	f.Accessed = time.Now().UTC()
+	changed(f)
+}
+
+// tierOf the file, which changes while tiers are moved.
+func tierOf(f *File) string {
+	getMtx.RLock()
+	defer getMtx.RUnlock()
+	return f.Tier
+}
+
+// setTier of every file with contents at the storage key. Returns the number of
+// files updated.
+func setTier(key, tier string) (count int, err error) {
+	getMtx.Lock()
+	defer getMtx.Unlock()
+
+	for _, f := range stored[key] {
+		f.Tier = tier
+		changed(f)
+		count++
+	}
+	if 0 < count {
+		err = save()
+	}
+	return
+}
+
+// saveAccessed times recorded since the last save.
+func saveAccessed() error {
+	getMtx.Lock()
+	defer getMtx.Unlock()
+	return save()
+}
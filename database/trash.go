@@ -0,0 +1,144 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+var (
+	// ErrTrashNotFound is returned for items that aren't in the trash.
+	ErrTrashNotFound = errors.New("item not found in trash")
+
+	// ErrRestoreConflict is returned when restoring an item to a path that has
+	// since been uploaded again.
+	ErrRestoreConflict = errors.New("a file already exists at the path")
+)
+
+// TrashItem is a deleted file kept until restored or purged.
+type TrashItem struct {
+	ID        string    `json:"id"`
+	DeletedBy string    `json:"deleted-by"`
+	Deleted   time.Time `json:"deleted"`
+	File      *File     `json:"file"`
+
+	stored location
+}
+
+// trashFile at the path, moving it from the files to the trash. The contents
+// stay where they are, referred to by the trash item.
+func trashFile(filePath string, item *TrashItem) (err error) {
+	getMtx.Lock()
+	defer getMtx.Unlock()
+
+	var f *File
+	if f, err = getFileFromIndex(filePath); nil != err {
+		return
+	}
+	if indexOf(get.Files, f) < 0 {
+		refreshIndex()
+		err = fmt.Errorf("file at %s not found", filePath)
+		return
+	}
+	if f.Locked() {
+		err = ErrLocked
+		return
+	}
+	get.Files = removeFromList(get.Files, f)
+	removeFileFromIndex(f)
+
+	item.File = f
+	get.Trash = append(get.Trash, item)
+	addTrashToIndex(item)
+	changed(f, item)
+	return save()
+}
+
+// restoreTrash item to its path, unless a file has since been uploaded there.
+func restoreTrash(id string) (file *File, err error) {
+	getMtx.Lock()
+	defer getMtx.Unlock()
+
+	var item *TrashItem
+	if item, err = getTrashFromIndex(id); nil != err {
+		return
+	}
+	if _, found := files[item.File.Path]; found {
+		err = ErrRestoreConflict
+		return
+	}
+	get.Trash = removeFromTrash(get.Trash, item)
+	removeTrashFromIndex(item)
+
+	get.Files = append(get.Files, item.File)
+	addFileToIndex(item.File)
+	changed(item, item.File)
+	file = copyFile(item.File)
+	err = save()
+	return
+}
+
+// purgeTrash items for good when the match function is true, releasing their
+// contents once any downloads complete. Returns the number of items purged.
+func purgeTrash(match func(item *TrashItem) bool) (count int, err error) {
+	getMtx.Lock()
+	defer getMtx.Unlock()
+
+	for _, item := range append([]*TrashItem{}, get.Trash...) {
+		if !match(item) {
+			continue
+		}
+
+		// Refuse once the deletion channel has closed and program is exiting.
+		if !queueDeletion(item.File) {
+			err = errors.New("application is shutting down")
+			break
+		}
+		get.Trash = removeFromTrash(get.Trash, item)
+		removeTrashFromIndex(item)
+		changed(item)
+		count++
+	}
+	if 0 < count {
+		if errX := save(); nil == err {
+			err = errX
+		}
+	}
+	return
+}
+
+// listTrash returns a copy of every item in the trash, oldest first.
+func listTrash() (list []*TrashItem) {
+	getMtx.RLock()
+	defer getMtx.RUnlock()
+
+	list = make([]*TrashItem, len(get.Trash))
+	for i, item := range get.Trash {
+		list[i] = &TrashItem{
+			ID:        item.ID,
+			DeletedBy: item.DeletedBy,
+			Deleted:   item.Deleted,
+			File:      copyFile(item.File),
+		}
+	}
+	return
+}
+
+// inTrash returns true when a file deleted from the path is in the trash.
+func inTrash(filePath string) bool {
+	getMtx.RLock()
+	defer getMtx.RUnlock()
+	return 0 < trashed[filePath]
+}
+
+// removeFromTrash the item, keeping the order of the remaining items.
+func removeFromTrash(list []*TrashItem, item *TrashItem) []*TrashItem {
+	for i, it := range list {
+		if it == item {
+			copy(list[i:], list[i+1:])
+			list[len(list)-1] = nil
+			return list[:len(list)-1]
+		}
+	}
+	return list
+}
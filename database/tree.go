@@ -0,0 +1,242 @@
+package database
+
+import (
+	"sort"
+)
+
+const (
+	// pathTreeDegree is the minimum number of children of every node in the
+	// path tree but the root. Nodes hold between one less and twice one less
+	// files.
+	pathTreeDegree = 32
+	maxNodeFiles   = 2*pathTreeDegree - 1
+	minNodeFiles   = pathTreeDegree - 1
+)
+
+// pathTree is a B-tree of files ordered by path, so that files under a prefix
+// are found without going through every path.
+type pathTree struct {
+	root *pathNode
+}
+
+// pathNode of the tree. Leaves have no children, while the others have one
+// more child than files, each holding the paths between its neighbouring files.
+type pathNode struct {
+	files    []*File
+	children []*pathNode
+}
+
+// set the file at its path, replacing any file already there.
+func (t *pathTree) set(f *File) {
+	if nil == t.root {
+		t.root = &pathNode{files: []*File{f}}
+		return
+	}
+
+	// Split a full root, which is the only way the tree grows taller.
+	if maxNodeFiles <= len(t.root.files) {
+		left := t.root
+		middle, right := left.split(maxNodeFiles / 2)
+		t.root = &pathNode{files: []*File{middle}, children: []*pathNode{left, right}}
+	}
+	t.root.insert(f)
+}
+
+// remove the file at the path, if any.
+func (t *pathTree) remove(filePath string) {
+	if nil == t.root {
+		return
+	}
+	t.root.remove(filePath)
+
+	// An empty root gives way to its only child, which is the only way the
+	// tree grows shorter.
+	if 0 == len(t.root.files) {
+		if 0 < len(t.root.children) {
+			t.root = t.root.children[0]
+		} else {
+			t.root = nil
+		}
+	}
+}
+
+// ascend through the files in order, starting at the first path at or after
+// from, until the function returns false.
+func (t *pathTree) ascend(from string, fn func(f *File) bool) {
+	if nil != t.root {
+		t.root.ascend(from, fn)
+	}
+}
+
+// find the index of the first file at or after the path, and whether it is at
+// the path.
+func (n *pathNode) find(filePath string) (i int, found bool) {
+	i = sort.Search(len(n.files), func(i int) bool { return filePath <= n.files[i].Path })
+	found = i < len(n.files) && filePath == n.files[i].Path
+	return
+}
+
+// split the node at the index, keeping the files before it. Returns the file
+// at the index and a new node with the files after it.
+func (n *pathNode) split(i int) (middle *File, right *pathNode) {
+	middle = n.files[i]
+	right = &pathNode{files: append([]*File{}, n.files[i+1:]...)}
+	for j := i; j < len(n.files); j++ {
+		n.files[j] = nil // Garbage collect the moved files.
+	}
+	n.files = n.files[:i]
+	if 0 < len(n.children) {
+		right.children = append([]*pathNode{}, n.children[i+1:]...)
+		for j := i + 1; j < len(n.children); j++ {
+			n.children[j] = nil
+		}
+		n.children = n.children[:i+1]
+	}
+	return
+}
+
+// insert the file below a node that isn't full, splitting full children on the
+// way down.
+func (n *pathNode) insert(f *File) {
+	i, found := n.find(f.Path)
+	if found {
+		n.files[i] = f
+		return
+	}
+	if 0 == len(n.children) {
+		n.files = insertFileAt(n.files, i, f)
+		return
+	}
+
+	if maxNodeFiles <= len(n.children[i].files) {
+		middle, right := n.children[i].split(maxNodeFiles / 2)
+		n.files = insertFileAt(n.files, i, middle)
+		n.children = insertNodeAt(n.children, i+1, right)
+		switch {
+		case f.Path == middle.Path:
+			n.files[i] = f
+			return
+		case f.Path > middle.Path:
+			i++
+		}
+	}
+	n.children[i].insert(f)
+}
+
+// remove the file at the path below the node, making sure every child gone
+// into has a file to spare.
+func (n *pathNode) remove(filePath string) {
+	i, found := n.find(filePath)
+	switch {
+	case 0 == len(n.children):
+		if found {
+			n.files = removeFileAt(n.files, i)
+		}
+	case minNodeFiles >= len(n.children[i].files):
+		n.grow(i)
+		n.remove(filePath)
+	case found:
+		// Take the place of the file with the last file before it.
+		n.files[i] = n.children[i].removeLast()
+	default:
+		n.children[i].remove(filePath)
+	}
+}
+
+// removeLast file below the node.
+func (n *pathNode) removeLast() (f *File) {
+	if 0 == len(n.children) {
+		f = n.files[len(n.files)-1]
+		n.files = removeFileAt(n.files, len(n.files)-1)
+		return
+	}
+	i := len(n.children) - 1
+	if minNodeFiles >= len(n.children[i].files) {
+		n.grow(i)
+		return n.removeLast()
+	}
+	return n.children[i].removeLast()
+}
+
+// grow the child at the index by a file, borrowing one from a sibling through
+// the node, or else merging it with a sibling.
+func (n *pathNode) grow(i int) {
+	child := n.children[i]
+	switch {
+	case 0 < i && minNodeFiles < len(n.children[i-1].files):
+		left := n.children[i-1]
+		child.files = insertFileAt(child.files, 0, n.files[i-1])
+		n.files[i-1] = left.files[len(left.files)-1]
+		left.files = removeFileAt(left.files, len(left.files)-1)
+		if 0 < len(left.children) {
+			child.children = insertNodeAt(child.children, 0, left.children[len(left.children)-1])
+			left.children = removeNodeAt(left.children, len(left.children)-1)
+		}
+
+	case i < len(n.files) && minNodeFiles < len(n.children[i+1].files):
+		right := n.children[i+1]
+		child.files = append(child.files, n.files[i])
+		n.files[i] = right.files[0]
+		right.files = removeFileAt(right.files, 0)
+		if 0 < len(right.children) {
+			child.children = append(child.children, right.children[0])
+			right.children = removeNodeAt(right.children, 0)
+		}
+
+	default:
+		// Merge with the next sibling, or the previous one for the last child.
+		if len(n.files) <= i {
+			i--
+		}
+		left, right := n.children[i], n.children[i+1]
+		left.files = append(append(left.files, n.files[i]), right.files...)
+		left.children = append(left.children, right.children...)
+		n.files = removeFileAt(n.files, i)
+		n.children = removeNodeAt(n.children, i+1)
+	}
+}
+
+// ascend through the files below the node like the tree. Returns false once
+// the function did.
+func (n *pathNode) ascend(from string, fn func(f *File) bool) bool {
+	i, _ := n.find(from)
+	for ; i <= len(n.files); i++ {
+		if 0 < len(n.children) && !n.children[i].ascend(from, fn) {
+			return false
+		}
+		if i < len(n.files) && !fn(n.files[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// insertFileAt into the list at the index.
+func insertFileAt(list []*File, i int, f *File) []*File {
+	list = append(list, nil)
+	copy(list[i+1:], list[i:])
+	list[i] = f
+	return list
+}
+
+// removeFileAt from the list at the index.
+func removeFileAt(list []*File, i int) []*File {
+	copy(list[i:], list[i+1:])
+	list[len(list)-1] = nil
+	return list[:len(list)-1]
+}
+
+// insertNodeAt into the list at the index.
+func insertNodeAt(list []*pathNode, i int, n *pathNode) []*pathNode {
+	list = append(list, nil)
+	copy(list[i+1:], list[i:])
+	list[i] = n
+	return list
+}
+
+// removeNodeAt from the list at the index.
+func removeNodeAt(list []*pathNode, i int) []*pathNode {
+	copy(list[i:], list[i+1:])
+	list[len(list)-1] = nil
+	return list[:len(list)-1]
+}
@@ -0,0 +1,131 @@
+package database
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"testing"
+)
+
+// TestPathTree against a sorted slice while randomly setting and removing
+// enough paths to split, borrow between and merge nodes several levels deep,
+// then removing every path.
+func TestPathTree(t *testing.T) {
+	random := rand.New(rand.NewSource(1))
+	tree := &pathTree{}
+	expected := map[string]*File{}
+
+	// randomPath out of a pool large enough for a tree three levels deep.
+	randomPath := func() string {
+		return fmt.Sprintf("/folder%02d/file%05d", random.Intn(20), random.Intn(2000))
+	}
+
+	// sorted paths expected in the tree.
+	sorted := func() []string {
+		paths := make([]string, 0, len(expected))
+		for filePath := range expected {
+			paths = append(paths, filePath)
+		}
+		sort.Strings(paths)
+		return paths
+	}
+
+	// verify the tree holds the expected files in order, ascending from the
+	// start and from a random path until stopping partway, and that every node
+	// but the root holds between the minimum and maximum number of files with
+	// every leaf at the same depth.
+	verify := func(when string) {
+		paths := sorted()
+		got := []*File{}
+		tree.ascend("", func(f *File) bool {
+			got = append(got, f)
+			return true
+		})
+		if len(paths) != len(got) {
+			t.Fatalf("Expected %d files and got %d (%s)\n", len(paths), len(got), when)
+		}
+		for i, f := range got {
+			if expected[paths[i]] != f {
+				t.Fatalf("Expected %s at %d and got %s (%s)\n", paths[i], i, f.Path, when)
+			}
+		}
+
+		from := randomPath()
+		start := sort.SearchStrings(paths, from)
+		count := 0
+		tree.ascend(from, func(f *File) bool {
+			if start+count >= len(paths) || paths[start+count] != f.Path {
+				t.Fatalf("Expected files from %s in order and got %s (%s)\n", from, f.Path, when)
+			}
+			count++
+			return count < 100
+		})
+		if remaining := len(paths) - start; count != remaining && 100 != count {
+			t.Fatalf("Expected to stop after %d files from %s and got %d (%s)\n", remaining, from, count, when)
+		}
+
+		leafDepth := -1
+		var check func(n *pathNode, depth int)
+		check = func(n *pathNode, depth int) {
+			if (tree.root != n && minNodeFiles > len(n.files)) || maxNodeFiles < len(n.files) {
+				t.Fatalf("Node at depth %d holds %d files (%s)\n", depth, len(n.files), when)
+			}
+			if 0 == len(n.children) {
+				if -1 == leafDepth {
+					leafDepth = depth
+				}
+				if leafDepth != depth {
+					t.Fatalf("Leaves at depths %d and %d (%s)\n", leafDepth, depth, when)
+				}
+				return
+			}
+			if len(n.files)+1 != len(n.children) {
+				t.Fatalf("Node with %d files has %d children (%s)\n", len(n.files), len(n.children), when)
+			}
+			for _, child := range n.children {
+				check(child, depth+1)
+			}
+		}
+		if nil != tree.root {
+			check(tree.root, 0)
+		}
+	}
+
+	// Mostly set paths at first, replacing some, then mostly remove them.
+	for round, setChance := range []int{80, 50, 20} {
+		for i := 0; i < 20000; i++ {
+			filePath := randomPath()
+			if random.Intn(100) < setChance {
+				f := &File{Path: filePath}
+				tree.set(f)
+				expected[filePath] = f
+			} else {
+				tree.remove(filePath)
+				delete(expected, filePath)
+			}
+			if 0 == i%2000 {
+				verify(fmt.Sprintf("round %d after %d changes", round, i))
+			}
+		}
+		verify(fmt.Sprintf("end of round %d", round))
+	}
+
+	// Removing every path in random order empties the tree.
+	paths := sorted()
+	random.Shuffle(len(paths), func(i, j int) { paths[i], paths[j] = paths[j], paths[i] })
+	for i, filePath := range paths {
+		tree.remove(filePath)
+		delete(expected, filePath)
+		if 0 == i%500 {
+			verify(fmt.Sprintf("after removing %d files", i))
+		}
+	}
+	verify("after removing every file")
+	if nil != tree.root {
+		t.Errorf("Expected an empty tree and got a root with %d files\n", len(tree.root.files))
+	}
+
+	// Removing from an empty tree does nothing.
+	tree.remove("/missing")
+	verify("after removing from an empty tree")
+}
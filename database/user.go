@@ -12,6 +12,8 @@ type user struct {
 	Username string `json:"username"`
 	Salt     string `json:"salt"`
 	Password string `json:"password"`
+
+	stored location
 }
 
 // newUser with random salt applied.
@@ -77,6 +79,7 @@ func addUser(u *user) (err error) {
 	// Add user to database and index.
 	get.Users = append(get.Users, u)
 	addUserToIndex(u)
+	changed(u)
 
 	return save()
 }
@@ -122,6 +125,7 @@ func removeUser(username string) (err error) {
 
 	// Update index.
 	removeUserFromIndex(username)
+	changed(u)
 
 	return save()
 }
@@ -141,6 +145,7 @@ func setPassword(username, password string) (err error) {
 	if err = u.setPassword(password); nil != err {
 		return
 	}
+	changed(u)
 
 	return save()
 }
@@ -0,0 +1,170 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+)
+
+const (
+	// NullVersion identifies files uploaded without versioning.
+	NullVersion = "null"
+)
+
+// Version ID of the file, which is NullVersion for files uploaded without
+// versioning.
+func (f *File) Version() string {
+	if "" == f.VersionID {
+		return NullVersion
+	}
+	return f.VersionID
+}
+
+// IsVersion returns true when the file is the version with the ID.
+func (f *File) IsVersion(versionID string) bool {
+	return versionID == f.Version()
+}
+
+// addVersion to the history of its path.
+func addVersion(f *File) {
+	get.Versions = append(get.Versions, f)
+	addVersionToIndex(f)
+	changed(f)
+}
+
+// addDeleteMarker as the latest version of a path. The current version becomes
+// part of the history, leaving the path without a file.
+func addDeleteMarker(marker *File) (err error) {
+	getMtx.Lock()
+	defer getMtx.Unlock()
+
+	var orig *File
+	if orig, err = getFileFromIndex(marker.Path); nil != err {
+		return
+	}
+	if indexOf(get.Files, orig) < 0 {
+		refreshIndex()
+		err = fmt.Errorf("file at %s not found", marker.Path)
+		return
+	}
+	if orig.Locked() {
+		err = ErrLocked
+		return
+	}
+	get.Files = removeFromList(get.Files, orig)
+	removeFileFromIndex(orig)
+	addVersion(orig)
+	addVersion(marker)
+	return save()
+}
+
+// removeVersion of a file for good, releasing its contents once any downloads
+// complete. When the version removed was the latest, the next newest version
+// takes its place, so removing a delete marker brings the file back.
+func removeVersion(filePath, versionID string) (err error) {
+	getMtx.Lock()
+	defer getMtx.Unlock()
+
+	var f *File
+	if f, err = getVersionFromIndex(filePath, versionID); nil != err {
+		return
+	}
+	if f.Locked() {
+		err = ErrLocked
+		return
+	}
+
+	// Refuse once the deletion channel has closed and program is exiting.
+	if !f.DeleteMarker && !queueDeletion(f) {
+		err = errors.New("application is shutting down")
+		return
+	}
+	if files[filePath] == f {
+		get.Files = removeFromList(get.Files, f)
+		removeFileFromIndex(f)
+	} else {
+		get.Versions = removeFromList(get.Versions, f)
+		removeVersionFromIndex(f)
+	}
+	changed(f)
+
+	promoteLatest(filePath)
+	return save()
+}
+
+// promoteLatest version of a path without a current file, unless it is a delete
+// marker.
+func promoteLatest(filePath string) {
+	if _, found := files[filePath]; found {
+		return
+	}
+	versions := history[filePath]
+	if 0 == len(versions) {
+		return
+	}
+	latest := versions[len(versions)-1]
+	if latest.DeleteMarker {
+		return
+	}
+	get.Versions = removeFromList(get.Versions, latest)
+	removeVersionFromIndex(latest)
+	get.Files = append(get.Files, latest)
+	addFileToIndex(latest)
+	changed(latest)
+}
+
+// listVersions of a path, newest first, starting with the current version.
+func listVersions(filePath string) (versions []*File, err error) {
+	getMtx.RLock()
+	defer getMtx.RUnlock()
+
+	if f, found := files[filePath]; found {
+		versions = append(versions, copyFile(f))
+	}
+	past := history[filePath]
+	for i := len(past) - 1; 0 <= i; i-- {
+		versions = append(versions, copyFile(past[i]))
+	}
+	if 0 == len(versions) {
+		err = fmt.Errorf("file at %s not found", filePath)
+	}
+	return
+}
+
+// listHistory returns a copy of every version that is not current, leaving out
+// delete markers.
+func listHistory() (list []*File) {
+	getMtx.RLock()
+	defer getMtx.RUnlock()
+
+	for _, f := range get.Versions {
+		if !f.DeleteMarker {
+			list = append(list, copyFile(f))
+		}
+	}
+	return
+}
+
+// getVersionForDownload so that a deletion needs to wait.
+func getVersionForDownload(filePath, versionID string) (file *File, err error) {
+	getMtx.RLock()
+	defer getMtx.RUnlock()
+
+	if file, err = getVersionFromIndex(filePath, versionID); nil != err {
+		return
+	}
+	if file.DeleteMarker {
+		file, err = nil, fmt.Errorf("version %s of %s is a delete marker", versionID, filePath)
+		return
+	}
+
+	// Lock file for download.
+	file.mtx.RLock()
+	return
+}
+
+// hasHistory returns true when the path has versions other than the current.
+func hasHistory(filePath string) bool {
+	getMtx.RLock()
+	defer getMtx.RUnlock()
+	return 0 < len(history[filePath])
+}
@@ -0,0 +1,163 @@
+// Package codec compresses and decompresses contents with the content codings
+// supported for storage at rest. Names match the HTTP "Content-Encoding" tokens
+// so compressed contents can be handed to clients as-is.
+package codec
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	// Gzip codec.
+	Gzip = "gzip"
+	// Zstd codec.
+	Zstd = "zstd"
+)
+
+// Valid returns an error when the codec name is not supported.
+func Valid(name string) error {
+	switch name {
+	case Gzip, Zstd:
+		return nil
+	}
+	return fmt.Errorf("unknown compression codec: %s", name)
+}
+
+// NewWriter compressing into the writer. Close flushes the remaining contents
+// but does not close the underlying writer.
+func NewWriter(name string, w io.Writer) (wc io.WriteCloser, err error) {
+	switch name {
+	case Gzip:
+		wc = gzip.NewWriter(w)
+	case Zstd:
+		wc, err = zstd.NewWriter(w)
+	default:
+		err = Valid(name)
+	}
+	return
+}
+
+// NewReader decompressing from the reader.
+func NewReader(name string, r io.Reader) (rc io.ReadCloser, err error) {
+	switch name {
+	case Gzip:
+		rc, err = gzip.NewReader(r)
+	case Zstd:
+		var d *zstd.Decoder
+		if d, err = zstd.NewReader(r); nil != err {
+			return
+		}
+		rc = d.IOReadCloser()
+	default:
+		err = Valid(name)
+	}
+	return
+}
+
+// Compress the contents of the reader. Reading the result yields the compressed
+// stream. Always close the result, which stops compressing if reading ends
+// early.
+func Compress(name string, r io.Reader) io.ReadCloser {
+	pr, pw := io.Pipe()
+	go func() {
+		w, err := NewWriter(name, pw)
+		if nil != err {
+			pw.CloseWithError(err)
+			return
+		}
+		_, err = io.Copy(w, r)
+		if errX := w.Close(); nil == err {
+			err = errX // The encoder is always closed, freeing its goroutines.
+		}
+		pw.CloseWithError(err) // A nil error closes normally.
+	}()
+	return pr
+}
+
+// Decoder reads the decompressed contents of a compressed, seekable source.
+// Seeking forward decompresses and discards; seeking backwards restarts from
+// the beginning of the source. Size is the decompressed size.
+type Decoder struct {
+	name   string
+	src    io.ReadSeeker
+	size   int64
+	r      io.ReadCloser
+	pos    int64
+	offset int64
+}
+
+// NewDecoder of the source, which decompresses to size bytes.
+func NewDecoder(name string, src io.ReadSeeker, size int64) (d *Decoder, err error) {
+	if err = Valid(name); nil != err {
+		return
+	}
+	d = &Decoder{name: name, src: src, size: size}
+	return
+}
+
+// Read decompressed contents from the current offset.
+func (d *Decoder) Read(p []byte) (n int, err error) {
+	if d.offset >= d.size {
+		return 0, io.EOF
+	}
+
+	// Restart from the beginning when moving backwards.
+	if nil == d.r || d.offset < d.pos {
+		d.Close()
+		if _, err = d.src.Seek(0, io.SeekStart); nil != err {
+			return
+		}
+		if d.r, err = NewReader(d.name, d.src); nil != err {
+			return
+		}
+		d.pos = 0
+	}
+
+	// Skip forward to the offset.
+	if d.offset > d.pos {
+		var skipped int64
+		skipped, err = io.CopyN(ioutil.Discard, d.r, d.offset-d.pos)
+		d.pos += skipped
+		if nil != err {
+			return
+		}
+	}
+
+	n, err = d.r.Read(p)
+	d.pos += int64(n)
+	d.offset = d.pos
+	return
+}
+
+// Seek to a decompressed offset. Nothing is decompressed until the next read.
+func (d *Decoder) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += d.offset
+	case io.SeekEnd:
+		offset += d.size
+	default:
+		return d.offset, fmt.Errorf("invalid whence: %d", whence)
+	}
+	if 0 > offset {
+		return d.offset, errors.New("negative position")
+	}
+	d.offset = offset
+	return d.offset, nil
+}
+
+// Close the decompressor. The source is left open.
+func (d *Decoder) Close() (err error) {
+	if nil != d.r {
+		err = d.r.Close()
+		d.r = nil
+	}
+	return
+}
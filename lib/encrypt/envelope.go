@@ -0,0 +1,295 @@
+package encrypt
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+const (
+	// KeySize of master and data keys in bytes (AES-256).
+	KeySize = 32
+
+	// chunkSize of plaintext sealed at a time. Contents are split into chunks so
+	// they can be decrypted starting anywhere, which keeps range requests cheap.
+	chunkSize = 64 * 1024
+	// tagSize added to every sealed chunk.
+	tagSize = 16
+)
+
+// NewKey of random bytes for use as a master or data key.
+func NewKey() (key []byte, err error) {
+	key = make([]byte, KeySize)
+	if _, err = io.ReadFull(rand.Reader, key); nil != err {
+		key = nil
+	}
+	return
+}
+
+// LoadKeyFile reads a master key stored as hex, Base64 or raw bytes.
+func LoadKeyFile(filename string) (key []byte, err error) {
+	var raw []byte
+	if raw, err = ioutil.ReadFile(filename); nil != err {
+		return
+	}
+	text := strings.TrimSpace(string(raw))
+
+	switch {
+	case hex.EncodedLen(KeySize) == len(text):
+		key, err = hex.DecodeString(text)
+	case base64.StdEncoding.EncodedLen(KeySize) == len(text):
+		key, err = base64.StdEncoding.DecodeString(text)
+	case KeySize == len(raw):
+		key = raw
+	default:
+		err = fmt.Errorf("key file %s must hold a %d byte key", filename, KeySize)
+	}
+	return
+}
+
+// SaveKeyFile writes the key as hex, readable only by the owner. Existing files
+// are never overwritten.
+func SaveKeyFile(filename string, key []byte) (err error) {
+	var file *os.File
+	if file, err = os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600); nil != err {
+		return
+	}
+	if _, err = file.WriteString(hex.EncodeToString(key) + "\n"); nil != err {
+		file.Close()
+		return
+	}
+	return file.Close()
+}
+
+// KeyID is a short fingerprint identifying a master key without revealing it.
+func KeyID(key []byte) string {
+	sum := sha256.Sum256(key)
+	return hex.EncodeToString(sum[:8])
+}
+
+// WrapKey encrypts a data key with the master key, returning Base64.
+func WrapKey(master, dataKey []byte) (wrapped string, err error) {
+	var aead cipher.AEAD
+	if aead, err = newGCM(master); nil != err {
+		return
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err = io.ReadFull(rand.Reader, nonce); nil != err {
+		return
+	}
+	sealed := aead.Seal(nonce, nonce, dataKey, nil)
+	wrapped = base64.StdEncoding.EncodeToString(sealed)
+	return
+}
+
+// UnwrapKey decrypts a data key wrapped with the master key.
+func UnwrapKey(master []byte, wrapped string) (dataKey []byte, err error) {
+	var aead cipher.AEAD
+	if aead, err = newGCM(master); nil != err {
+		return
+	}
+	var sealed []byte
+	if sealed, err = base64.StdEncoding.DecodeString(wrapped); nil != err {
+		return
+	}
+	if len(sealed) < aead.NonceSize() {
+		err = errors.New("wrapped key is too short")
+		return
+	}
+	nonce := sealed[:aead.NonceSize()]
+	if dataKey, err = aead.Open(nil, nonce, sealed[aead.NonceSize():], nil); nil != err {
+		err = errors.New("unable to unwrap data key; wrong master key?")
+	}
+	return
+}
+
+// newGCM cipher for the key.
+func newGCM(key []byte) (aead cipher.AEAD, err error) {
+	var block cipher.Block
+	if block, err = aes.NewCipher(key); nil != err {
+		return
+	}
+	return cipher.NewGCM(block)
+}
+
+// chunkNonce is the chunk index with the high bit of the first byte marking the
+// final chunk, so truncated or reordered contents fail to decrypt.
+func chunkNonce(index uint64, final bool) []byte {
+	nonce := make([]byte, 12)
+	binary.BigEndian.PutUint64(nonce[4:], index)
+	if final {
+		nonce[0] = 0x80
+	}
+	return nonce
+}
+
+// Encrypter reads plaintext from a source and yields sealed chunks.
+type Encrypter struct {
+	aead  cipher.AEAD
+	src   *bufio.Reader
+	index uint64
+	chunk []byte
+	out   []byte
+	done  bool
+}
+
+// NewEncrypter of the source's contents with a data key. Every data key must
+// only ever encrypt one set of contents.
+func NewEncrypter(dataKey []byte, src io.Reader) (e *Encrypter, err error) {
+	var aead cipher.AEAD
+	if aead, err = newGCM(dataKey); nil != err {
+		return
+	}
+	e = &Encrypter{
+		aead:  aead,
+		src:   bufio.NewReaderSize(src, chunkSize),
+		chunk: make([]byte, chunkSize),
+	}
+	return
+}
+
+// Read encrypted contents.
+func (e *Encrypter) Read(p []byte) (n int, err error) {
+	if 0 == len(e.out) {
+		if e.done {
+			return 0, io.EOF
+		}
+
+		// Fill a chunk, then peek to learn whether it is the last one.
+		var filled int
+		filled, err = io.ReadFull(e.src, e.chunk)
+		if nil != err && io.EOF != err && io.ErrUnexpectedEOF != err {
+			return
+		}
+		final := nil != err
+		if !final {
+			if _, errX := e.src.Peek(1); io.EOF == errX {
+				final = true
+			} else if nil != errX {
+				return 0, errX
+			}
+		}
+		err = nil
+
+		e.out = e.aead.Seal(e.out[:0], chunkNonce(e.index, final), e.chunk[:filled], nil)
+		e.index++
+		e.done = final
+	}
+
+	n = copy(p, e.out)
+	e.out = e.out[n:]
+	return
+}
+
+// Decrypter reads plaintext from sealed chunks in a seekable source.
+type Decrypter struct {
+	aead       cipher.AEAD
+	src        io.ReadSeeker
+	size       int64
+	chunks     int64
+	offset     int64
+	chunk      []byte
+	chunkIndex int64
+}
+
+// NewDecrypter of the sealed contents in the source using the data key.
+func NewDecrypter(dataKey []byte, src io.ReadSeeker) (d *Decrypter, err error) {
+	var aead cipher.AEAD
+	if aead, err = newGCM(dataKey); nil != err {
+		return
+	}
+
+	// Work out the plaintext size from the sealed size.
+	var sealed int64
+	if sealed, err = src.Seek(0, io.SeekEnd); nil != err {
+		return
+	}
+	chunks := (sealed + chunkSize + tagSize - 1) / (chunkSize + tagSize)
+	if 0 == chunks || sealed < chunks*tagSize {
+		err = errors.New("encrypted contents are truncated")
+		return
+	}
+
+	d = &Decrypter{
+		aead:       aead,
+		src:        src,
+		size:       sealed - chunks*tagSize,
+		chunks:     chunks,
+		chunkIndex: -1,
+	}
+	return
+}
+
+// Size of the plaintext.
+func (d *Decrypter) Size() int64 {
+	return d.size
+}
+
+// Read plaintext from the current offset.
+func (d *Decrypter) Read(p []byte) (n int, err error) {
+	if d.offset >= d.size {
+		return 0, io.EOF
+	}
+
+	// Load the chunk holding the offset.
+	index := d.offset / chunkSize
+	if index != d.chunkIndex {
+		if err = d.load(index); nil != err {
+			return
+		}
+	}
+
+	n = copy(p, d.chunk[d.offset-index*chunkSize:])
+	d.offset += int64(n)
+	return
+}
+
+// load and open a chunk.
+func (d *Decrypter) load(index int64) (err error) {
+	if _, err = d.src.Seek(index*(chunkSize+tagSize), io.SeekStart); nil != err {
+		return
+	}
+	sealed := make([]byte, chunkSize+tagSize)
+	var n int
+	if n, err = io.ReadFull(d.src, sealed); nil != err && io.ErrUnexpectedEOF != err {
+		return
+	}
+
+	final := index == d.chunks-1
+	if d.chunk, err = d.aead.Open(d.chunk[:0], chunkNonce(uint64(index), final), sealed[:n], nil); nil != err {
+		d.chunkIndex = -1
+		err = fmt.Errorf("encrypted contents failed authentication at chunk %d", index)
+		return
+	}
+	d.chunkIndex = index
+	return
+}
+
+// Seek to a plaintext offset.
+func (d *Decrypter) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += d.offset
+	case io.SeekEnd:
+		offset += d.size
+	default:
+		return d.offset, fmt.Errorf("invalid whence: %d", whence)
+	}
+	if 0 > offset {
+		return d.offset, errors.New("negative position")
+	}
+	d.offset = offset
+	return d.offset, nil
+}
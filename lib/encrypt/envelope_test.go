@@ -0,0 +1,102 @@
+package encrypt
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"io/ioutil"
+	"testing"
+)
+
+// TestEnvelope round trips contents of various sizes through encryption and
+// reads them back from arbitrary offsets.
+func TestEnvelope(t *testing.T) {
+	master, err := NewKey()
+	if nil != err {
+		t.Fatalf("Failed to create master key with: %v\n", err)
+	}
+
+	for _, size := range []int{0, 1, chunkSize - 1, chunkSize, chunkSize + 1, 3*chunkSize + 7} {
+		plain := make([]byte, size)
+		rand.Read(plain)
+
+		// Wrap and unwrap the data key.
+		dataKey, _ := NewKey()
+		wrapped, err := WrapKey(master, dataKey)
+		if nil != err {
+			t.Fatalf("Failed to wrap key with: %v\n", err)
+		}
+		if dataKey, err = UnwrapKey(master, wrapped); nil != err {
+			t.Fatalf("Failed to unwrap key with: %v\n", err)
+		}
+
+		sealed := seal(t, dataKey, plain)
+		d, err := NewDecrypter(dataKey, bytes.NewReader(sealed))
+		if nil != err {
+			t.Fatalf("Failed to create decrypter for %d bytes with: %v\n", size, err)
+		}
+		if int64(size) != d.Size() {
+			t.Errorf("Size mismatch. Expected %d and got %d\n", size, d.Size())
+		}
+
+		// Read from the start, around chunk boundaries and near the end.
+		for _, offset := range []int{0, size / 2, chunkSize - 1, chunkSize, size - 1} {
+			if 0 > offset || offset > size {
+				continue
+			}
+			if _, err = d.Seek(int64(offset), io.SeekStart); nil != err {
+				t.Fatalf("Failed to seek with: %v\n", err)
+			}
+			raw, err := ioutil.ReadAll(d)
+			if nil != err {
+				t.Fatalf("Failed to read %d bytes from %d with: %v\n", size, offset, err)
+			}
+			if !bytes.Equal(plain[offset:], raw) {
+				t.Errorf("Contents mismatch for %d bytes from %d\n", size, offset)
+			}
+		}
+	}
+}
+
+// TestEnvelopeTampering is detected rather than returning altered contents.
+func TestEnvelopeTampering(t *testing.T) {
+	dataKey, _ := NewKey()
+	plain := make([]byte, 2*chunkSize+100)
+	sealed := seal(t, dataKey, plain)
+
+	tests := map[string][]byte{
+		"flipped bit":     append([]byte{sealed[0] ^ 1}, sealed[1:]...),
+		"truncated chunk": sealed[:len(sealed)-1],
+		"dropped chunk":   sealed[:2*(chunkSize+tagSize)],
+	}
+	for name, altered := range tests {
+		d, err := NewDecrypter(dataKey, bytes.NewReader(altered))
+		if nil == err {
+			_, err = ioutil.ReadAll(d)
+		}
+		if nil == err {
+			t.Errorf("Expected error reading contents with %s\n", name)
+		}
+	}
+
+	// A different master key can't unwrap the data key.
+	master, _ := NewKey()
+	other, _ := NewKey()
+	wrapped, _ := WrapKey(master, dataKey)
+	if _, err := UnwrapKey(other, wrapped); nil == err {
+		t.Error("Expected error unwrapping with the wrong master key")
+	}
+}
+
+// seal the contents with the data key.
+func seal(t *testing.T, dataKey, plain []byte) []byte {
+	e, err := NewEncrypter(dataKey, bytes.NewReader(plain))
+	if nil != err {
+		t.Fatalf("Failed to create encrypter with: %v\n", err)
+	}
+	sealed, err := ioutil.ReadAll(e)
+	if nil != err {
+		t.Fatalf("Failed to encrypt with: %v\n", err)
+	}
+	return sealed
+}
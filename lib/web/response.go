@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"time"
 )
 
 // Response builder for HTTP requests.
@@ -45,6 +46,18 @@ func (resp *Response) Stream() io.Writer {
 	return resp.ctx.W
 }
 
+// Serve seekable content, handling range and conditional requests. The status
+// is chosen based on the request. Do not call 'Do'.
+func (resp *Response) Serve(modTime time.Time, content io.ReadSeeker) {
+	// Set all headers.
+	for k, v := range resp.headers {
+		resp.ctx.W.Header().Add(k, v)
+	}
+
+	http.ServeContent(resp.ctx.W, resp.ctx.R, "", modTime, content)
+	resp.ctx.Debugln("Request completed")
+}
+
 // Do finalizes the response.
 func (resp *Response) Do() {
 	// This defer function runs at any return and logs the result of the exchange.
@@ -57,7 +70,7 @@ func (resp *Response) Do() {
 		}
 
 		// Second priority: log any unsuccessful requests that were made.
-		if http.StatusOK != resp.status {
+		if http.StatusOK > resp.status || http.StatusMultipleChoices <= resp.status {
 			resp.ctx.Logf(
 				"Response code %s: %s\n",
 				http.StatusText(resp.status),
@@ -77,6 +90,12 @@ func (resp *Response) Do() {
 	}
 	resp.ctx.W.WriteHeader(resp.status)
 
+	// Some responses never carry a body.
+	if http.StatusNoContent == resp.status || http.StatusNotModified == resp.status ||
+		"HEAD" == resp.ctx.R.Method {
+		return
+	}
+
 	// Convert error messages to strings to avoid 'err.Error()' throughout app.
 	if errX, ok := resp.msg.(error); ok {
 		resp.msg = errX.Error()
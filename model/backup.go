@@ -0,0 +1,358 @@
+package model
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/halverneus/example/config"
+	"github.com/halverneus/example/database"
+	"github.com/halverneus/example/lib/codec"
+	"github.com/halverneus/example/storage"
+)
+
+const (
+	// backupDatabase member holding the database snapshot.
+	backupDatabase = "database.json"
+	// backupManifest member listing the checksum of every other member, written
+	// last.
+	backupManifest = "manifest.json"
+	// backupObjects folder holding the contents, by tier and storage key.
+	backupObjects = "objects"
+)
+
+var (
+	// Backup namespace contains all backup-specific functions.
+	Backup BackupNamespace
+
+	// ErrNotAdmin is returned when a user not listed as a backup admin asks for
+	// a backup.
+	ErrNotAdmin = errors.New("user is not allowed to back up the server")
+
+	// zstdMagic starts every zstd frame, telling compressed archives apart.
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// BackupNamespace is used to organize the controller/model functions.
+type BackupNamespace struct{}
+
+// BackupManifest of an archive, listing every member before the manifest with
+// its checksum.
+type BackupManifest struct {
+	Created time.Time       `json:"created"`
+	Schema  int             `json:"schema"`
+	Members []*BackupMember `json:"members"`
+}
+
+// BackupMember of an archive. Objects name the storage key and the tier they
+// are restored to.
+type BackupMember struct {
+	Name   string `json:"name"`
+	Key    string `json:"key,omitempty"`
+	Tier   string `json:"tier,omitempty"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// Allowed returns true when the user may back up the server.
+func (bn BackupNamespace) Allowed(user string) bool {
+	return contains(config.Get.Backup.Admins, user)
+}
+
+// Write a backup of the database and every stored object to the writer as a tar
+// archive, compressed with zstd when asked to. Objects are written as stored,
+// so encrypted contents need the same master key once restored. Uploads and
+// deletions carry on while writing, but the archive holds the database and the
+// contents as they were when it started.
+func (bn BackupNamespace) Write(w io.Writer, compress bool) (err error) {
+	var snapshot *database.Snapshot
+	if snapshot, err = database.TakeSnapshot(); nil != err {
+		return
+	}
+	defer snapshot.Release()
+
+	var files []*database.File
+	if files, err = snapshot.Contents(); nil != err {
+		return
+	}
+	var raw []byte
+	if raw, err = json.Marshal(snapshot); nil != err {
+		return
+	}
+
+	if compress {
+		var zw io.WriteCloser
+		if zw, err = codec.NewWriter(codec.Zstd, w); nil != err {
+			return
+		}
+		defer func() {
+			if errX := zw.Close(); nil == err {
+				err = errX
+			}
+		}()
+		w = zw
+	}
+	tw := tar.NewWriter(w)
+	manifest := &BackupManifest{Created: time.Now().UTC(), Schema: snapshot.Schema}
+
+	// Database first, then the contents of every storage key once.
+	var member *BackupMember
+	if member, err = writeMember(tw, backupDatabase, bytes.NewReader(raw), int64(len(raw))); nil != err {
+		return
+	}
+	manifest.Members = append(manifest.Members, member)
+
+	written := map[string]bool{}
+	for _, f := range files {
+		key := f.StorageKey()
+		if written[key] {
+			continue
+		}
+		written[key] = true
+		if member, err = writeObject(tw, key, f.Tier); nil != err {
+			return
+		}
+		manifest.Members = append(manifest.Members, member)
+	}
+
+	if raw, err = json.Marshal(manifest); nil != err {
+		return
+	}
+	if _, err = writeMember(tw, backupManifest, bytes.NewReader(raw), int64(len(raw))); nil != err {
+		return
+	}
+	return tw.Close()
+}
+
+// writeObject at the storage key to the archive, named after the tier recorded
+// in the snapshot. The contents may have moved to the other tier since.
+func writeObject(tw *tar.Writer, key, tier string) (member *BackupMember, err error) {
+	unlock := lockKey(key)
+	defer unlock()
+
+	var obj storage.Object
+	if obj, err = File.tier(tier).Get(key); storage.ErrNotFound == err && nil != File.Cold {
+		obj, err = File.tier(otherTier(tier)).Get(key)
+	}
+	if nil != err {
+		err = fmt.Errorf("unable to back up contents at %s: %v", key, err)
+		return
+	}
+	defer obj.Close()
+
+	var size int64
+	if size, err = obj.Seek(0, io.SeekEnd); nil != err {
+		return
+	}
+	if _, err = obj.Seek(0, io.SeekStart); nil != err {
+		return
+	}
+	name := path.Join(backupObjects, tierName(tier), strings.TrimPrefix(key, "/"))
+	if member, err = writeMember(tw, name, obj, size); nil != err {
+		return
+	}
+	member.Key, member.Tier = key, tierName(tier)
+	return
+}
+
+// writeMember of the size to the archive, hashing it on the way.
+func writeMember(tw *tar.Writer, name string, r io.Reader, size int64) (member *BackupMember, err error) {
+	header := &tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Mode:     0644,
+		Size:     size,
+		ModTime:  time.Now(),
+	}
+	if err = tw.WriteHeader(header); nil != err {
+		return
+	}
+	h := sha256.New()
+	if _, err = io.CopyN(io.MultiWriter(tw, h), r, size); nil != err {
+		return
+	}
+	member = &BackupMember{Name: name, Size: size, SHA256: hex.EncodeToString(h.Sum(nil))}
+	return
+}
+
+// Verify every member of the archive against the manifest, and that it holds the
+// contents of every file in the database. Returns the manifest.
+func (bn BackupNamespace) Verify(filename string) (manifest *BackupManifest, err error) {
+	manifest, _, err = verifyBackup(filename)
+	return
+}
+
+// Restore the archive, verified in full before anything changes. Objects are
+// written to storage and checked again, then the database is replaced, keeping
+// the existing one as a backup. The server must be stopped. Returns the name of
+// the database backup, which is empty when there was no database.
+func (bn BackupNamespace) Restore(filename string) (backup string, err error) {
+	var manifest *BackupManifest
+	var snapshot *database.Snapshot
+	if manifest, snapshot, err = verifyBackup(filename); nil != err {
+		return
+	}
+
+	// Write the objects, keeping only contents matching their checksums.
+	members := map[string]*BackupMember{}
+	for _, member := range manifest.Members {
+		members[member.Name] = member
+	}
+	err = readBackup(filename, func(name string, r io.Reader) (err error) {
+		member, found := members[name]
+		switch {
+		case backupDatabase == name || backupManifest == name:
+			return
+		case !found:
+			return fmt.Errorf("%s changed while restoring", filename)
+		}
+		backend := File.tier(recordedTier(member.Tier))
+		h := sha256.New()
+		var upload storage.Upload
+		if upload, err = backend.Put(io.TeeReader(r, h)); nil != err {
+			return
+		}
+		if sum := hex.EncodeToString(h.Sum(nil)); sum != member.SHA256 {
+			upload.Abort()
+			return fmt.Errorf("checksum of %s changed while restoring", name)
+		}
+		return upload.Commit(member.Key)
+	})
+	if nil != err {
+		return
+	}
+
+	return database.RestoreSnapshot(config.Get.Database.Driver, config.Get.Database.Filename, snapshot)
+}
+
+// verifyBackup reads every member of the archive, checking each against the
+// manifest and the snapshot against the objects. Returns the manifest and the
+// snapshot.
+func verifyBackup(filename string) (manifest *BackupManifest, snapshot *database.Snapshot, err error) {
+	sums := map[string]string{}
+	var raw []byte
+	err = readBackup(filename, func(name string, r io.Reader) (err error) {
+		if _, found := sums[name]; found {
+			return fmt.Errorf("member %s appears more than once", name)
+		}
+		h := sha256.New()
+		switch name {
+		case backupManifest:
+			manifest = &BackupManifest{}
+			return json.NewDecoder(r).Decode(manifest)
+		case backupDatabase:
+			if raw, err = ioutil.ReadAll(io.TeeReader(r, h)); nil != err {
+				return
+			}
+		default:
+			if _, err = io.Copy(h, r); nil != err {
+				return
+			}
+		}
+		sums[name] = hex.EncodeToString(h.Sum(nil))
+		return
+	})
+	if nil != err {
+		return
+	}
+	if nil == manifest {
+		err = fmt.Errorf("%s is incomplete, missing %s", filename, backupManifest)
+		return
+	}
+
+	// Every member was listed with the checksum read.
+	keys := map[string]bool{}
+	for _, member := range manifest.Members {
+		sum, found := sums[member.Name]
+		switch {
+		case !found:
+			err = fmt.Errorf("%s is missing %s", filename, member.Name)
+		case sum != member.SHA256:
+			err = fmt.Errorf("checksum of %s in %s doesn't match", member.Name, filename)
+		case "" != member.Key && (storage.Reserved(member.Key) || !strings.HasPrefix(member.Name, backupObjects+"/")):
+			err = fmt.Errorf("invalid object %s in %s", member.Name, filename)
+		}
+		if nil != err {
+			return
+		}
+		delete(sums, member.Name)
+		keys[member.Key] = true
+	}
+	for name := range sums {
+		err = fmt.Errorf("%s holds %s, which isn't in the manifest", filename, name)
+		return
+	}
+
+	// Every file in the database has its contents.
+	snapshot = &database.Snapshot{}
+	if err = json.Unmarshal(raw, snapshot); nil != err {
+		err = fmt.Errorf("unable to read %s in %s: %v", backupDatabase, filename, err)
+		return
+	}
+	var files []*database.File
+	if files, err = snapshot.Contents(); nil != err {
+		return
+	}
+	for _, f := range files {
+		if !keys[f.StorageKey()] {
+			err = fmt.Errorf("%s is missing the contents of %s", filename, f.Path)
+			return
+		}
+	}
+	return
+}
+
+// readBackup calls the function with every regular member of the archive, in
+// order, decompressing zstd archives.
+func readBackup(filename string, fn func(name string, r io.Reader) error) (err error) {
+	var f *os.File
+	if f, err = os.Open(filename); nil != err {
+		return
+	}
+	defer f.Close()
+
+	var r io.Reader = bufio.NewReader(f)
+	if magic, _ := r.(*bufio.Reader).Peek(len(zstdMagic)); bytes.Equal(magic, zstdMagic) {
+		var rc io.ReadCloser
+		if rc, err = codec.NewReader(codec.Zstd, r); nil != err {
+			return
+		}
+		defer rc.Close()
+		r = rc
+	}
+
+	tr := tar.NewReader(r)
+	for {
+		var header *tar.Header
+		if header, err = tr.Next(); io.EOF == err {
+			return nil
+		} else if nil != err {
+			return fmt.Errorf("unable to read %s: %v", filename, err)
+		}
+		if tar.TypeReg != header.Typeflag {
+			return fmt.Errorf("unexpected member %s in %s", header.Name, filename)
+		}
+		if err = fn(header.Name, tr); nil != err {
+			return
+		}
+	}
+}
+
+// recordedTier in the database for a tier name.
+func recordedTier(name string) string {
+	if ColdTier == name {
+		return database.ColdTier
+	}
+	return ""
+}
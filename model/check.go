@@ -0,0 +1,275 @@
+package model
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/halverneus/example/database"
+	"github.com/halverneus/example/lib/codec"
+	"github.com/halverneus/example/storage"
+)
+
+// CheckReport of a storage check, ready to be written out as JSON.
+type CheckReport struct {
+	Files        int          `json:"files"`
+	Objects      int          `json:"objects"`
+	Orphans      []CheckIssue `json:"orphans"`
+	Missing      []CheckIssue `json:"missing"`
+	Mismatched   []CheckIssue `json:"mismatched"`
+	EmptyFolders []CheckIssue `json:"empty-folders"`
+}
+
+// CheckIssue found in storage.
+type CheckIssue struct {
+	Key      string   `json:"key"`
+	Tier     string   `json:"tier,omitempty"`
+	Paths    []string `json:"paths,omitempty"`
+	Problem  string   `json:"problem"`
+	Repaired bool     `json:"repaired"`
+	Error    string   `json:"error,omitempty"`
+}
+
+// Unresolved issues in the report.
+func (report *CheckReport) Unresolved() (count int) {
+	for _, issues := range [][]CheckIssue{
+		report.Orphans,
+		report.Missing,
+		report.Mismatched,
+		report.EmptyFolders,
+	} {
+		for _, issue := range issues {
+			if !issue.Repaired {
+				count++
+			}
+		}
+	}
+	return
+}
+
+// Check storage against the database, reporting objects no file refers to,
+// files whose contents are missing or don't match their size and checksums,
+// and empty folders. With repair, orphans and empty folders are removed and
+// files without contents are removed from the database. Mismatched contents are
+// corrupt and are only reported, to be restored from a backup. The database
+// must not be in use by the server, and the deletion worker must be running
+// when repairing.
+func (mn MaintenanceNamespace) Check(repair bool) (report *CheckReport, err error) {
+	report = &CheckReport{
+		Orphans:      []CheckIssue{},
+		Missing:      []CheckIssue{},
+		Mismatched:   []CheckIssue{},
+		EmptyFolders: []CheckIssue{},
+	}
+
+	// Group files, including past versions and the trash, by where their
+	// contents are stored.
+	files := []*checkedFile{}
+	for _, f := range append(database.ListFiles(), database.ListHistory()...) {
+		files = append(files, &checkedFile{File: f})
+	}
+	for _, item := range database.ListTrash() {
+		files = append(files, &checkedFile{File: item.File, trashID: item.ID})
+	}
+	report.Files = len(files)
+	byKey := map[string][]*checkedFile{}
+	for _, f := range files {
+		byKey[f.StorageKey()] = append(byKey[f.StorageKey()], f)
+	}
+	keys := make([]string, 0, len(byKey))
+	for key := range byKey {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		mn.checkFiles(report, key, byKey[key], repair)
+	}
+
+	for _, tier := range []string{"", database.ColdTier} {
+		if "" != tier && nil == File.Cold {
+			break
+		}
+		if err = mn.checkTier(report, tier, byKey, repair); nil != err {
+			return
+		}
+	}
+	return
+}
+
+// checkTier for objects no file in the tier refers to and empty folders.
+func (mn MaintenanceNamespace) checkTier(report *CheckReport, tier string, byKey map[string][]*checkedFile, repair bool) (err error) {
+	backend := File.tier(tier)
+
+	// Objects without files, including copies left behind when moving between
+	// tiers. Collect first, as deleting while listing is unsafe.
+	orphans := []string{}
+	if err = backend.List("/", func(info *storage.Info) error {
+		report.Objects++
+		if files, found := byKey[info.Key]; !found || tier != files[0].Tier {
+			orphans = append(orphans, info.Key)
+		}
+		return nil
+	}); nil != err {
+		return
+	}
+	for _, key := range orphans {
+		issue := CheckIssue{Key: key, Tier: tier, Problem: "no file refers to the object"}
+		if repair {
+			resolve(&issue, backend.Delete(key))
+		}
+		report.Orphans = append(report.Orphans, issue)
+	}
+
+	// Empty folders, including those left behind by the repairs above.
+	pruner, ok := backend.(storage.Pruner)
+	if !ok {
+		return
+	}
+	var folders []string
+	if folders, err = pruner.EmptyFolders(); nil != err {
+		return
+	}
+	for _, folder := range folders {
+		issue := CheckIssue{Key: folder, Tier: tier, Problem: "empty folder"}
+		if repair {
+			resolve(&issue, pruner.Prune(folder))
+		}
+		report.EmptyFolders = append(report.EmptyFolders, issue)
+	}
+	return
+}
+
+// checkFiles sharing a storage key against the stored contents.
+func (mn MaintenanceNamespace) checkFiles(report *CheckReport, key string, files []*checkedFile, repair bool) {
+	paths := make([]string, len(files))
+	for i, f := range files {
+		paths[i] = f.label()
+	}
+
+	// Contents gone altogether leave nothing to serve.
+	tier := files[0].Tier
+	if _, err := File.tier(tier).Stat(key); storage.ErrNotFound == err {
+		issue := CheckIssue{Key: key, Tier: tier, Paths: paths, Problem: "contents missing from storage"}
+		if repair {
+			for _, f := range files {
+				if err = f.remove(); nil != err {
+					break
+				}
+			}
+			resolve(&issue, err)
+		}
+		report.Missing = append(report.Missing, issue)
+		return
+	}
+
+	// Every file at the key expects the same contents, so compare against each.
+	size, sha256Sum, err := storedContents(files[0].File)
+	if nil != err {
+		report.Mismatched = append(report.Mismatched, CheckIssue{
+			Key:     key,
+			Tier:    tier,
+			Paths:   paths,
+			Problem: "contents unreadable",
+			Error:   err.Error(),
+		})
+		return
+	}
+	// Files recorded before sizes and checksums were kept, whose size couldn't
+	// be found when migrating, have nothing to compare against.
+	mismatchedPaths := []string{}
+	for _, f := range files {
+		sized := 0 != f.Size || "" != f.SHA256
+		if (sized && size != f.Size) || ("" != f.SHA256 && !strings.EqualFold(sha256Sum, f.SHA256)) {
+			mismatchedPaths = append(mismatchedPaths, f.label())
+		}
+	}
+	if 0 == len(mismatchedPaths) {
+		return
+	}
+
+	issue := CheckIssue{
+		Key:     key,
+		Tier:    tier,
+		Paths:   mismatchedPaths,
+		Problem: fmt.Sprintf("stored contents are %d bytes with SHA-256 %s", size, sha256Sum),
+	}
+	if repair {
+		issue.Error = "corrupt contents must be restored from a backup"
+	}
+	report.Mismatched = append(report.Mismatched, issue)
+}
+
+// storedContents of a file, measured and hashed.
+func storedContents(f *database.File) (size int64, sha256Sum string, err error) {
+	var obj storage.Object
+	if obj, err = File.tier(f.Tier).Get(f.StorageKey()); nil != err {
+		return
+	}
+	defer obj.Close()
+	return hashContents(f, obj)
+}
+
+// hashContents of a file as stored, which are decrypted and decompressed to
+// measure and hash them.
+func hashContents(f *database.File, stored io.ReadSeeker) (size int64, sha256Sum string, err error) {
+	var content io.Reader = stored
+	if "" != f.WrappedKey {
+		if content, err = decrypter(f, stored); nil != err {
+			return
+		}
+	}
+	if "" != f.Encoding {
+		var rc io.ReadCloser
+		if rc, err = codec.NewReader(f.Encoding, content); nil != err {
+			return
+		}
+		defer rc.Close()
+		content = rc
+	}
+
+	sha256Hash := sha256.New()
+	if size, err = io.Copy(sha256Hash, content); nil != err {
+		return
+	}
+	sha256Sum = hex.EncodeToString(sha256Hash.Sum(nil))
+	return
+}
+
+// checkedFile in a storage check, which is in the trash when it has a trash ID.
+type checkedFile struct {
+	*database.File
+	trashID string
+}
+
+// label of the file in a report, which is its path followed by the trash item
+// or the version when versioned.
+func (f *checkedFile) label() string {
+	switch {
+	case "" != f.trashID:
+		return f.Path + "?trash=" + f.trashID
+	case "" != f.VersionID:
+		return f.Path + "?version=" + f.VersionID
+	}
+	return f.Path
+}
+
+// remove the file from the database for good.
+func (f *checkedFile) remove() error {
+	if "" != f.trashID {
+		return database.PurgeTrash(f.trashID)
+	}
+	return database.RemoveVersion(f.Path, f.Version())
+}
+
+// resolve an issue with the outcome of its repair.
+func resolve(issue *CheckIssue, err error) {
+	if nil != err {
+		issue.Error = err.Error()
+		return
+	}
+	issue.Repaired = true
+}
@@ -0,0 +1,127 @@
+package model
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/halverneus/example/config"
+	"github.com/halverneus/example/database"
+	"github.com/halverneus/example/storage"
+)
+
+// TestCheck storage with an orphan, missing contents and corrupt contents
+// planted, then repair what can be repaired.
+func TestCheck(t *testing.T) {
+	// Load the database and keep deduplicated contents in memory. Delete
+	// everything on completion.
+	if err := database.Load("json", "check.db"); nil != err {
+		t.Fatalf("While loading database: %v\n", err)
+	}
+	defer os.Remove("check.db")
+	defer os.Remove("check.db.journal")
+
+	config.Get.Storage.Driver = "memory"
+	config.Get.Storage.Deduplicate = true
+	defer func() {
+		config.Get.Storage.Driver = "filesystem"
+		config.Get.Storage.Deduplicate = false
+	}()
+	if err := Load(); nil != err {
+		t.Fatalf("While loading model: %v\n", err)
+	}
+
+	// put contents at the key in storage.
+	put := func(key, contents string) {
+		upload, err := File.Storage.Put(strings.NewReader(contents))
+		if nil == err {
+			err = upload.Commit(key)
+		}
+		if nil != err {
+			t.Fatalf("Failed to put %s with: %v\n", key, err)
+		}
+	}
+
+	// upload contents to the path, returning the storage key.
+	upload := func(filePath, contents string) string {
+		if err := File.Upload(&FileMetadata{Path: filePath, Uploader: "john"}, strings.NewReader(contents)); nil != err {
+			t.Fatalf("Failed to upload %s with: %v\n", filePath, err)
+		}
+		f, err := database.GetMetadata(filePath)
+		if nil != err {
+			t.Fatalf("Failed to find %s with: %v\n", filePath, err)
+		}
+		return f.StorageKey()
+	}
+
+	// check storage, expecting the number of orphans, missing and mismatched
+	// contents, and the number left unresolved.
+	check := func(repair bool, orphans, missing, mismatched, unresolved int) *CheckReport {
+		report, err := Maintenance.Check(repair)
+		if nil != err {
+			t.Fatalf("Failed to check storage with: %v\n", err)
+		}
+		if orphans != len(report.Orphans) || missing != len(report.Missing) || mismatched != len(report.Mismatched) {
+			t.Errorf("Expected %d orphans, %d missing and %d mismatched and got %+v\n", orphans, missing, mismatched, report)
+		}
+		if count := report.Unresolved(); unresolved != count {
+			t.Errorf("Expected %d unresolved problems and got %d\n", unresolved, count)
+		}
+		return report
+	}
+
+	upload("/good.txt", "good")
+	missingKey := upload("/missing.txt", "missing")
+	corruptKey := upload("/corrupt.txt", "corrupt")
+	corrupt, _ := database.GetMetadata("/corrupt.txt")
+	put("/orphan.bin", "orphan")
+
+	// A file recorded before sizes and checksums were kept.
+	put("/legacy.txt", "legacy")
+	if err := database.AddFile(&database.File{Path: "/legacy.txt", Uploader: "john", Created: "Jan 2, 2006 3:04 PM"}); nil != err {
+		t.Fatalf("Failed to add /legacy.txt with: %v\n", err)
+	}
+	if err := File.Storage.Delete(missingKey); nil != err {
+		t.Fatalf("Failed to delete %s with: %v\n", missingKey, err)
+	}
+	put(corruptKey, "corrupted")
+
+	// Nothing changes without repairing.
+	report := check(false, 1, 1, 1, 3)
+	if 0 < len(report.Orphans) && "/orphan.bin" != report.Orphans[0].Key {
+		t.Errorf("Expected /orphan.bin to be the orphan and got %s\n", report.Orphans[0].Key)
+	}
+	if 0 < len(report.Missing) && "/missing.txt" != strings.Join(report.Missing[0].Paths, ",") {
+		t.Errorf("Expected /missing.txt to be missing and got %v\n", report.Missing[0].Paths)
+	}
+	if 0 < len(report.Mismatched) && "/corrupt.txt" != strings.Join(report.Mismatched[0].Paths, ",") {
+		t.Errorf("Expected /corrupt.txt to be mismatched and got %v\n", report.Mismatched[0].Paths)
+	}
+	if _, err := File.Storage.Stat("/orphan.bin"); nil != err {
+		t.Errorf("Expected orphan to be kept and got: %v\n", err)
+	}
+
+	// Repairing removes the orphan and forgets the missing file, but leaves the
+	// corrupt file as it was.
+	report = check(true, 1, 1, 1, 1)
+	if 0 < len(report.Mismatched) && report.Mismatched[0].Repaired {
+		t.Error("Expected corrupt contents to be left unresolved")
+	}
+	if _, err := File.Storage.Stat("/orphan.bin"); storage.ErrNotFound != err {
+		t.Errorf("Expected orphan to be removed and got: %v\n", err)
+	}
+	if _, err := database.GetMetadata("/missing.txt"); nil == err {
+		t.Error("Expected file with missing contents to be removed")
+	}
+	if f, err := database.GetMetadata("/corrupt.txt"); nil != err || corrupt.Size != f.Size || corrupt.SHA256 != f.SHA256 {
+		t.Errorf("Expected corrupt file to keep its checksums and got %+v (%v)\n", f, err)
+	}
+	for _, filePath := range []string{"/good.txt", "/legacy.txt"} {
+		if _, err := database.GetMetadata(filePath); nil != err {
+			t.Errorf("Expected %s to be kept and got: %v\n", filePath, err)
+		}
+	}
+
+	// Only the corrupt contents remain a problem.
+	check(false, 0, 0, 1, 1)
+}
@@ -1,109 +1,619 @@
 package model
 
 import (
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
 	"io"
 	"log"
+	"path"
+	"strings"
 	"sync"
 	"time"
 
+	"github.com/halverneus/example/config"
 	"github.com/halverneus/example/database"
+	"github.com/halverneus/example/lib/codec"
 	"github.com/halverneus/example/storage"
 )
 
+const (
+	// blobFolder holds deduplicated contents named by their SHA-256 digest.
+	blobFolder = ".blobs"
+	// objectFolder holds contents named by random IDs in the hashed layout.
+	objectFolder = ".objects"
+
+	// pathLayout stores contents at the file path.
+	pathLayout = "path"
+	// hashedLayout stores contents at random IDs unrelated to the file path,
+	// which only the database knows.
+	hashedLayout = "hashed"
+)
+
 var (
 	// File namespace contains all file-specific functions.
 	File FileNamespace
 )
 
+// Load the storage backend selected in the configuration.
+func Load() (err error) {
+	for _, rule := range config.Get.Storage.Compression {
+		if err = codec.Valid(rule.Codec); nil != err {
+			return
+		}
+	}
+	switch config.Get.Storage.Layout {
+	case "", pathLayout, hashedLayout:
+	default:
+		err = fmt.Errorf("unknown storage layout: %s", config.Get.Storage.Layout)
+		return
+	}
+	if err = validLifecycle(); nil != err {
+		return
+	}
+	if err = validRetentionRules(); nil != err {
+		return
+	}
+	if err = validTiering(); nil != err {
+		return
+	}
+	if err = loadMasterKey(); nil != err {
+		return
+	}
+	File.Cold = storage.OpenCold()
+	File.Storage, err = storage.Open()
+	return
+}
+
 // Start the model service.
 func Start() (wg *sync.WaitGroup) {
+	// Remove uploads left staged by a previous run before accepting new ones.
+	for _, backend := range File.tiers() {
+		if err := backend.Clean(); nil != err {
+			log.Printf("Received error while cleaning staged uploads: %v\n", err)
+		}
+	}
+
+	// Delete contents no longer referred to, and in between abort expired
+	// resumable and multipart uploads, purge expired trash, expire files by lifecycle rules,
+	// move contents between tiers and scrub mirrored copies, until the database shuts down.
 	wg = &sync.WaitGroup{}
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
+		sweep := time.NewTicker(sweepInterval)
+		defer sweep.Stop()
+		lifecycle := time.NewTicker(lifecycleInterval)
+		defer lifecycle.Stop()
+		tiering := time.NewTicker(tieringInterval)
+		defer tiering.Stop()
+		scrubbing := time.NewTicker(scrubInterval)
+		defer scrubbing.Stop()
 		for {
-			f, ok := <-database.FileDeletionChan
-			if !ok {
-				return
-			}
-			if err := storage.Delete(f.Path); nil != err {
-				log.Printf("Received error while deleting %s: %v\n", f.Path, err)
+			select {
+			case f, ok := <-database.FileDeletionChan:
+				if !ok {
+					return
+				}
+				File.release(f)
+
+			case <-sweep.C:
+				if _, err := Uploads.Sweep(); nil != err {
+					log.Printf("Received error while sweeping resumable uploads: %v\n", err)
+				}
+				if _, err := Multipart.Sweep(); nil != err {
+					log.Printf("Received error while sweeping multipart uploads: %v\n", err)
+				}
+				if _, err := Trash.Expire(); nil != err {
+					log.Printf("Received error while purging expired trash: %v\n", err)
+				}
+
+			case <-lifecycle.C:
+				Lifecycle.expire()
+
+			case <-tiering.C:
+				Tiering.move()
+
+			case <-scrubbing.C:
+				Scrubbing.scrub()
 			}
 		}
 	}()
 	return
 }
 
-// FileMetadata contains general information about file contents.
+// FileMetadata contains general information about file contents. When
+// uploading, non-empty checksums are what the client expects the contents to
+// hash to and a positive Size is the announced size, which lets uploads over
+// quota fail before any contents are read. Size and checksums always describe
+// the uncompressed contents, while Encoding names the codec the contents are
+// compressed with, either at rest or as handed to a download. VersionID is
+// "null" for files uploaded without versioning. Files can't be replaced or
+// deleted until RetainUntil passes and the LegalHold is released. Tier is
+// either "hot" or "cold".
 type FileMetadata struct {
-	Path        string
-	ContentType string
-	Uploader    string
+	Path          string
+	ContentType   string
+	Uploader      string
+	Modified      time.Time
+	Size          int64
+	SHA256        string
+	MD5           string
+	Encoding      string
+	VersionID     string
+	DeleteMarker  bool
+	RetentionMode string
+	RetainUntil   time.Time
+	LegalHold     bool
+	Tier          string
+}
+
+// ETag for the contents, which is empty for files stored before checksums were
+// recorded. Compressed contents are a different representation and are tagged
+// as such.
+func (meta *FileMetadata) ETag() string {
+	if "" == meta.SHA256 {
+		return ""
+	}
+	if "" != meta.Encoding {
+		return `"` + meta.SHA256 + "-" + meta.Encoding + `"`
+	}
+	return `"` + meta.SHA256 + `"`
+}
+
+// ChecksumError is returned when uploaded contents don't hash to the checksum
+// provided by the client.
+type ChecksumError struct {
+	Algorithm string
+	Expected  string
+	Received  string
+}
+
+// Error message for the mismatch.
+func (e *ChecksumError) Error() string {
+	return fmt.Sprintf(
+		"%s checksum mismatch: expected %s but received contents hash to %s",
+		e.Algorithm,
+		e.Expected,
+		e.Received,
+	)
 }
 
 // FileNamespace is used to organize the controller/model functions.
-type FileNamespace struct{}
+type FileNamespace struct {
+	// Storage backend holding the contents of every file in the hot tier.
+	Storage storage.Backend
+
+	// Cold storage backend holding contents moved off the hot tier, which is
+	// nil when tiering is disabled.
+	Cold storage.Backend
+}
 
 // Upload a new file.
 func (fn FileNamespace) Upload(meta *FileMetadata, r io.Reader) (err error) {
+	// Store under the canonical path, reporting it back to the caller.
+	var objectKey ObjectKey
+	if objectKey, err = ParseObjectKey(meta.Path); nil != err {
+		return
+	}
+	meta.Path = objectKey.String()
+
+	// Refuse to replace a locked file before reading anything.
+	if current, errX := database.GetMetadata(meta.Path); nil == errX && current.Locked() {
+		err = ErrLocked
+		return
+	}
+
+	// Refuse uploads that can't fit within quotas, and stop reading once the
+	// contents go over.
+	var quota *quotaReader
+	if quotaEnabled() {
+		var a allowance
+		if a, err = quotaAllowance(meta.Path, meta.Uploader); nil != err {
+			return
+		}
+		if 0 < meta.Size && !a.permits(meta.Size) {
+			err = a.exceeded
+			return
+		}
+		quota = &quotaReader{r: r, a: a}
+		r = quota
+	}
+
 	// Copy to database object to assure no race condition due to misuse.
+	now := time.Now()
 	f := &database.File{
 		Path:        meta.Path,
 		ContentType: meta.ContentType,
 		Uploader:    meta.Uploader,
-		Created:     time.Now().Format("Jan 2, 2006 3:04 PM"),
+		Created:     now.Format("Jan 2, 2006 3:04 PM"),
+		Modified:    now.UTC(),
+	}
+	f.RetentionMode, f.RetainUntil = defaultRetention(f.Path, now)
+
+	// Stage the upload while hashing the uncompressed contents. Existing contents
+	// remain untouched until committed.
+	sha256Hash, md5Hash, size := sha256.New(), md5.New(), &countWriter{}
+	hashes := io.MultiWriter(sha256Hash, md5Hash, size)
+	contents := io.TeeReader(r, hashes)
+	if f.Encoding = compressionFor(f.Path, f.ContentType); "" != f.Encoding {
+		compressed := codec.Compress(f.Encoding, contents)
+		defer compressed.Close()
+		contents = compressed
+	}
+	if nil != masterKey {
+		var encrypted io.Reader
+		if encrypted, f.WrappedKey, f.KeyID, err = encrypter(contents); nil != err {
+			return
+		}
+		contents = encrypted
+	}
+	var upload storage.Upload
+	if upload, err = fn.Storage.Put(contents); nil != err {
+		if nil != quota && nil != quota.err {
+			err = quota.err
+		}
+		return
+	}
+	f.Size = size.n
+	f.SHA256 = hex.EncodeToString(sha256Hash.Sum(nil))
+	f.MD5 = hex.EncodeToString(md5Hash.Sum(nil))
+
+	// Verify contents arrived as the client sent them.
+	if err = verifyChecksum("SHA-256", meta.SHA256, f.SHA256); nil != err {
+		upload.Abort()
+		return
+	}
+	if err = verifyChecksum("MD5", meta.MD5, f.MD5); nil != err {
+		upload.Abort()
+		return
 	}
 
-	// Upload to the file system.
-	if err = storage.Upload(f.Path, r); nil != err {
+	// Versions get a new ID each time.
+	versioned := versioningEnabled(f.Path)
+	if versioned {
+		if f.VersionID, err = newVersionID(); nil != err {
+			upload.Abort()
+			return
+		}
+	}
+
+	// Report what was received back to the caller.
+	meta.Size, meta.SHA256, meta.MD5, meta.VersionID = f.Size, f.SHA256, f.MD5, f.Version()
+
+	// Contents at the path would overwrite those of past versions or of files in
+	// the trash, so these get keys of their own no matter the layout.
+	switch {
+	case config.Get.Storage.Deduplicate:
+		f.Key = blobKey(f.SHA256, f.Encoding)
+	case hashedLayout == config.Get.Storage.Layout, versioned,
+		database.HasHistory(f.Path), database.InTrash(f.Path):
+		if f.Key, err = newObjectKey(); nil != err {
+			upload.Abort()
+			return
+		}
+	}
+
+	// Keep the deletion worker away from the key until the contents are in place.
+	key := f.StorageKey()
+	unlock := lockKey(key)
+	defer unlock()
+
+	// Identical contents already stored as a blob don't need to be stored again,
+	// but must then be read with the same data key from the same tier.
+	shared := false
+	if isBlobKey(key) {
+		if existing, errX := database.GetMetadataByKey(key); nil == errX {
+			if _, errX = fn.tier(existing.Tier).Stat(key); nil == errX {
+				f.WrappedKey, f.KeyID, f.Tier = existing.WrappedKey, existing.KeyID, existing.Tier
+				shared = true
+			}
+		}
+	}
+
+	// Push metadata into database, holding on to the file being replaced until
+	// the new contents are in place. On failure, discard the staged upload.
+	var replaced *database.File
+	if replaced, err = addFileHoldingWithinQuota(f); nil != err {
+		upload.Abort()
+		database.ReleaseFile(replaced)
+		return
+	}
+	if shared {
+		upload.Abort()
+		database.ReleaseFile(replaced)
 		return
 	}
 
-	// Push metadata into database. On failure, attempt to delete uploaded file.
-	if err = database.AddFile(f); nil != err {
-		storage.Delete(f.Path)
+	// Move the contents into place. On failure, bring back the replaced file.
+	if err = upload.Commit(key); nil != err {
+		if errX := database.RevertFile(f, replaced); nil != errX {
+			log.Printf("Received error while reverting upload of %s: %v\n", f.Path, errX)
+		}
+		return
 	}
+	database.ReleaseFile(replaced)
+	return
+}
+
+// addFileWithinQuota to the database, checking quotas again now that the size
+// is known, since other uploads may have finished in the meantime.
+func addFileWithinQuota(f *database.File) (err error) {
+	var replaced *database.File
+	replaced, err = addFileHoldingWithinQuota(f)
+	database.ReleaseFile(replaced)
 	return
 }
 
+// addFileHoldingWithinQuota is addFileWithinQuota returning the replaced file
+// to be released, like database.AddFileHolding.
+func addFileHoldingWithinQuota(f *database.File) (replaced *database.File, err error) {
+	if !quotaEnabled() {
+		return database.AddFileHolding(f)
+	}
+
+	quotaMtx.Lock()
+	defer quotaMtx.Unlock()
+	var a allowance
+	if a, err = quotaAllowance(f.Path, f.Uploader); nil != err {
+		return
+	}
+	if !a.permits(f.Size) {
+		err = a.exceeded
+		return
+	}
+	return database.AddFileHolding(f)
+}
+
 // Metadata for a file.
 func (fn FileNamespace) Metadata(filePath string) (meta *FileMetadata, err error) {
+	var objectKey ObjectKey
+	if objectKey, err = ParseObjectKey(filePath); nil != err {
+		return
+	}
 	var file *database.File
-	if file, err = database.GetMetadata(filePath); nil != err {
+	if file, err = database.GetMetadata(objectKey.String()); nil != err {
 		return
 	}
 
 	// Copy to model metadata.
-	meta = &FileMetadata{
-		Path:        file.Path,
-		ContentType: file.ContentType,
-		Uploader:    file.Uploader,
-	}
+	meta = newFileMetadata(file)
 	return
 }
 
-// Download an existing file.
-func (fn FileNamespace) Download(filePath string, w io.Writer) (meta *FileMetadata, err error) {
+// Download an existing file. The contents are handed to the serve function and
+// are only valid until it returns; the file can't be deleted in the meantime.
+// Contents compressed at rest with one of the accepted encodings are handed
+// over compressed, otherwise they are decompressed on the fly.
+func (fn FileNamespace) Download(
+	filePath string,
+	encodings []string,
+	serve func(meta *FileMetadata, content io.ReadSeeker) error,
+) error {
+	return fn.DownloadVersion(filePath, "", encodings, serve)
+}
+
+// DownloadVersion of a file like Download. An empty version ID downloads the
+// current version.
+func (fn FileNamespace) DownloadVersion(
+	filePath, versionID string,
+	encodings []string,
+	serve func(meta *FileMetadata, content io.ReadSeeker) error,
+) (err error) {
+	var objectKey ObjectKey
+	if objectKey, err = ParseObjectKey(filePath); nil != err {
+		return
+	}
+
 	// Get a lock on the file to prevent deletion while downloading.
 	var f *database.File
-	if f, err = database.GetFileForDownload(filePath); nil != err {
+	if "" == versionID {
+		f, err = database.GetFileForDownload(objectKey.String())
+	} else {
+		f, err = database.GetVersionForDownload(objectKey.String(), versionID)
+	}
+	if nil != err {
 		return
 	}
 	defer f.Done()
+	database.Touch(f)
 
-	// Create metadata.
-	meta = &FileMetadata{
+	// Open file from its tier, which may have just changed.
+	tier := database.TierOf(f)
+	var obj storage.Object
+	if obj, err = fn.tier(tier).Get(f.StorageKey()); storage.ErrNotFound == err && nil != fn.Cold {
+		tier = otherTier(tier)
+		obj, err = fn.tier(tier).Get(f.StorageKey())
+	}
+	if nil != err {
+		return
+	}
+	defer obj.Close()
+
+	// Decrypt encrypted contents.
+	var content io.ReadSeeker = obj
+	if "" != f.WrappedKey {
+		if content, err = decrypter(f, obj); nil != err {
+			return
+		}
+	}
+
+	// Pass compressed contents through when accepted.
+	meta := newFileMetadata(f)
+	meta.Tier = tierName(tier)
+	if "" == meta.Encoding || contains(encodings, meta.Encoding) {
+		return serve(meta, content)
+	}
+
+	// Decompress for the client.
+	var decoder *codec.Decoder
+	if decoder, err = codec.NewDecoder(meta.Encoding, content, meta.Size); nil != err {
+		return
+	}
+	defer decoder.Close()
+	meta.Encoding = ""
+	return serve(meta, decoder)
+}
+
+// Delete a file on behalf of a user. Versioned files are kept as a past version
+// behind a delete marker, and others are moved to the trash when enabled.
+func (fn FileNamespace) Delete(filePath, deletedBy string) error {
+	objectKey, err := ParseObjectKey(filePath)
+	if nil != err {
+		return err
+	}
+	if trashEnabled(objectKey.String()) {
+		return moveToTrash(objectKey.String(), deletedBy)
+	}
+	if !versioningEnabled(objectKey.String()) {
+		return database.RemoveFile(objectKey.String())
+	}
+	marker, err := newDeleteMarker(objectKey.String())
+	if nil != err {
+		return err
+	}
+	return database.AddDeleteMarker(marker)
+}
+
+// newFileMetadata copied from a database file.
+func newFileMetadata(f *database.File) *FileMetadata {
+	return &FileMetadata{
 		Path:        f.Path,
 		ContentType: f.ContentType,
 		Uploader:    f.Uploader,
+		Modified:    f.Modified,
+		Size:        f.Size,
+		SHA256:      f.SHA256,
+		MD5:         f.MD5,
+		Encoding:    f.Encoding,
+
+		VersionID:    f.Version(),
+		DeleteMarker: f.DeleteMarker,
+
+		RetentionMode: f.RetentionMode,
+		RetainUntil:   f.RetainUntil,
+		LegalHold:     f.LegalHold,
+		Tier:          tierName(f.Tier),
 	}
+}
 
-	// Download file from storage.
-	err = storage.Download(filePath, w)
-	return
+// compressionFor an upload, which is the codec of the first matching rule or
+// empty for none. Every list in a rule that isn't empty must have a match.
+func compressionFor(filePath, contentType string) string {
+	for _, rule := range config.Get.Storage.Compression {
+		if 0 < len(rule.Prefixes) && !anyMatch(rule.Prefixes, func(prefix string) bool {
+			return strings.HasPrefix(filePath, prefix)
+		}) {
+			continue
+		}
+		if 0 < len(rule.ContentTypes) && !matchContentType(rule.ContentTypes, contentType) {
+			continue
+		}
+		return rule.Codec
+	}
+	return ""
+}
+
+// matchContentType against any of the patterns, which may be wildcard subtypes
+// such as "text/*". Parameters such as "charset" don't matter when matching.
+func matchContentType(patterns []string, contentType string) bool {
+	mediaType := strings.TrimSpace(strings.SplitN(contentType, ";", 2)[0])
+	return anyMatch(patterns, func(pattern string) bool {
+		if strings.HasSuffix(pattern, "/*") {
+			return strings.HasPrefix(mediaType, strings.TrimSuffix(pattern, "*"))
+		}
+		return strings.EqualFold(pattern, mediaType)
+	})
+}
+
+// anyMatch returns true when the match function is true for any value.
+func anyMatch(values []string, match func(string) bool) bool {
+	for _, value := range values {
+		if match(value) {
+			return true
+		}
+	}
+	return false
+}
+
+// contains returns true when the value is in the list.
+func contains(values []string, value string) bool {
+	return anyMatch(values, func(v string) bool { return v == value })
+}
+
+// verifyChecksum received against the expected value, if one was provided.
+func verifyChecksum(algorithm, expected, received string) error {
+	if "" == expected || strings.EqualFold(expected, received) {
+		return nil
+	}
+	return &ChecksumError{Algorithm: algorithm, Expected: expected, Received: received}
+}
+
+// countWriter counts bytes written to it.
+type countWriter struct {
+	n int64
+}
+
+// Write by counting.
+func (cw *countWriter) Write(p []byte) (int, error) {
+	cw.n += int64(len(p))
+	return len(p), nil
+}
+
+// release the contents of a file removed from the database, deleting them when
+// no file refers to them anymore. Contents shared with other files, or a path
+// that has since been uploaded again, are kept, though a copy left in another
+// tier by the file is deleted.
+func (fn FileNamespace) release(f *database.File) {
+	key := f.StorageKey()
+	unlock := lockKey(key)
+	defer unlock()
+
+	if 0 < database.References(key) {
+		if current, err := database.GetMetadataByKey(key); nil == err && current.Tier != f.Tier {
+			deleteObject(fn.tier(f.Tier), key)
+		}
+		return
+	}
+	for _, backend := range fn.tiers() {
+		deleteObject(backend, key)
+	}
 }
 
-// Delete a file.
-func (fn FileNamespace) Delete(filePath string) error {
-	return database.RemoveFile(filePath)
+// deleteObject at the key from the backend, logging failures.
+func deleteObject(backend storage.Backend, key string) {
+	if err := backend.Delete(key); nil != err && storage.ErrNotFound != err {
+		log.Printf("Received error while deleting %s: %v\n", key, err)
+	}
+}
+
+// blobKey of deduplicated contents with the SHA-256 digest. Blobs are spread
+// over subfolders by the leading digits of the digest. Contents compressed
+// differently are different blobs.
+func blobKey(digest, encoding string) string {
+	name := digest
+	if "" != encoding {
+		name += "." + encoding
+	}
+	return path.Join("/", blobFolder, digest[:2], digest[2:4], name)
+}
+
+// isBlobKey of deduplicated contents, which may be shared by any number of
+// files.
+func isBlobKey(key string) bool {
+	return strings.HasPrefix(key, "/"+blobFolder+"/")
+}
+
+// newObjectKey for contents in the hashed layout. Objects are spread over
+// subfolders by the leading digits of a random ID.
+func newObjectKey() (key string, err error) {
+	raw := make([]byte, 16)
+	if _, err = rand.Read(raw); nil != err {
+		return
+	}
+	id := hex.EncodeToString(raw)
+	key = path.Join("/", objectFolder, id[:2], id[2:4], id)
+	return
 }
@@ -0,0 +1,82 @@
+package model
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/halverneus/example/storage"
+	"golang.org/x/text/unicode/norm"
+)
+
+const (
+	// maxKeyLength of an object key in bytes.
+	maxKeyLength = 1024
+	// maxKeyElementLength of each slash-separated element of a key in bytes.
+	maxKeyElementLength = 255
+)
+
+// ObjectKey is the canonical form of a file path: NFC normalized, starting with
+// a slash and free of empty, "." and ".." elements. Paths that differ only in
+// those respects name the same file.
+type ObjectKey string
+
+// KeyError is returned when a file path can't be made into an object key.
+type KeyError struct {
+	Path   string
+	Reason string
+}
+
+// Error message for the invalid path.
+func (e *KeyError) Error() string {
+	return fmt.Sprintf("invalid file path %q: %s", e.Path, e.Reason)
+}
+
+// ParseObjectKey from a file path.
+func ParseObjectKey(filePath string) (key ObjectKey, err error) {
+	invalid := func(reason string, a ...interface{}) (ObjectKey, error) {
+		return "", &KeyError{Path: filePath, Reason: fmt.Sprintf(reason, a...)}
+	}
+
+	if !utf8.ValidString(filePath) {
+		return invalid("not valid UTF-8")
+	}
+	for _, r := range filePath {
+		if unicode.IsControl(r) {
+			return invalid("contains control character %U", r)
+		}
+	}
+
+	// Drop empty and "." elements, refusing to climb out with "..".
+	elements := []string{}
+	for _, element := range strings.Split(norm.NFC.String(filePath), "/") {
+		switch element {
+		case "", ".":
+			continue
+		case "..":
+			return invalid(`contains ".."`)
+		}
+		if maxKeyElementLength < len(element) {
+			return invalid("element longer than %d bytes", maxKeyElementLength)
+		}
+		elements = append(elements, element)
+	}
+	if 0 == len(elements) {
+		return invalid("empty")
+	}
+
+	key = ObjectKey("/" + strings.Join(elements, "/"))
+	switch {
+	case maxKeyLength < len(key):
+		return invalid("longer than %d bytes", maxKeyLength)
+	case blobFolder == elements[0], objectFolder == elements[0], storage.Reserved(string(key)):
+		return invalid("%q is reserved", elements[0])
+	}
+	return
+}
+
+// String of the key.
+func (key ObjectKey) String() string {
+	return string(key)
+}
@@ -0,0 +1,38 @@
+package model
+
+import (
+	"hash/fnv"
+	"sync"
+)
+
+var (
+	// keyLocks serialize committing and deleting contents at the same storage
+	// key. Keys are spread over a fixed set of locks so that unrelated keys
+	// rarely wait on each other.
+	keyLocks [64]sync.Mutex
+
+	// uploadLocks serialize changes to uploads in progress. They are kept apart
+	// from keyLocks, since completing an upload commits contents while locked.
+	uploadLocks [64]sync.Mutex
+)
+
+// lockKey for committing or deleting contents. Call the returned function to
+// unlock.
+func lockKey(key string) (unlock func()) {
+	return lockStripe(keyLocks[:], key)
+}
+
+// lockUpload for changing an upload in progress. Call the returned function to
+// unlock.
+func lockUpload(id string) (unlock func()) {
+	return lockStripe(uploadLocks[:], id)
+}
+
+// lockStripe of the set the name hashes to.
+func lockStripe(locks []sync.Mutex, name string) (unlock func()) {
+	h := fnv.New32a()
+	h.Write([]byte(name))
+	mtx := &locks[h.Sum32()%uint32(len(locks))]
+	mtx.Lock()
+	return mtx.Unlock
+}
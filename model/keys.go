@@ -0,0 +1,116 @@
+package model
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/halverneus/example/config"
+	"github.com/halverneus/example/database"
+	"github.com/halverneus/example/lib/encrypt"
+)
+
+var (
+	// Keys namespace contains all encryption key functions.
+	Keys KeysNamespace
+
+	// masterKey wraps the data key of every encrypted file. Nil when encryption
+	// isn't configured.
+	masterKey   []byte
+	masterKeyID string
+)
+
+// KeysNamespace is used to organize the controller/model functions.
+type KeysNamespace struct{}
+
+// Rotate every data key to the master key in the key file, which is created
+// with a new random key if it doesn't exist. Contents are not rewritten, only
+// the wrapped data keys in the database. Returns the number of keys rewrapped.
+func (kn KeysNamespace) Rotate(keyFile string) (count int, err error) {
+	// Create a new master key when needed.
+	if _, err = os.Stat(keyFile); os.IsNotExist(err) {
+		var key []byte
+		if key, err = encrypt.NewKey(); nil != err {
+			return
+		}
+		if err = encrypt.SaveKeyFile(keyFile, key); nil != err {
+			return
+		}
+	} else if nil != err {
+		return
+	}
+
+	var newKey []byte
+	if newKey, err = encrypt.LoadKeyFile(keyFile); nil != err {
+		return
+	}
+	newKeyID := encrypt.KeyID(newKey)
+
+	return database.RewrapKeys(func(keyID, wrapped string) (string, string, error) {
+		// Keys already under the new master key are left alone, so an interrupted
+		// rotation can simply be run again.
+		if newKeyID == keyID {
+			return keyID, wrapped, nil
+		}
+		if err := checkMasterKey(keyID); nil != err {
+			return "", "", err
+		}
+		dataKey, err := encrypt.UnwrapKey(masterKey, wrapped)
+		if nil != err {
+			return "", "", err
+		}
+		newWrapped, err := encrypt.WrapKey(newKey, dataKey)
+		return newKeyID, newWrapped, err
+	})
+}
+
+// loadMasterKey from the configured key file, if any.
+func loadMasterKey() (err error) {
+	masterKey, masterKeyID = nil, ""
+	if "" == config.Get.Storage.Encryption.KeyFile {
+		return
+	}
+	if masterKey, err = encrypt.LoadKeyFile(config.Get.Storage.Encryption.KeyFile); nil != err {
+		return
+	}
+	masterKeyID = encrypt.KeyID(masterKey)
+	return
+}
+
+// checkMasterKey is loaded and is the one that wrapped a data key.
+func checkMasterKey(keyID string) error {
+	if nil == masterKey {
+		return fmt.Errorf("data key wrapped by master key %s, but no key file is configured", keyID)
+	}
+	if "" != keyID && masterKeyID != keyID {
+		return fmt.Errorf("data key wrapped by master key %s, but the configured key is %s", keyID, masterKeyID)
+	}
+	return nil
+}
+
+// encrypter for contents with a new data key, which is returned wrapped by the
+// master key.
+func encrypter(r io.Reader) (e *encrypt.Encrypter, wrapped, keyID string, err error) {
+	var dataKey []byte
+	if dataKey, err = encrypt.NewKey(); nil != err {
+		return
+	}
+	if wrapped, err = encrypt.WrapKey(masterKey, dataKey); nil != err {
+		return
+	}
+	keyID = masterKeyID
+	e, err = encrypt.NewEncrypter(dataKey, r)
+	return
+}
+
+// decrypter for the stored contents of an encrypted file.
+func decrypter(f *database.File, obj io.ReadSeeker) (d *encrypt.Decrypter, err error) {
+	if err = checkMasterKey(f.KeyID); nil != err {
+		return
+	}
+	var dataKey []byte
+	if dataKey, err = encrypt.UnwrapKey(masterKey, f.WrappedKey); nil != err {
+		return
+	}
+	return encrypt.NewDecrypter(dataKey, obj)
+}
@@ -0,0 +1,112 @@
+package model
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/halverneus/example/config"
+	"github.com/halverneus/example/database"
+	"github.com/halverneus/example/lib/encrypt"
+)
+
+// TestRotate the master key to a new key file, then download every file with
+// only the new key configured.
+func TestRotate(t *testing.T) {
+	const oldKeyFile, newKeyFile = "old.key", "new.key"
+
+	// Load the database and keep contents in memory, without encryption to
+	// begin with. Delete everything on completion.
+	if err := database.Load("json", "rotate.db"); nil != err {
+		t.Fatalf("While loading database: %v\n", err)
+	}
+	defer os.Remove("rotate.db")
+	defer os.Remove("rotate.db.journal")
+	defer os.Remove(oldKeyFile)
+	defer os.Remove(newKeyFile)
+
+	config.Get.Storage.Driver = "memory"
+	defer func() {
+		config.Get.Storage.Driver = "filesystem"
+		config.Get.Storage.Encryption.KeyFile = ""
+		loadMasterKey()
+	}()
+	if err := Load(); nil != err {
+		t.Fatalf("While loading model: %v\n", err)
+	}
+
+	// use the master key in the key file.
+	use := func(keyFile string) {
+		config.Get.Storage.Encryption.KeyFile = keyFile
+		if err := loadMasterKey(); nil != err {
+			t.Fatalf("Failed to load %s with: %v\n", keyFile, err)
+		}
+	}
+
+	// upload contents to the path.
+	upload := func(filePath, contents string) {
+		if err := File.Upload(&FileMetadata{Path: filePath, Uploader: "john"}, strings.NewReader(contents)); nil != err {
+			t.Fatalf("Failed to upload %s with: %v\n", filePath, err)
+		}
+	}
+
+	// download the contents at the path.
+	download := func(filePath string) (contents string, err error) {
+		err = File.Download(filePath, nil, func(meta *FileMetadata, content io.ReadSeeker) error {
+			raw, err := ioutil.ReadAll(content)
+			contents = string(raw)
+			return err
+		})
+		return
+	}
+
+	// A file uploaded before encryption was enabled, and two encrypted with the
+	// old master key.
+	upload("/plain.txt", "plain")
+	oldKey, err := encrypt.NewKey()
+	if nil == err {
+		err = encrypt.SaveKeyFile(oldKeyFile, oldKey)
+	}
+	if nil != err {
+		t.Fatalf("Failed to create %s with: %v\n", oldKeyFile, err)
+	}
+	use(oldKeyFile)
+	upload("/a.txt", "alpha")
+	upload("/b.txt", "beta")
+
+	// Rotating creates the new key file and rewraps both data keys.
+	if count, err := Keys.Rotate(newKeyFile); nil != err || 2 != count {
+		t.Fatalf("Expected 2 keys to be rewrapped and got %d (%v)\n", count, err)
+	}
+	newKey, err := encrypt.LoadKeyFile(newKeyFile)
+	if nil != err {
+		t.Fatalf("Failed to load %s with: %v\n", newKeyFile, err)
+	}
+	for _, f := range database.ListFiles() {
+		if "" != f.WrappedKey && encrypt.KeyID(newKey) != f.KeyID {
+			t.Errorf("Expected %s to be wrapped by the new key and got %s\n", f.Path, f.KeyID)
+		}
+	}
+
+	// Only the new key is needed from now on.
+	if err = os.Remove(oldKeyFile); nil != err {
+		t.Fatalf("Failed to remove %s with: %v\n", oldKeyFile, err)
+	}
+	use(newKeyFile)
+	for filePath, expected := range map[string]string{"/plain.txt": "plain", "/a.txt": "alpha", "/b.txt": "beta"} {
+		if contents, err := download(filePath); nil != err || expected != contents {
+			t.Errorf("Expected %q at %s and got %q (%v)\n", expected, filePath, contents, err)
+		}
+	}
+
+	// The old key can't read the files anymore.
+	if err = encrypt.SaveKeyFile(oldKeyFile, oldKey); nil != err {
+		t.Fatalf("Failed to restore %s with: %v\n", oldKeyFile, err)
+	}
+	use(oldKeyFile)
+	if _, err = download("/a.txt"); nil == err {
+		t.Error("Expected the old key to be refused")
+	}
+}
@@ -0,0 +1,93 @@
+package model
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/halverneus/example/config"
+	"github.com/halverneus/example/database"
+)
+
+const (
+	// lifecycleInterval between looking for files expired by lifecycle rules.
+	lifecycleInterval = time.Hour
+)
+
+var (
+	// Lifecycle namespace contains all lifecycle-specific functions.
+	Lifecycle LifecycleNamespace
+)
+
+// LifecycleNamespace is used to organize the controller/model functions.
+type LifecycleNamespace struct{}
+
+// Expire files matching any lifecycle rule, deleting them for good. Files under
+// retention or legal hold are left until released. Returns the files removed,
+// which stops at the first failure.
+func (ln LifecycleNamespace) Expire() (removed []*FileMetadata, err error) {
+	if 0 == len(config.Get.Lifecycle) {
+		return
+	}
+
+	now := time.Now()
+	for _, f := range database.ListFiles() {
+		if !expired(f, now) || f.Locked() {
+			continue
+		}
+
+		// Leave the path alone when uploaded again since listing.
+		if current, errX := database.GetMetadata(f.Path); nil != errX || !expired(current, now) || current.Locked() {
+			continue
+		}
+		if err = database.RemoveFile(f.Path); nil != err {
+			return
+		}
+		removed = append(removed, newFileMetadata(f))
+	}
+	return
+}
+
+// expire files and report what was removed.
+func (ln LifecycleNamespace) expire() {
+	removed, err := ln.Expire()
+	for _, meta := range removed {
+		log.Printf("Lifecycle expired %s (uploaded %s by %s).\n", meta.Path, meta.Modified.Format(time.RFC3339), meta.Uploader)
+	}
+	if 0 < len(removed) {
+		log.Printf("Lifecycle expired %d files.\n", len(removed))
+	}
+	if nil != err {
+		log.Printf("Received error while expiring files: %v\n", err)
+	}
+}
+
+// validLifecycle rules, which need a positive minimum age.
+func validLifecycle() error {
+	for _, rule := range config.Get.Lifecycle {
+		if 0 >= rule.MinAge {
+			return fmt.Errorf("lifecycle rule for prefix %q needs a positive min_age", rule.Prefix)
+		}
+	}
+	return nil
+}
+
+// expired files are older than the minimum age of a matching rule. Files whose
+// age is unknown, recorded before modification times with a creation time that
+// couldn't be read, never expire.
+func expired(f *database.File, now time.Time) bool {
+	if f.Modified.IsZero() {
+		return false
+	}
+	for _, rule := range config.Get.Lifecycle {
+		if !strings.HasPrefix(f.Path, rule.Prefix) || now.Sub(f.Modified) < rule.MinAge {
+			continue
+		}
+		if 0 < len(rule.ContentTypes) && !matchContentType(rule.ContentTypes, f.ContentType) {
+			continue
+		}
+		return true
+	}
+	return false
+}
@@ -0,0 +1,67 @@
+package model
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/halverneus/example/config"
+	"github.com/halverneus/example/database"
+	"gopkg.in/yaml.v2"
+)
+
+// TestLifecycleLegacy files, recorded before modification times, which expire
+// by their creation time and are left alone when it is unknown.
+func TestLifecycleLegacy(t *testing.T) {
+	// Keep contents in memory and expire logs after 30 days. Delete everything
+	// on completion.
+	config.Get.Storage.Driver = "memory"
+	defer func() {
+		config.Get.Storage.Driver = "filesystem"
+		config.Get.Lifecycle = nil
+		matches, _ := filepath.Glob("lifecycle.db*")
+		for _, match := range matches {
+			os.Remove(match)
+		}
+	}()
+	if err := yaml.Unmarshal([]byte(`lifecycle: [{prefix: /logs/, min_age: 720h}]`), &config.Get); nil != err {
+		t.Fatalf("Failed to parse lifecycle rules with: %v\n", err)
+	}
+
+	// Files created today, two months ago and at an unreadable time, as written
+	// by the first version.
+	const format = "Jan 2, 2006 3:04 PM"
+	file := func(filePath, created string) string {
+		return fmt.Sprintf(`{"path": %q, "content-type": "text/plain", "uploader": "john", "created": %q}`, filePath, created)
+	}
+	legacy := `{"users": [], "files": [` +
+		file("/logs/new.txt", time.Now().Format(format)) + `,` +
+		file("/logs/old.txt", time.Now().AddDate(0, -2, 0).Format(format)) + `,` +
+		file("/logs/unknown.txt", "someday") + `]}`
+	if err := ioutil.WriteFile("lifecycle.db", []byte(legacy), 0666); nil != err {
+		t.Fatalf("Failed to write database with: %v\n", err)
+	}
+	if err := database.Load("json", "lifecycle.db"); nil != err {
+		t.Fatalf("While loading database: %v\n", err)
+	}
+	if err := Load(); nil != err {
+		t.Fatalf("While loading model: %v\n", err)
+	}
+
+	// Only the file created long ago expires.
+	removed, err := Lifecycle.Expire()
+	if nil != err {
+		t.Fatalf("Failed to expire files with: %v\n", err)
+	}
+	if 1 != len(removed) || "/logs/old.txt" != removed[0].Path {
+		t.Errorf("Expected only /logs/old.txt to expire and got %d files\n", len(removed))
+	}
+	for _, filePath := range []string{"/logs/new.txt", "/logs/unknown.txt"} {
+		if _, err := database.GetMetadata(filePath); nil != err {
+			t.Errorf("Expected %s to survive and got: %v\n", filePath, err)
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package model
+
+import (
+	"strings"
+
+	"github.com/halverneus/example/database"
+	"golang.org/x/text/unicode/norm"
+)
+
+const (
+	// MaxListKeys listed at once, which is also the default.
+	MaxListKeys = 1000
+)
+
+// FileListing of current files under a prefix, ordered by path. Paths holding
+// the delimiter after the prefix are rolled up into common prefixes ending with
+// it. Truncated listings continue by listing again with Next as the start.
+type FileListing struct {
+	Files     []*FileMetadata
+	Prefixes  []string
+	Truncated bool
+	Next      string
+}
+
+// List up to max files and common prefixes under the prefix, after the start
+// when not empty. Zero or too many lists up to MaxListKeys. Prefixes match the
+// canonical paths, which start with a slash, so folders are listed with a
+// trailing slash and a "/" delimiter (ex: "/docs/").
+func (fn FileNamespace) List(prefix, delimiter, startAfter string, max int) *FileListing {
+	prefix = norm.NFC.String(prefix)
+	if !strings.HasPrefix(prefix, "/") {
+		prefix = "/" + prefix
+	}
+	if 0 >= max || MaxListKeys < max {
+		max = MaxListKeys
+	}
+
+	listing := database.ListPrefix(prefix, delimiter, norm.NFC.String(startAfter), max)
+	list := &FileListing{
+		Files:     make([]*FileMetadata, len(listing.Files)),
+		Prefixes:  listing.Prefixes,
+		Truncated: listing.Truncated,
+		Next:      listing.Next,
+	}
+	for i, f := range listing.Files {
+		list.Files[i] = newFileMetadata(f)
+	}
+	return list
+}
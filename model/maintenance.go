@@ -0,0 +1,95 @@
+package model
+
+import (
+	"log"
+
+	"github.com/halverneus/example/database"
+	"github.com/halverneus/example/storage"
+)
+
+const (
+	// convertBatch is the number of files converted between database saves.
+	convertBatch = 100
+)
+
+var (
+	// Maintenance namespace contains offline storage maintenance functions.
+	Maintenance MaintenanceNamespace
+)
+
+// MaintenanceNamespace is used to organize the controller/model functions.
+type MaintenanceNamespace struct{}
+
+// Convert every file stored at its path to the hashed layout. Contents are
+// copied to their new key within their tier, recorded in the database, then
+// removed from the path, so an interrupted conversion can be run again. Files
+// missing from storage are logged and skipped. Returns the number of files
+// converted.
+func (mn MaintenanceNamespace) Convert() (count int, err error) {
+	keys := map[string]string{}
+	tiers := map[string]string{}
+
+	// flush converted files to the database before removing the originals.
+	flush := func() (err error) {
+		if err = database.SetStorageKeys(keys); nil != err {
+			return
+		}
+		for filePath := range keys {
+			deleteObject(File.tier(tiers[filePath]), filePath)
+		}
+		count += len(keys)
+		keys = map[string]string{}
+		return
+	}
+
+	// Past versions may share the contents at a path with the current version,
+	// and files in the trash keep theirs at the path they were deleted from.
+	all := append(database.ListFiles(), database.ListHistory()...)
+	for _, item := range database.ListTrash() {
+		all = append(all, item.File)
+	}
+	paths := []string{}
+	for _, f := range all {
+		if _, found := tiers[f.Path]; "" == f.Key && !found {
+			tiers[f.Path] = f.Tier
+			paths = append(paths, f.Path)
+		}
+	}
+
+	for _, filePath := range paths {
+		var key string
+		if key, err = newObjectKey(); nil != err {
+			return
+		}
+		backend := File.tier(tiers[filePath])
+		if err = copyObject(backend, filePath, backend, key); storage.ErrNotFound == err {
+			log.Printf("Skipping %s, which is missing from storage\n", filePath)
+			continue
+		} else if nil != err {
+			return
+		}
+
+		if keys[filePath] = key; convertBatch == len(keys) {
+			if err = flush(); nil != err {
+				return
+			}
+		}
+	}
+	err = flush()
+	return
+}
+
+// copyObject at a key in a backend to a key in another, or the same, backend.
+func copyObject(from storage.Backend, fromKey string, to storage.Backend, toKey string) (err error) {
+	var obj storage.Object
+	if obj, err = from.Get(fromKey); nil != err {
+		return
+	}
+	defer obj.Close()
+
+	var upload storage.Upload
+	if upload, err = to.Put(obj); nil != err {
+		return
+	}
+	return upload.Commit(toKey)
+}
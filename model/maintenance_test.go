@@ -0,0 +1,157 @@
+package model
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/halverneus/example/config"
+	"github.com/halverneus/example/database"
+	"github.com/halverneus/example/storage"
+)
+
+// interruptedBackend fails to stage uploads once the allowance runs out.
+type interruptedBackend struct {
+	storage.Backend
+	allowance int
+}
+
+// Put the contents while allowed to.
+func (b *interruptedBackend) Put(r io.Reader) (storage.Upload, error) {
+	if 0 == b.allowance {
+		return nil, errors.New("storage is unavailable")
+	}
+	b.allowance--
+	return b.Backend.Put(r)
+}
+
+// TestConvert files stored at their path to the hashed layout, running the
+// conversion again after it was interrupted.
+func TestConvert(t *testing.T) {
+	// Load the database and keep contents in memory at their path, moving
+	// deleted files to the trash. Delete everything on completion.
+	if err := database.Load("json", "convert.db"); nil != err {
+		t.Fatalf("While loading database: %v\n", err)
+	}
+	defer os.Remove("convert.db")
+	defer os.Remove("convert.db.journal")
+
+	config.Get.Storage.Driver = "memory"
+	config.Get.Trash.Enabled = true
+	defer func() {
+		config.Get.Storage.Driver = "filesystem"
+		config.Get.Trash.Enabled = false
+		config.Get.Versioning.Prefixes = nil
+	}()
+	if err := Load(); nil != err {
+		t.Fatalf("While loading model: %v\n", err)
+	}
+	healthy := File.Storage
+
+	// upload contents to the path.
+	upload := func(filePath, contents string) {
+		if err := File.Upload(&FileMetadata{Path: filePath, Uploader: "john"}, strings.NewReader(contents)); nil != err {
+			t.Fatalf("Failed to upload %s with: %v\n", filePath, err)
+		}
+	}
+
+	// read the contents kept in storage for the file.
+	read := func(f *database.File) string {
+		obj, err := healthy.Get(f.StorageKey())
+		if nil != err {
+			t.Errorf("Failed to get contents of %s at %s with: %v\n", f.Path, f.StorageKey(), err)
+			return ""
+		}
+		defer obj.Close()
+		raw, _ := ioutil.ReadAll(obj)
+		return string(raw)
+	}
+
+	// every file in the database, mapped by path and contents.
+	every := func() map[string]*database.File {
+		all := map[string]*database.File{}
+		for _, f := range append(database.ListFiles(), database.ListHistory()...) {
+			all[f.Path+"="+read(f)] = f
+		}
+		for _, item := range database.ListTrash() {
+			all[item.File.Path+"="+read(item.File)] = item.File
+		}
+		return all
+	}
+
+	// expect files with the contents, at their path or not.
+	expect := func(atPath bool, contents ...string) {
+		all := every()
+		for _, c := range contents {
+			f, found := all[c]
+			switch {
+			case !found:
+				t.Errorf("Expected to find %s\n", c)
+			case atPath && "" != f.Key:
+				t.Errorf("Expected %s to be stored at its path and got %s\n", c, f.Key)
+			case !atPath && "" == f.Key:
+				t.Errorf("Expected %s to be stored at a key\n", c)
+			}
+		}
+	}
+
+	// convert, expecting the number of files converted.
+	convert := func(expected int) {
+		if count, err := Maintenance.Convert(); nil != err || expected != count {
+			t.Errorf("Expected %d files to be converted and got %d (%v)\n", expected, count, err)
+		}
+	}
+
+	// Plain files, a file deleted to the trash and replaced since, and a file
+	// replaced once versioned, each have contents at their path. The current
+	// files at the last two paths already have keys of their own.
+	upload("/a.txt", "a")
+	upload("/b.txt", "b")
+	upload("/t.txt", "trashed")
+	if err := File.Delete("/t.txt", "john"); nil != err {
+		t.Fatalf("Failed to delete /t.txt with: %v\n", err)
+	}
+	upload("/t.txt", "current")
+	upload("/v/doc.txt", "past")
+	config.Get.Versioning.Prefixes = []string{"/v/"}
+	upload("/v/doc.txt", "latest")
+	expect(true, "/a.txt=a", "/b.txt=b", "/t.txt=trashed", "/v/doc.txt=past")
+	if all := every(); "" == all["/t.txt=current"].Key || "" == all["/v/doc.txt=latest"].Key {
+		t.Fatal("Expected files replacing others at their path to have keys")
+	}
+
+	// Storage failing partway through leaves every file where it was.
+	File.Storage = &interruptedBackend{Backend: healthy, allowance: 2}
+	if _, err := Maintenance.Convert(); nil == err {
+		t.Error("Expected the interrupted conversion to fail")
+	}
+	File.Storage = healthy
+	expect(true, "/a.txt=a", "/b.txt=b", "/t.txt=trashed", "/v/doc.txt=past")
+
+	// Running again converts everything, removing the contents at the paths
+	// without touching the current files sharing them.
+	convert(4)
+	if all := every(); 6 != len(all) {
+		t.Errorf("Expected 6 files and got %d\n", len(all))
+	}
+	expect(false, "/a.txt=a", "/b.txt=b", "/t.txt=trashed", "/t.txt=current", "/v/doc.txt=past", "/v/doc.txt=latest")
+	for _, key := range []string{"/a.txt", "/b.txt", "/t.txt", "/v/doc.txt"} {
+		if _, err := healthy.Stat(key); storage.ErrNotFound != err {
+			t.Errorf("Expected contents at %s to be removed and got: %v\n", key, err)
+		}
+	}
+
+	// Nothing is left to convert, and the copies made before the interruption
+	// are the only orphans.
+	convert(0)
+	report, err := Maintenance.Check(false)
+	if nil != err {
+		t.Fatalf("Failed to check storage with: %v\n", err)
+	}
+	if 2 != len(report.Orphans) || 0 != len(report.Missing) || 0 != len(report.Mismatched) {
+		t.Errorf("Expected the 2 copies to be orphans and got %+v\n", report)
+	}
+}
@@ -0,0 +1,386 @@
+package model
+
+import (
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/halverneus/example/config"
+)
+
+const (
+	// multipartFolder inside the uploads folder holds a folder of parts for each
+	// multipart upload.
+	multipartFolder = "multipart"
+	// multipartInfo file in an upload's folder holds its description.
+	multipartInfo = "upload.json"
+	// maxPartNumber of a multipart upload. Part numbers start at one.
+	maxPartNumber = 10000
+	// sweepInterval between looking for expired uploads and trash.
+	sweepInterval = 10 * time.Minute
+)
+
+var (
+	// Multipart namespace contains all multipart upload functions.
+	Multipart MultipartNamespace
+
+	// ErrNoParts is returned when completing an upload without listing parts.
+	ErrNoParts = errors.New("no parts listed to complete the upload")
+)
+
+// MultipartUpload of a file arriving as numbered parts, which may be sent in
+// parallel and in any order. Parts are kept in the uploads folder until the
+// upload is completed or aborted.
+type MultipartUpload struct {
+	ID          string                 `json:"id"`
+	Path        string                 `json:"path"`
+	ContentType string                 `json:"content-type"`
+	Uploader    string                 `json:"uploader"`
+	Initiated   time.Time              `json:"initiated"`
+	Parts       map[int]*MultipartPart `json:"parts"`
+}
+
+// MultipartPart of an upload. Uploading a part again replaces it.
+type MultipartPart struct {
+	Number   int       `json:"part-number"`
+	ETag     string    `json:"etag"`
+	Size     int64     `json:"size"`
+	Modified time.Time `json:"modified"`
+}
+
+// PartError is returned for part numbers out of range, and for parts listed on
+// completion that weren't uploaded as described.
+type PartError struct {
+	Number int
+	Reason string
+}
+
+// Error message for the part.
+func (e *PartError) Error() string {
+	return fmt.Sprintf("part %d %s", e.Number, e.Reason)
+}
+
+// MultipartNamespace is used to organize the controller/model functions.
+type MultipartNamespace struct{}
+
+// Initiate a multipart upload, assigning its ID. Uploaders who can't store
+// another file are refused up front.
+func (mn MultipartNamespace) Initiate(u *MultipartUpload) (err error) {
+	var objectKey ObjectKey
+	if objectKey, err = ParseObjectKey(u.Path); nil != err {
+		return
+	}
+	u.Path = objectKey.String()
+	if quotaEnabled() {
+		if _, err = quotaAllowance(u.Path, u.Uploader); nil != err {
+			return
+		}
+	}
+
+	raw := make([]byte, 16)
+	if _, err = rand.Read(raw); nil != err {
+		return
+	}
+	u.ID = hex.EncodeToString(raw)
+	u.Initiated = time.Now().UTC()
+	u.Parts = map[int]*MultipartPart{}
+
+	if err = os.MkdirAll(multipartDir(u.ID), 0755); nil != err {
+		return
+	}
+	return saveMultipart(u)
+}
+
+// UploadPart of a multipart upload to a path. When set, the contents must hash
+// to the expected hex MD5 or they are discarded. Parts of the same upload are
+// received in parallel, and only wait on each other to be recorded.
+func (mn MultipartNamespace) UploadPart(
+	id, filePath, uploader string,
+	number int,
+	r io.Reader,
+	expectedMD5 string,
+) (part *MultipartPart, err error) {
+	if 1 > number || maxPartNumber < number {
+		err = &PartError{Number: number, Reason: fmt.Sprintf("is not between 1 and %d", maxPartNumber)}
+		return
+	}
+
+	// Make sure the upload exists before receiving anything.
+	unlock := lockUpload(id)
+	_, err = loadMultipart(id, filePath, uploader)
+	unlock()
+	if nil != err {
+		return
+	}
+
+	// Receive the part next to the others while hashing.
+	var file *os.File
+	if file, err = ioutil.TempFile(multipartDir(id), "part-"); nil != err {
+		return
+	}
+	defer func() {
+		file.Close()
+		if nil != err {
+			os.Remove(file.Name())
+		}
+	}()
+	md5Hash := md5.New()
+	var size int64
+	if size, err = io.Copy(io.MultiWriter(file, md5Hash), r); nil != err {
+		return
+	}
+	checksum := hex.EncodeToString(md5Hash.Sum(nil))
+	if err = verifyChecksum("MD5", expectedMD5, checksum); nil != err {
+		return
+	}
+	if err = file.Sync(); nil != err {
+		return
+	}
+
+	// Record the part, unless the upload finished in the meantime.
+	unlock = lockUpload(id)
+	defer unlock()
+	var u *MultipartUpload
+	if u, err = loadMultipart(id, filePath, uploader); nil != err {
+		return
+	}
+	if err = os.Rename(file.Name(), multipartPart(id, number)); nil != err {
+		return
+	}
+	part = &MultipartPart{
+		Number:   number,
+		ETag:     `"` + checksum + `"`,
+		Size:     size,
+		Modified: time.Now().UTC(),
+	}
+	u.Parts[number] = part
+	err = saveMultipart(u)
+	return
+}
+
+// ListParts of a multipart upload in part number order.
+func (mn MultipartNamespace) ListParts(
+	id, filePath, uploader string,
+) (u *MultipartUpload, parts []*MultipartPart, err error) {
+	unlock := lockUpload(id)
+	defer unlock()
+
+	if u, err = loadMultipart(id, filePath, uploader); nil != err {
+		return
+	}
+	parts = sortedParts(u)
+	return
+}
+
+// Complete a multipart upload by uploading the listed parts, in order, as a
+// single file. Each part must be listed with the ETag received when uploading
+// it, and parts left out are discarded.
+func (mn MultipartNamespace) Complete(
+	id, filePath, uploader string,
+	parts []*MultipartPart,
+) (meta *FileMetadata, err error) {
+	unlock := lockUpload(id)
+	defer unlock()
+
+	var u *MultipartUpload
+	if u, err = loadMultipart(id, filePath, uploader); nil != err {
+		return
+	}
+	if 0 == len(parts) {
+		err = ErrNoParts
+		return
+	}
+
+	// Open the parts in order.
+	readers := make([]io.Reader, len(parts))
+	var size int64
+	for i, listed := range parts {
+		if 0 < i && listed.Number <= parts[i-1].Number {
+			err = &PartError{Number: listed.Number, Reason: "is out of order"}
+			return
+		}
+		part, found := u.Parts[listed.Number]
+		if !found || !strings.EqualFold(strings.Trim(listed.ETag, `"`), strings.Trim(part.ETag, `"`)) {
+			err = &PartError{Number: listed.Number, Reason: "was not uploaded with the ETag " + listed.ETag}
+			return
+		}
+
+		var file *os.File
+		if file, err = os.Open(multipartPart(id, part.Number)); nil != err {
+			return
+		}
+		defer file.Close()
+		readers[i] = file
+		size += part.Size
+	}
+
+	meta = &FileMetadata{
+		Path:        u.Path,
+		ContentType: u.ContentType,
+		Uploader:    u.Uploader,
+		Size:        size,
+	}
+	if err = File.Upload(meta, io.MultiReader(readers...)); nil != err {
+		meta = nil
+		return
+	}
+	err = os.RemoveAll(multipartDir(id))
+	return
+}
+
+// Abort a multipart upload, discarding every part.
+func (mn MultipartNamespace) Abort(id, filePath, uploader string) (err error) {
+	unlock := lockUpload(id)
+	defer unlock()
+
+	if _, err = loadMultipart(id, filePath, uploader); nil != err {
+		return
+	}
+	return os.RemoveAll(multipartDir(id))
+}
+
+// Sweep aborts multipart uploads that have gone without a new part for longer
+// than the configured expiry, returning how many were aborted. A zero expiry
+// keeps uploads forever.
+func (mn MultipartNamespace) Sweep() (count int, err error) {
+	expiry := config.Get.Uploads.Expiry
+	if 0 >= expiry {
+		return
+	}
+
+	var dirs []os.FileInfo
+	if dirs, err = ioutil.ReadDir(path.Join(config.Get.Uploads.Folder, multipartFolder)); nil != err {
+		if os.IsNotExist(err) {
+			err = nil
+		}
+		return
+	}
+	cutoff := time.Now().Add(-expiry)
+	for _, dir := range dirs {
+		if !dir.IsDir() || !validUploadID(dir.Name()) {
+			continue
+		}
+		var aborted bool
+		if aborted, err = sweepMultipart(dir, cutoff); nil != err {
+			return
+		}
+		if aborted {
+			count++
+		}
+	}
+	return
+}
+
+// sweepMultipart aborts the upload in the folder when it was last active before
+// the cutoff. Folders without a description were interrupted while initiating
+// and go by their own modification time.
+func sweepMultipart(dir os.FileInfo, cutoff time.Time) (aborted bool, err error) {
+	id := dir.Name()
+	unlock := lockUpload(id)
+	defer unlock()
+
+	active := dir.ModTime()
+	var raw []byte
+	if raw, err = ioutil.ReadFile(path.Join(multipartDir(id), multipartInfo)); nil == err {
+		u := &MultipartUpload{}
+		if err = json.Unmarshal(raw, u); nil != err {
+			return
+		}
+		active = u.Initiated
+		for _, part := range u.Parts {
+			if part.Modified.After(active) {
+				active = part.Modified
+			}
+		}
+	} else if !os.IsNotExist(err) {
+		return
+	}
+	err = nil
+	if !active.Before(cutoff) {
+		return
+	}
+
+	log.Printf("Aborting multipart upload %s, idle since %s.\n", id, active.Format(time.RFC3339))
+	if err = os.RemoveAll(multipartDir(id)); nil != err {
+		return
+	}
+	aborted = true
+	return
+}
+
+// loadMultipart from the uploads folder if it belongs to the uploader and is an
+// upload to the path.
+func loadMultipart(id, filePath, uploader string) (u *MultipartUpload, err error) {
+	var objectKey ObjectKey
+	if objectKey, err = ParseObjectKey(filePath); nil != err {
+		return
+	}
+	if !validUploadID(id) {
+		err = ErrUploadNotFound
+		return
+	}
+	var raw []byte
+	if raw, err = ioutil.ReadFile(path.Join(multipartDir(id), multipartInfo)); nil != err {
+		if os.IsNotExist(err) {
+			err = ErrUploadNotFound
+		}
+		return
+	}
+	u = &MultipartUpload{}
+	if err = json.Unmarshal(raw, u); nil != err {
+		return
+	}
+	if uploader != u.Uploader || objectKey.String() != u.Path {
+		u, err = nil, ErrUploadNotFound
+		return
+	}
+	if nil == u.Parts {
+		u.Parts = map[int]*MultipartPart{}
+	}
+	return
+}
+
+// saveMultipart description, replacing the previous one in a single step.
+func saveMultipart(u *MultipartUpload) (err error) {
+	var raw []byte
+	if raw, err = json.Marshal(u); nil != err {
+		return
+	}
+	info := path.Join(multipartDir(u.ID), multipartInfo)
+	if err = ioutil.WriteFile(info+".tmp", raw, 0644); nil != err {
+		return
+	}
+	return os.Rename(info+".tmp", info)
+}
+
+// sortedParts of an upload by part number.
+func sortedParts(u *MultipartUpload) (parts []*MultipartPart) {
+	parts = make([]*MultipartPart, 0, len(u.Parts))
+	for _, part := range u.Parts {
+		parts = append(parts, part)
+	}
+	sort.Slice(parts, func(i, j int) bool { return parts[i].Number < parts[j].Number })
+	return
+}
+
+// multipartDir holding the parts of an upload.
+func multipartDir(id string) string {
+	return path.Join(config.Get.Uploads.Folder, multipartFolder, id)
+}
+
+// multipartPart file holding the contents of a part.
+func multipartPart(id string, number int) string {
+	return path.Join(multipartDir(id), strconv.Itoa(number))
+}
@@ -0,0 +1,118 @@
+package model
+
+import (
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/halverneus/example/config"
+	"github.com/halverneus/example/database"
+)
+
+var (
+	// quotaMtx serializes the final quota check with adding the file, so that
+	// concurrent uploads can't jointly exceed a quota.
+	quotaMtx sync.Mutex
+)
+
+// QuotaError is returned when an upload would exceed a quota.
+type QuotaError struct {
+	Scope string
+	Max   int64
+	Unit  string
+}
+
+// Error message for the exceeded quota.
+func (e *QuotaError) Error() string {
+	return fmt.Sprintf("upload exceeds the %s quota of %d %s", e.Scope, e.Max, e.Unit)
+}
+
+// allowance of bytes an upload may store before exceeding a quota.
+type allowance struct {
+	// bytes allowed, which is negative when unlimited.
+	bytes int64
+	// exceeded is the quota to report when going over.
+	exceeded *QuotaError
+}
+
+// permits storing the number of bytes.
+func (a allowance) permits(size int64) bool {
+	return 0 > a.bytes || size <= a.bytes
+}
+
+// quotaEnabled when any limit is configured.
+func quotaEnabled() bool {
+	quota := config.Get.Quota
+	return 0 < quota.Global.MaxBytes || 0 < quota.Global.MaxObjects ||
+		0 < quota.User.MaxBytes || 0 < quota.User.MaxObjects ||
+		0 < len(quota.Users)
+}
+
+// userLimit for an uploader, unless overridden for that user.
+func userLimit(uploader string) config.Limit {
+	if limit, found := config.Get.Quota.Users[uploader]; found {
+		return limit
+	}
+	return config.Get.Quota.User
+}
+
+// quotaAllowance for an upload to a path. Returns an error when the uploader
+// can't store another file at all.
+func quotaAllowance(filePath, uploader string) (a allowance, err error) {
+	a.bytes = -1
+	user, total := database.GetUsage(uploader)
+
+	// Contents being replaced no longer count, unless kept as a past version.
+	if replaced, errX := database.GetMetadata(filePath); nil == errX && !versioningEnabled(filePath) {
+		total.Bytes -= replaced.Size
+		total.Objects--
+		if uploader == replaced.Uploader {
+			user.Bytes -= replaced.Size
+			user.Objects--
+		}
+	}
+
+	// check a limit, keeping the tightest byte allowance.
+	check := func(scope string, limit config.Limit, used database.Usage) error {
+		if 0 < limit.MaxObjects && used.Objects >= limit.MaxObjects {
+			return &QuotaError{Scope: scope, Max: limit.MaxObjects, Unit: "files"}
+		}
+		if 0 < limit.MaxBytes {
+			remaining := limit.MaxBytes - used.Bytes
+			if 0 > remaining {
+				remaining = 0
+			}
+			if 0 > a.bytes || remaining < a.bytes {
+				a.bytes = remaining
+				a.exceeded = &QuotaError{Scope: scope, Max: limit.MaxBytes, Unit: "bytes"}
+			}
+		}
+		return nil
+	}
+	if err = check("global", config.Get.Quota.Global, total); nil != err {
+		return
+	}
+	err = check(fmt.Sprintf("user %q", uploader), userLimit(uploader), user)
+	return
+}
+
+// quotaReader fails once more bytes are read than allowed.
+type quotaReader struct {
+	r   io.Reader
+	a   allowance
+	n   int64
+	err error
+}
+
+// Read until the allowance is exceeded.
+func (qr *quotaReader) Read(p []byte) (n int, err error) {
+	if nil != qr.err {
+		return 0, qr.err
+	}
+	n, err = qr.r.Read(p)
+	if qr.n += int64(n); !qr.a.permits(qr.n) {
+		qr.err = qr.a.exceeded
+		err = qr.err
+	}
+	return
+}
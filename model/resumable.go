@@ -0,0 +1,390 @@
+package model
+
+import (
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/halverneus/example/config"
+)
+
+var (
+	// Uploads namespace contains all resumable upload functions.
+	Uploads UploadsNamespace
+
+	// ErrUploadNotFound is returned for unknown uploads, or those belonging to
+	// someone else.
+	ErrUploadNotFound = errors.New("upload not found")
+
+	// ErrUploadTooLarge is returned when appending past the announced length.
+	ErrUploadTooLarge = errors.New("contents exceed the upload length")
+
+	// checksumAlgorithms supported when appending to uploads.
+	checksumAlgorithms = map[string]func() hash.Hash{
+		"md5":    md5.New,
+		"sha1":   sha1.New,
+		"sha256": sha256.New,
+	}
+)
+
+// ResumableUpload of a file arriving in pieces. Contents are kept in the
+// uploads folder until complete, then uploaded as a file.
+type ResumableUpload struct {
+	ID          string    `json:"id"`
+	Path        string    `json:"path"`
+	ContentType string    `json:"content-type"`
+	Uploader    string    `json:"uploader"`
+	Length      int64     `json:"length"`
+	Metadata    string    `json:"metadata"`
+	Created     time.Time `json:"created"`
+
+	// Offset of the next byte, which is the amount received so far.
+	Offset int64 `json:"-"`
+
+	// Expires is when the upload is aborted unless more contents arrive. Zero
+	// when uploads never expire.
+	Expires time.Time `json:"-"`
+}
+
+// OffsetError is returned when appending at an offset other than the end.
+type OffsetError struct {
+	Expected int64
+	Received int64
+}
+
+// Error message for the mismatch.
+func (e *OffsetError) Error() string {
+	return fmt.Sprintf("upload is at offset %d, not %d", e.Expected, e.Received)
+}
+
+// UploadsNamespace is used to organize the controller/model functions.
+type UploadsNamespace struct{}
+
+// ChecksumAlgorithm returns true when the algorithm can verify appends.
+func (un UploadsNamespace) ChecksumAlgorithm(algorithm string) bool {
+	_, found := checksumAlgorithms[algorithm]
+	return found
+}
+
+// Create a new upload, assigning its ID. Uploads that can't possibly fit within
+// quotas are refused up front, and empty uploads become files immediately.
+func (un UploadsNamespace) Create(u *ResumableUpload) (err error) {
+	var objectKey ObjectKey
+	if objectKey, err = ParseObjectKey(u.Path); nil != err {
+		return
+	}
+	u.Path = objectKey.String()
+	if 0 > u.Length {
+		err = errors.New("upload length must not be negative")
+		return
+	}
+	if quotaEnabled() {
+		var a allowance
+		if a, err = quotaAllowance(u.Path, u.Uploader); nil != err {
+			return
+		}
+		if !a.permits(u.Length) {
+			err = a.exceeded
+			return
+		}
+	}
+
+	raw := make([]byte, 16)
+	if _, err = rand.Read(raw); nil != err {
+		return
+	}
+	u.ID = hex.EncodeToString(raw)
+	u.Created = time.Now().UTC()
+	u.Offset = 0
+	u.expire(u.Created)
+
+	if err = os.MkdirAll(config.Get.Uploads.Folder, 0755); nil != err {
+		return
+	}
+	if err = ioutil.WriteFile(uploadData(u.ID), nil, 0644); nil != err {
+		return
+	}
+	var info []byte
+	if info, err = json.Marshal(u); nil != err {
+		return
+	}
+	if err = ioutil.WriteFile(uploadInfo(u.ID), info, 0644); nil != err {
+		return
+	}
+
+	// Nothing to wait for when there are no contents.
+	if 0 == u.Length {
+		_, err = u.complete()
+	}
+	return
+}
+
+// Get an upload belonging to the uploader.
+func (un UploadsNamespace) Get(id, uploader string) (u *ResumableUpload, err error) {
+	unlock := lockUpload(id)
+	defer unlock()
+	return loadUpload(id, uploader)
+}
+
+// Append contents to an upload at the offset, which must be the end of what was
+// received so far. When the algorithm is set, the contents must hash to the
+// expected hex checksum or they are discarded. Once every byte has arrived the
+// upload becomes a file, returning its metadata. A complete upload that failed
+// to become a file is tried again by appending nothing.
+func (un UploadsNamespace) Append(
+	id, uploader string,
+	offset int64,
+	r io.Reader,
+	algorithm, expected string,
+) (u *ResumableUpload, meta *FileMetadata, err error) {
+	unlock := lockUpload(id)
+	defer unlock()
+
+	if u, err = loadUpload(id, uploader); nil != err {
+		return
+	}
+	if offset != u.Offset {
+		err = &OffsetError{Expected: u.Offset, Received: offset}
+		return
+	}
+
+	// Append no more than is left, plus a byte to notice clients sending more.
+	var file *os.File
+	if file, err = os.OpenFile(uploadData(id), os.O_WRONLY|os.O_APPEND, 0644); nil != err {
+		return
+	}
+	defer file.Close()
+
+	w := io.Writer(file)
+	var checksum hash.Hash
+	if "" != algorithm {
+		newHash, found := checksumAlgorithms[algorithm]
+		if !found {
+			err = fmt.Errorf("unsupported checksum algorithm: %s", algorithm)
+			return
+		}
+		checksum = newHash()
+		w = io.MultiWriter(file, checksum)
+	}
+	remaining := u.Length - u.Offset
+	written, err := io.Copy(w, io.LimitReader(r, remaining+1))
+	switch {
+	case remaining < written:
+		err = ErrUploadTooLarge
+	case nil == err && nil != checksum:
+		err = verifyChecksum(algorithm, expected, hex.EncodeToString(checksum.Sum(nil)))
+	}
+
+	// Undo appends that can't be trusted. Without a checksum, whatever arrived
+	// before a broken connection is kept for the client to resume from.
+	if nil != err && (ErrUploadTooLarge == err || nil != checksum) {
+		if errX := file.Truncate(u.Offset); nil != errX {
+			err = errX
+		}
+		return
+	}
+	if errX := file.Sync(); nil == err {
+		err = errX
+	}
+	u.Offset += written
+	if 0 < written {
+		u.expire(time.Now())
+	}
+	if nil != err || u.Offset < u.Length {
+		return
+	}
+
+	meta, err = u.complete()
+	return
+}
+
+// Terminate an upload, discarding everything received.
+func (un UploadsNamespace) Terminate(id, uploader string) (err error) {
+	unlock := lockUpload(id)
+	defer unlock()
+
+	if _, err = loadUpload(id, uploader); nil != err {
+		return
+	}
+	return removeUpload(id)
+}
+
+// Sweep aborts resumable uploads that have gone without new contents for longer
+// than the configured expiry, returning how many were aborted. A zero expiry
+// keeps uploads forever.
+func (un UploadsNamespace) Sweep() (count int, err error) {
+	expiry := config.Get.Uploads.Expiry
+	if 0 >= expiry {
+		return
+	}
+
+	var infos []os.FileInfo
+	if infos, err = ioutil.ReadDir(config.Get.Uploads.Folder); nil != err {
+		if os.IsNotExist(err) {
+			err = nil
+		}
+		return
+	}
+	cutoff := time.Now().Add(-expiry)
+	swept := map[string]bool{}
+	for _, info := range infos {
+		id := strings.TrimSuffix(info.Name(), ".json")
+		if info.IsDir() || !validUploadID(id) || swept[id] {
+			continue
+		}
+		swept[id] = true
+		var aborted bool
+		if aborted, err = sweepUpload(id, cutoff); nil != err {
+			return
+		}
+		if aborted {
+			count++
+		}
+	}
+	return
+}
+
+// sweepUpload aborts the upload when it was last active before the cutoff. An
+// upload interrupted while being created or removed may be missing either file
+// and goes by the other.
+func sweepUpload(id string, cutoff time.Time) (aborted bool, err error) {
+	unlock := lockUpload(id)
+	defer unlock()
+
+	var active time.Time
+	for _, name := range []string{uploadInfo(id), uploadData(id)} {
+		var fi os.FileInfo
+		if fi, err = os.Stat(name); nil != err {
+			if !os.IsNotExist(err) {
+				return
+			}
+			continue
+		}
+		if fi.ModTime().After(active) {
+			active = fi.ModTime()
+		}
+	}
+	err = nil
+	if active.IsZero() || !active.Before(cutoff) {
+		return
+	}
+
+	log.Printf("Aborting resumable upload %s, idle since %s.\n", id, active.Format(time.RFC3339))
+	if err = removeUpload(id); nil != err {
+		return
+	}
+	aborted = true
+	return
+}
+
+// expire the upload once idle for the configured expiry after it was last
+// active.
+func (u *ResumableUpload) expire(active time.Time) {
+	if expiry := config.Get.Uploads.Expiry; 0 < expiry {
+		u.Expires = active.Add(expiry).UTC()
+	}
+}
+
+// complete the upload by uploading the contents as a file.
+func (u *ResumableUpload) complete() (meta *FileMetadata, err error) {
+	var file *os.File
+	if file, err = os.Open(uploadData(u.ID)); nil != err {
+		return
+	}
+	defer file.Close()
+
+	meta = &FileMetadata{
+		Path:        u.Path,
+		ContentType: u.ContentType,
+		Uploader:    u.Uploader,
+		Size:        u.Length,
+	}
+	if err = File.Upload(meta, file); nil != err {
+		meta = nil
+		return
+	}
+	err = removeUpload(u.ID)
+	return
+}
+
+// loadUpload from the uploads folder if it belongs to the uploader.
+func loadUpload(id, uploader string) (u *ResumableUpload, err error) {
+	if !validUploadID(id) {
+		err = ErrUploadNotFound
+		return
+	}
+	var raw []byte
+	if raw, err = ioutil.ReadFile(uploadInfo(id)); nil != err {
+		if os.IsNotExist(err) {
+			err = ErrUploadNotFound
+		}
+		return
+	}
+	u = &ResumableUpload{}
+	if err = json.Unmarshal(raw, u); nil != err {
+		return
+	}
+	if uploader != u.Uploader {
+		u, err = nil, ErrUploadNotFound
+		return
+	}
+
+	var fi os.FileInfo
+	if fi, err = os.Stat(uploadData(id)); nil != err {
+		return
+	}
+	u.Offset = fi.Size()
+
+	// Expired uploads are as good as gone, even before being swept.
+	active := u.Created
+	if fi.ModTime().After(active) {
+		active = fi.ModTime()
+	}
+	u.expire(active)
+	if !u.Expires.IsZero() && time.Now().After(u.Expires) {
+		u, err = nil, ErrUploadNotFound
+	}
+	return
+}
+
+// removeUpload files. The description goes first, so an interruption never
+// leaves an upload without contents.
+func removeUpload(id string) (err error) {
+	if err = os.Remove(uploadInfo(id)); nil != err && !os.IsNotExist(err) {
+		return
+	}
+	if err = os.Remove(uploadData(id)); os.IsNotExist(err) {
+		err = nil
+	}
+	return
+}
+
+// validUploadID returns true for IDs created by this server, which keeps
+// client-provided IDs from reaching outside the uploads folder.
+func validUploadID(id string) bool {
+	raw, err := hex.DecodeString(id)
+	return nil == err && 16 == len(raw)
+}
+
+// uploadData file holding the contents received.
+func uploadData(id string) string {
+	return path.Join(config.Get.Uploads.Folder, id)
+}
+
+// uploadInfo file holding the upload description.
+func uploadInfo(id string) string {
+	return path.Join(config.Get.Uploads.Folder, id+".json")
+}
@@ -0,0 +1,124 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/halverneus/example/config"
+	"github.com/halverneus/example/database"
+)
+
+var (
+	// ErrLocked is returned when replacing or deleting a file under retention or
+	// legal hold.
+	ErrLocked = database.ErrLocked
+
+	// ErrRetentionShortened is returned when shortening or removing retention
+	// without being allowed to.
+	ErrRetentionShortened = database.ErrRetentionShortened
+
+	// ErrNotRetentionAdmin is returned when a user not listed as a retention
+	// admin places or releases a legal hold.
+	ErrNotRetentionAdmin = errors.New("user is not allowed to place or release legal holds")
+)
+
+// RetentionError is returned for retention settings that make no sense.
+type RetentionError struct {
+	Reason string
+}
+
+// Error message for the invalid retention.
+func (e *RetentionError) Error() string {
+	return "invalid retention: " + e.Reason
+}
+
+// SetRetention of a file version, keeping it from being replaced or deleted
+// until the time. An empty version ID is the current version. Retention still
+// in effect can only be extended, except by an admin in governance mode, who
+// may also remove it with an empty mode and time.
+func (fn FileNamespace) SetRetention(filePath, versionID, mode string, until time.Time, user string) (err error) {
+	var objectKey ObjectKey
+	if objectKey, err = ParseObjectKey(filePath); nil != err {
+		return
+	}
+	if "" == mode && !until.IsZero() {
+		return &RetentionError{Reason: "mode is required"}
+	}
+	if "" != mode {
+		if err = validRetention(mode, until.Sub(time.Now())); nil != err {
+			return
+		}
+	}
+	if "" == versionID {
+		var f *database.File
+		if f, err = database.GetMetadata(objectKey.String()); nil != err {
+			return
+		}
+		versionID = f.Version()
+	}
+	return database.SetRetention(objectKey.String(), versionID, mode, until.UTC(), isAdmin(user))
+}
+
+// SetLegalHold on a file version, keeping it from being replaced or deleted
+// until released. An empty version ID is the current version. Only admins may
+// place or release legal holds.
+func (fn FileNamespace) SetLegalHold(filePath, versionID string, hold bool, user string) (err error) {
+	if !isAdmin(user) {
+		return ErrNotRetentionAdmin
+	}
+	var objectKey ObjectKey
+	if objectKey, err = ParseObjectKey(filePath); nil != err {
+		return
+	}
+	if "" == versionID {
+		var f *database.File
+		if f, err = database.GetMetadata(objectKey.String()); nil != err {
+			return
+		}
+		versionID = f.Version()
+	}
+	return database.SetLegalHold(objectKey.String(), versionID, hold)
+}
+
+// isAdmin users may shorten or remove governance mode retention and place or
+// release legal holds.
+func isAdmin(user string) bool {
+	return contains(config.Get.Retention.Admins, user)
+}
+
+// defaultRetention of a new upload from the first rule matching its path.
+func defaultRetention(filePath string, now time.Time) (mode string, until time.Time) {
+	for _, rule := range config.Get.Retention.Rules {
+		if strings.HasPrefix(filePath, rule.Prefix) {
+			return rule.Mode, now.Add(rule.Period).UTC()
+		}
+	}
+	return
+}
+
+// validRetention mode for the period.
+func validRetention(mode string, period time.Duration) error {
+	switch mode {
+	case database.GovernanceMode, database.ComplianceMode:
+	default:
+		return &RetentionError{Reason: fmt.Sprintf(
+			"mode must be %q or %q", database.GovernanceMode, database.ComplianceMode,
+		)}
+	}
+	if 0 >= period {
+		return &RetentionError{Reason: "retention must end in the future"}
+	}
+	return nil
+}
+
+// validRetentionRules in the configuration.
+func validRetentionRules() error {
+	for _, rule := range config.Get.Retention.Rules {
+		if err := validRetention(rule.Mode, rule.Period); nil != err {
+			return fmt.Errorf("retention rule for prefix %q: %v", rule.Prefix, err)
+		}
+	}
+	return nil
+}
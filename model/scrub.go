@@ -0,0 +1,90 @@
+package model
+
+import (
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/halverneus/example/database"
+	"github.com/halverneus/example/storage"
+)
+
+const (
+	// scrubInterval between checking every copy of mirrored contents.
+	scrubInterval = 24 * time.Hour
+)
+
+var (
+	// Scrubbing namespace contains all scrubbing-specific functions.
+	Scrubbing ScrubbingNamespace
+)
+
+// ScrubbingNamespace is used to organize the controller/model functions.
+type ScrubbingNamespace struct{}
+
+// Scrub every copy of contents kept by backends holding several, such as
+// mirrored folders. Copies are healthy when they match the size and SHA-256
+// recorded for the file, and missing or corrupt copies are rewritten from a
+// healthy one. Contents recorded without either only need to be readable.
+// Returns the number of copies rewritten, carrying on past contents without a
+// healthy copy, which are logged. Contents missing altogether are left to the
+// storage check.
+func (sn ScrubbingNamespace) Scrub() (repaired int) {
+	// Group every file with contents by where the contents are stored.
+	files := append(database.ListFiles(), database.ListHistory()...)
+	for _, item := range database.ListTrash() {
+		files = append(files, item.File)
+	}
+	byKey := map[string]*database.File{}
+	for _, f := range files {
+		if !f.DeleteMarker {
+			byKey[f.StorageKey()] = f
+		}
+	}
+	keys := make([]string, 0, len(byKey))
+	for key := range byKey {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		count, err := scrubKey(key, byKey[key])
+		if nil != err && storage.ErrNotFound != err {
+			log.Printf("Received error while scrubbing %s: %v\n", key, err)
+		}
+		repaired += count
+	}
+	return
+}
+
+// scrub every copy of mirrored contents and report what was rewritten.
+func (sn ScrubbingNamespace) scrub() {
+	if repaired := sn.Scrub(); 0 < repaired {
+		log.Printf("Repaired %d copies of mirrored objects.\n", repaired)
+	}
+}
+
+// scrubKey checks every copy of the contents of a file against its size and
+// SHA-256.
+func scrubKey(key string, f *database.File) (repaired int, err error) {
+	unlock := lockKey(key)
+	defer unlock()
+
+	// Contents may have been released meanwhile.
+	if 0 == database.References(key) {
+		return
+	}
+	scrubber, ok := File.tier(f.Tier).(storage.Scrubber)
+	if !ok {
+		return
+	}
+	return scrubber.Scrub(key, func(obj storage.Object) (bool, error) {
+		size, sha256Sum, err := hashContents(f, obj)
+		if nil != err {
+			return false, err
+		}
+		sized := 0 != f.Size || "" != f.SHA256
+		return (!sized || size == f.Size) && ("" == f.SHA256 || strings.EqualFold(sha256Sum, f.SHA256)), nil
+	})
+}
@@ -0,0 +1,79 @@
+package model
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/halverneus/example/config"
+	"github.com/halverneus/example/database"
+	"github.com/halverneus/example/storage"
+)
+
+// TestScrub mirrored copies against the checksums in the database.
+func TestScrub(t *testing.T) {
+	// Load the database and mirror contents across two backends in memory.
+	// Delete everything on completion.
+	if err := database.Load("json", "scrub.db"); nil != err {
+		t.Fatalf("While loading database: %v\n", err)
+	}
+	defer os.Remove("scrub.db")
+	defer os.Remove("scrub.db.journal")
+
+	config.Get.Storage.Driver = "memory"
+	defer func() { config.Get.Storage.Driver = "filesystem" }()
+	if err := Load(); nil != err {
+		t.Fatalf("While loading model: %v\n", err)
+	}
+	first, second := storage.NewMemory(), storage.NewMemory()
+	File.Storage = storage.NewMirror(first, second)
+
+	// put contents at the key on a single copy.
+	put := func(backend storage.Backend, key, contents string) {
+		upload, err := backend.Put(strings.NewReader(contents))
+		if nil == err {
+			err = upload.Commit(key)
+		}
+		if nil != err {
+			t.Fatalf("Failed to put %s with: %v\n", key, err)
+		}
+	}
+
+	// read the contents at the key on a single copy.
+	read := func(backend storage.Backend, key string) string {
+		obj, err := backend.Get(key)
+		if nil != err {
+			return err.Error()
+		}
+		defer obj.Close()
+		raw, _ := ioutil.ReadAll(obj)
+		return string(raw)
+	}
+
+	if err := File.Upload(&FileMetadata{Path: "/a.txt", Uploader: "john"}, strings.NewReader("alpha")); nil != err {
+		t.Fatalf("Failed to upload /a.txt with: %v\n", err)
+	}
+	key := database.ListFiles()[0].StorageKey()
+
+	// Healthy copies are left alone.
+	if repaired := Scrubbing.Scrub(); 0 != repaired {
+		t.Errorf("Expected nothing to repair and got %d\n", repaired)
+	}
+
+	// A corrupt first copy is rewritten from the second, and the second from
+	// the first once missing.
+	put(first, key, "gamma")
+	if repaired := Scrubbing.Scrub(); 1 != repaired {
+		t.Errorf("Expected 1 copy to be repaired and got %d\n", repaired)
+	}
+	second.Delete(key)
+	if repaired := Scrubbing.Scrub(); 1 != repaired {
+		t.Errorf("Expected 1 copy to be repaired and got %d\n", repaired)
+	}
+	for i, backend := range []storage.Backend{first, second} {
+		if got := read(backend, key); "alpha" != got {
+			t.Errorf("Copy %d not repaired. Holds %q\n", i, got)
+		}
+	}
+}
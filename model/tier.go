@@ -0,0 +1,180 @@
+package model
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/halverneus/example/config"
+	"github.com/halverneus/example/database"
+	"github.com/halverneus/example/storage"
+)
+
+const (
+	// HotTier is the fast storage new uploads go to.
+	HotTier = "hot"
+	// ColdTier is the storage contents move to once left alone long enough.
+	ColdTier = "cold"
+
+	// tieringInterval between looking for contents to move between tiers.
+	tieringInterval = time.Hour
+)
+
+var (
+	// Tiering namespace contains all tiering-specific functions.
+	Tiering TieringNamespace
+)
+
+// TieringNamespace is used to organize the controller/model functions.
+type TieringNamespace struct{}
+
+// Move contents between tiers. Contents go cold once every file sharing them
+// has gone without a download for longer than the rule matching its path, and
+// back to the hot tier once any of them is downloaded again. Downloads keep
+// working while contents move. Returns the number of storage keys moved, which
+// stops at the first failure.
+func (tn TieringNamespace) Move() (moved int, err error) {
+	if nil == File.Cold {
+		return
+	}
+
+	// Keep access times recorded by downloads in case of a crash.
+	if err = database.SaveAccessed(); nil != err {
+		return
+	}
+
+	// Group every file with contents by where the contents are stored.
+	files := append(database.ListFiles(), database.ListHistory()...)
+	for _, item := range database.ListTrash() {
+		files = append(files, item.File)
+	}
+	byKey := map[string][]*database.File{}
+	for _, f := range files {
+		byKey[f.StorageKey()] = append(byKey[f.StorageKey()], f)
+	}
+	keys := make([]string, 0, len(byKey))
+	for key := range byKey {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	now := time.Now()
+	for _, key := range keys {
+		tier, cold := byKey[key][0].Tier, idle(byKey[key], now)
+		var ok bool
+		switch {
+		case cold && database.ColdTier != tier:
+			ok, err = moveKey(key, File.Storage, File.Cold, database.ColdTier)
+		case !cold && database.ColdTier == tier:
+			ok, err = moveKey(key, File.Cold, File.Storage, "")
+		}
+		if nil != err {
+			return
+		}
+		if ok {
+			moved++
+		}
+	}
+	return
+}
+
+// move contents between tiers and report what moved.
+func (tn TieringNamespace) move() {
+	moved, err := tn.Move()
+	if 0 < moved {
+		log.Printf("Moved %d objects between tiers.\n", moved)
+	}
+	if nil != err {
+		log.Printf("Received error while moving objects between tiers: %v\n", err)
+	}
+}
+
+// idle files have all gone without a download for longer than the rule matching
+// their path. Files last accessed at an unknown time are never idle.
+func idle(files []*database.File, now time.Time) bool {
+	for _, f := range files {
+		after, found := coldAfter(f.Path)
+		if !found || f.LastAccess().IsZero() || now.Sub(f.LastAccess()) < after {
+			return false
+		}
+	}
+	return true
+}
+
+// coldAfter returns the idle time before contents at the path go cold, from the
+// first matching rule.
+func coldAfter(filePath string) (after time.Duration, found bool) {
+	for _, rule := range config.Get.Tiering.Rules {
+		if strings.HasPrefix(filePath, rule.Prefix) {
+			return rule.ColdAfter, true
+		}
+	}
+	return
+}
+
+// moveKey from one backend to another, recording the new tier once copied and
+// only then deleting the original, so downloads never miss the contents.
+func moveKey(key string, from, to storage.Backend, tier string) (moved bool, err error) {
+	unlock := lockKey(key)
+	defer unlock()
+
+	// Contents may have been released while waiting.
+	if 0 == database.References(key) {
+		return
+	}
+	if err = copyObject(from, key, to, key); nil != err {
+		return
+	}
+	var count int
+	if count, err = database.SetTier(key, tier); nil != err || 0 == count {
+		deleteObject(to, key)
+		return
+	}
+	deleteObject(from, key)
+	moved = true
+	return
+}
+
+// tier backend by name, which is the hot tier unless cold.
+func (fn FileNamespace) tier(tier string) storage.Backend {
+	if database.ColdTier == tier && nil != fn.Cold {
+		return fn.Cold
+	}
+	return fn.Storage
+}
+
+// tiers backends in use, hot first.
+func (fn FileNamespace) tiers() []storage.Backend {
+	if nil == fn.Cold {
+		return []storage.Backend{fn.Storage}
+	}
+	return []storage.Backend{fn.Storage, fn.Cold}
+}
+
+// otherTier than the one given.
+func otherTier(tier string) string {
+	if database.ColdTier == tier {
+		return ""
+	}
+	return database.ColdTier
+}
+
+// tierName reported for a tier recorded in the database.
+func tierName(tier string) string {
+	if database.ColdTier == tier {
+		return ColdTier
+	}
+	return HotTier
+}
+
+// validTiering rules, which need a positive idle time.
+func validTiering() error {
+	for _, rule := range config.Get.Tiering.Rules {
+		if 0 >= rule.ColdAfter {
+			return fmt.Errorf("tiering rule for prefix %q needs a positive cold_after", rule.Prefix)
+		}
+	}
+	return nil
+}
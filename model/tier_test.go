@@ -0,0 +1,85 @@
+package model
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/halverneus/example/config"
+	"github.com/halverneus/example/database"
+	"gopkg.in/yaml.v2"
+)
+
+// TestTieringLegacy files, recorded before modification times, which go cold
+// by their creation time and stay hot when it is unknown.
+func TestTieringLegacy(t *testing.T) {
+	// Keep contents in memory, moving reports idle for 30 days to a cold
+	// folder. Delete everything on completion.
+	const coldFolder = "tiering-cold"
+	config.Get.Storage.Driver = "memory"
+	config.Get.Tiering.ColdFolder = coldFolder
+	defer func() {
+		config.Get.Storage.Driver = "filesystem"
+		config.Get.Tiering.ColdFolder = ""
+		config.Get.Tiering.Rules = nil
+		os.RemoveAll(coldFolder)
+		matches, _ := filepath.Glob("tiering.db*")
+		for _, match := range matches {
+			os.Remove(match)
+		}
+	}()
+	if err := yaml.Unmarshal([]byte(`tiering: {rules: [{prefix: /reports/, cold_after: 720h}]}`), &config.Get); nil != err {
+		t.Fatalf("Failed to parse tiering rules with: %v\n", err)
+	}
+
+	// Files created today, two months ago and at an unreadable time, as written
+	// by the first version.
+	const format = "Jan 2, 2006 3:04 PM"
+	file := func(filePath, created string) string {
+		return fmt.Sprintf(`{"path": %q, "content-type": "text/plain", "uploader": "john", "created": %q}`, filePath, created)
+	}
+	legacy := `{"users": [], "files": [` +
+		file("/reports/new.txt", time.Now().Format(format)) + `,` +
+		file("/reports/old.txt", time.Now().AddDate(0, -2, 0).Format(format)) + `,` +
+		file("/reports/unknown.txt", "someday") + `]}`
+	if err := ioutil.WriteFile("tiering.db", []byte(legacy), 0666); nil != err {
+		t.Fatalf("Failed to write database with: %v\n", err)
+	}
+	if err := database.Load("json", "tiering.db"); nil != err {
+		t.Fatalf("While loading database: %v\n", err)
+	}
+	if err := Load(); nil != err {
+		t.Fatalf("While loading model: %v\n", err)
+	}
+	for _, key := range []string{"/reports/new.txt", "/reports/old.txt", "/reports/unknown.txt"} {
+		upload, err := File.Storage.Put(strings.NewReader("contents"))
+		if nil == err {
+			err = upload.Commit(key)
+		}
+		if nil != err {
+			t.Fatalf("Failed to put %s with: %v\n", key, err)
+		}
+	}
+
+	// Only the file created long ago goes cold.
+	if moved, err := Tiering.Move(); nil != err || 1 != moved {
+		t.Errorf("Expected 1 file to move and got %d (%v)\n", moved, err)
+	}
+	for filePath, expected := range map[string]string{
+		"/reports/new.txt":     "",
+		"/reports/old.txt":     database.ColdTier,
+		"/reports/unknown.txt": "",
+	} {
+		f, err := database.GetMetadata(filePath)
+		if nil != err {
+			t.Fatalf("Failed to find %s with: %v\n", filePath, err)
+		}
+		if expected != f.Tier {
+			t.Errorf("Expected %s in tier %q and got %q\n", filePath, expected, f.Tier)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package model
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"time"
+
+	"github.com/halverneus/example/config"
+	"github.com/halverneus/example/database"
+)
+
+var (
+	// Trash namespace contains all trash-specific functions.
+	Trash TrashNamespace
+
+	// ErrTrashNotFound is returned for items that aren't in the trash.
+	ErrTrashNotFound = database.ErrTrashNotFound
+
+	// ErrRestoreConflict is returned when restoring an item to a path that has
+	// since been uploaded again.
+	ErrRestoreConflict = database.ErrRestoreConflict
+)
+
+// TrashNamespace is used to organize the controller/model functions.
+type TrashNamespace struct{}
+
+// TrashItem is a deleted file kept in the trash.
+type TrashItem struct {
+	ID        string
+	DeletedBy string
+	Deleted   time.Time
+	File      *FileMetadata
+}
+
+// List every item in the trash, oldest first.
+func (tn TrashNamespace) List() []*TrashItem {
+	items := database.ListTrash()
+	list := make([]*TrashItem, len(items))
+	for i, item := range items {
+		list[i] = &TrashItem{
+			ID:        item.ID,
+			DeletedBy: item.DeletedBy,
+			Deleted:   item.Deleted,
+			File:      newFileMetadata(item.File),
+		}
+	}
+	return list
+}
+
+// Restore an item to the path it was deleted from. Fails when a file has since
+// been uploaded to the path.
+func (tn TrashNamespace) Restore(id string) (meta *FileMetadata, err error) {
+	var f *database.File
+	if f, err = database.RestoreTrash(id); nil != err {
+		return
+	}
+	meta = newFileMetadata(f)
+	return
+}
+
+// Purge an item for good. The deletion worker must be running.
+func (tn TrashNamespace) Purge(id string) error {
+	return database.PurgeTrash(id)
+}
+
+// Empty the trash for good, returning the number of items purged. The deletion
+// worker must be running.
+func (tn TrashNamespace) Empty() (int, error) {
+	return database.EmptyTrash()
+}
+
+// Expire items kept for longer than the retention, returning the number of
+// items purged. Nothing expires without a retention.
+func (tn TrashNamespace) Expire() (count int, err error) {
+	if 0 >= config.Get.Trash.Retention {
+		return
+	}
+	return database.PurgeTrashDeletedBefore(time.Now().Add(-config.Get.Trash.Retention))
+}
+
+// trashEnabled for files deleted from the path. Versioned files keep a delete
+// marker instead.
+func trashEnabled(filePath string) bool {
+	return config.Get.Trash.Enabled && !versioningEnabled(filePath)
+}
+
+// moveToTrash the file at the path, recording who deleted it.
+func moveToTrash(filePath, deletedBy string) (err error) {
+	raw := make([]byte, 16)
+	if _, err = rand.Read(raw); nil != err {
+		return
+	}
+	item := &database.TrashItem{
+		ID:        hex.EncodeToString(raw),
+		DeletedBy: deletedBy,
+		Deleted:   time.Now().UTC(),
+	}
+	return database.TrashFile(filePath, item)
+}
@@ -0,0 +1,117 @@
+package model
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"strings"
+	"time"
+
+	"github.com/halverneus/example/config"
+	"github.com/halverneus/example/database"
+)
+
+// Versions of a file, newest first. The first is the latest version, which is
+// either the current file or a delete marker left by deleting it.
+func (fn FileNamespace) Versions(filePath string) (versions []*FileMetadata, err error) {
+	var objectKey ObjectKey
+	if objectKey, err = ParseObjectKey(filePath); nil != err {
+		return
+	}
+	var files []*database.File
+	if files, err = database.ListVersions(objectKey.String()); nil != err {
+		return
+	}
+	versions = make([]*FileMetadata, len(files))
+	for i, f := range files {
+		versions[i] = newFileMetadata(f)
+	}
+	return
+}
+
+// DeleteVersion of a file for good. Deleting the current version makes the
+// previous one current, and deleting a delete marker that is the latest
+// version brings the file back.
+func (fn FileNamespace) DeleteVersion(filePath, versionID string) error {
+	objectKey, err := ParseObjectKey(filePath)
+	if nil != err {
+		return err
+	}
+	return database.RemoveVersion(objectKey.String(), versionID)
+}
+
+// Restore a past version of a file as the current version. The contents are
+// shared with the past version rather than copied.
+func (fn FileNamespace) Restore(filePath, versionID, uploader string) (meta *FileMetadata, err error) {
+	var objectKey ObjectKey
+	if objectKey, err = ParseObjectKey(filePath); nil != err {
+		return
+	}
+
+	// Hold the version like a download so its contents stay put.
+	var past *database.File
+	if past, err = database.GetVersionForDownload(objectKey.String(), versionID); nil != err {
+		return
+	}
+	defer past.Done()
+
+	now := time.Now()
+	f := &database.File{
+		Path:        past.Path,
+		ContentType: past.ContentType,
+		Uploader:    uploader,
+		Created:     now.Format("Jan 2, 2006 3:04 PM"),
+		Modified:    now.UTC(),
+		Size:        past.Size,
+		SHA256:      past.SHA256,
+		MD5:         past.MD5,
+		Encoding:    past.Encoding,
+		Key:         past.Key,
+		WrappedKey:  past.WrappedKey,
+		KeyID:       past.KeyID,
+		Tier:        database.TierOf(past),
+	}
+	f.RetentionMode, f.RetainUntil = defaultRetention(f.Path, now)
+	if versioningEnabled(f.Path) {
+		if f.VersionID, err = newVersionID(); nil != err {
+			return
+		}
+	}
+
+	unlock := lockKey(f.StorageKey())
+	defer unlock()
+	if err = addFileWithinQuota(f); nil != err {
+		return
+	}
+	meta = newFileMetadata(f)
+	return
+}
+
+// versioningEnabled for files under any of the configured prefixes.
+func versioningEnabled(filePath string) bool {
+	return anyMatch(config.Get.Versioning.Prefixes, func(prefix string) bool {
+		return strings.HasPrefix(filePath, prefix)
+	})
+}
+
+// newVersionID for a file.
+func newVersionID() (versionID string, err error) {
+	raw := make([]byte, 16)
+	if _, err = rand.Read(raw); nil != err {
+		return
+	}
+	versionID = hex.EncodeToString(raw)
+	return
+}
+
+// newDeleteMarker for a path, recording a deletion as its latest version.
+func newDeleteMarker(filePath string) (marker *database.File, err error) {
+	now := time.Now()
+	marker = &database.File{
+		Path:         filePath,
+		Created:      now.Format("Jan 2, 2006 3:04 PM"),
+		Modified:     now.UTC(),
+		DeleteMarker: true,
+	}
+	marker.VersionID, err = newVersionID()
+	return
+}
@@ -10,7 +10,10 @@ import (
 
 	"github.com/julienschmidt/httprouter"
 
+	"github.com/halverneus/example/api/backup"
 	"github.com/halverneus/example/api/file"
+	"github.com/halverneus/example/api/trash"
+	"github.com/halverneus/example/api/upload"
 	"github.com/halverneus/example/api/user"
 	"github.com/halverneus/example/config"
 	"github.com/halverneus/example/database"
@@ -30,17 +33,41 @@ func Run() (err error) {
 	router := httprouter.New()
 
 	// V1 of the API.
+	router.GET("/api/v1/backup", web.Wrap(authenticate.User(backup.GET)))
 	router.DELETE("/api/v1/file/*filepath", web.Wrap(authenticate.User(file.DELETE)))
 	router.GET("/api/v1/file/*filepath", web.Wrap(authenticate.User(file.GET)))
+	router.HEAD("/api/v1/file/*filepath", web.Wrap(authenticate.User(file.GET)))
+	router.POST("/api/v1/file/*filepath", web.Wrap(authenticate.User(file.POST)))
 	router.PUT("/api/v1/file/*filepath", web.Wrap(authenticate.User(file.PUT)))
+	router.OPTIONS("/api/v1/uploads", web.Wrap(upload.OPTIONS))
+	router.POST("/api/v1/uploads", web.Wrap(authenticate.User(upload.POST)))
+	router.DELETE("/api/v1/uploads/:id", web.Wrap(authenticate.User(upload.DELETE)))
+	router.HEAD("/api/v1/uploads/:id", web.Wrap(authenticate.User(upload.HEAD)))
+	router.PATCH("/api/v1/uploads/:id", web.Wrap(authenticate.User(upload.PATCH)))
+	router.DELETE("/api/v1/trash", web.Wrap(authenticate.User(trash.DELETE)))
+	router.GET("/api/v1/trash", web.Wrap(authenticate.User(trash.GET)))
+	router.DELETE("/api/v1/trash/:id", web.Wrap(authenticate.User(trash.DELETE)))
+	router.POST("/api/v1/trash/:id", web.Wrap(authenticate.User(trash.POST)))
 	router.DELETE("/api/v1/user", web.Wrap(authenticate.User(user.DELETE)))
 	router.POST("/api/v1/user", web.Wrap(authenticate.User(user.POST)))
 	router.PUT("/api/v1/user", web.Wrap(authenticate.User(user.PUT)))
 
 	// Latest version of the API.
+	router.GET("/api/latest/backup", web.Wrap(authenticate.User(backup.GET)))
 	router.DELETE("/api/latest/file/*filepath", web.Wrap(authenticate.User(file.DELETE)))
 	router.GET("/api/latest/file/*filepath", web.Wrap(authenticate.User(file.GET)))
+	router.HEAD("/api/latest/file/*filepath", web.Wrap(authenticate.User(file.GET)))
+	router.POST("/api/latest/file/*filepath", web.Wrap(authenticate.User(file.POST)))
 	router.PUT("/api/latest/file/*filepath", web.Wrap(authenticate.User(file.PUT)))
+	router.OPTIONS("/api/latest/uploads", web.Wrap(upload.OPTIONS))
+	router.POST("/api/latest/uploads", web.Wrap(authenticate.User(upload.POST)))
+	router.DELETE("/api/latest/uploads/:id", web.Wrap(authenticate.User(upload.DELETE)))
+	router.HEAD("/api/latest/uploads/:id", web.Wrap(authenticate.User(upload.HEAD)))
+	router.PATCH("/api/latest/uploads/:id", web.Wrap(authenticate.User(upload.PATCH)))
+	router.DELETE("/api/latest/trash", web.Wrap(authenticate.User(trash.DELETE)))
+	router.GET("/api/latest/trash", web.Wrap(authenticate.User(trash.GET)))
+	router.DELETE("/api/latest/trash/:id", web.Wrap(authenticate.User(trash.DELETE)))
+	router.POST("/api/latest/trash/:id", web.Wrap(authenticate.User(trash.POST)))
 	router.DELETE("/api/latest/user", web.Wrap(authenticate.User(user.DELETE)))
 	router.POST("/api/latest/user", web.Wrap(authenticate.User(user.POST)))
 	router.PUT("/api/latest/user", web.Wrap(authenticate.User(user.PUT)))
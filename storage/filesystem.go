@@ -0,0 +1,289 @@
+package storage
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const (
+	// stagingFolder inside the storage folder holds uploads until committed. It
+	// lives on the same file system so that committing is an atomic rename.
+	stagingFolder = ".staging"
+)
+
+// Filesystem backend mirrors object keys onto a folder on the local disk.
+type Filesystem struct {
+	root string
+}
+
+// NewFilesystem backend rooted at the provided folder.
+func NewFilesystem(folder string) *Filesystem {
+	return &Filesystem{root: path.Clean(folder)}
+}
+
+// String names the storage folder.
+func (fs *Filesystem) String() string {
+	return fs.root
+}
+
+// fullpath of the object on disk.
+func (fs *Filesystem) fullpath(key string) string {
+	return path.Join(fs.root, key)
+}
+
+// Put a file from the client into the staging folder. The file is fsynced so
+// that a commit never exposes partially written contents.
+func (fs *Filesystem) Put(r io.Reader) (upload Upload, err error) {
+	// Create the staging folder.
+	staging := path.Join(fs.root, stagingFolder)
+	if err = os.MkdirAll(staging, 0755); nil != err {
+		return
+	}
+
+	// Open a uniquely named file for writing.
+	var file *os.File
+	if file, err = ioutil.TempFile(staging, "upload-"); nil != err {
+		return
+	}
+	defer func() {
+		if nil != err {
+			os.Remove(file.Name())
+		}
+	}()
+	defer file.Close()
+
+	// Write to file and flush to disk.
+	if _, err = io.Copy(file, r); nil != err {
+		return
+	}
+	if err = file.Sync(); nil != err {
+		return
+	}
+	if err = file.Chmod(0755); nil != err {
+		return
+	}
+
+	upload = &filesystemUpload{fs: fs, staged: file.Name()}
+	return
+}
+
+// filesystemUpload is a fully written file waiting in the staging folder.
+type filesystemUpload struct {
+	fs     *Filesystem
+	staged string
+}
+
+// Commit by renaming the staged file over the live path. Readers with the old
+// file open continue to see the old contents.
+func (u *filesystemUpload) Commit(key string) (err error) {
+	if u.fs.isStaging(key) {
+		u.Abort()
+		err = fmt.Errorf("key %s is reserved for staging uploads", key)
+		return
+	}
+	fullpath := u.fs.fullpath(key)
+	dir := path.Dir(fullpath)
+
+	// Create all parent directories.
+	if err = os.MkdirAll(dir, 0755); nil != err {
+		u.Abort()
+		return
+	}
+	if err = os.Rename(u.staged, fullpath); nil != err {
+		u.Abort()
+		return
+	}
+
+	// Flush the directory entry so the rename survives a crash.
+	return syncDir(dir)
+}
+
+// Abort by removing the staged file.
+func (u *filesystemUpload) Abort() error {
+	return os.Remove(u.staged)
+}
+
+// Get a file from storage for the client.
+func (fs *Filesystem) Get(key string) (obj Object, err error) {
+	// Open file for reading.
+	var file *os.File
+	if file, err = os.OpenFile(fs.fullpath(key), os.O_RDONLY, 0755); nil != err {
+		if os.IsNotExist(err) {
+			err = ErrNotFound
+		}
+		return
+	}
+	obj = file
+	return
+}
+
+// Stat a file in storage.
+func (fs *Filesystem) Stat(key string) (info *Info, err error) {
+	var fi os.FileInfo
+	if fi, err = os.Stat(fs.fullpath(key)); nil != err {
+		if os.IsNotExist(err) {
+			err = ErrNotFound
+		}
+		return
+	}
+	info = &Info{Key: key, Size: fi.Size(), ModTime: fi.ModTime()}
+	return
+}
+
+// Delete file from storage.
+func (fs *Filesystem) Delete(key string) (err error) {
+	fullpath := fs.fullpath(key)
+
+	// Remove file.
+	if err = os.Remove(fullpath); nil != err {
+		if os.IsNotExist(err) {
+			err = ErrNotFound
+		}
+		return
+	}
+
+	// Remove empty parent directories, stopping at the storage folder.
+	dir := path.Dir(fullpath)
+	for dir != fs.root && isDirEmpty(dir) {
+		if err = os.Remove(dir); nil != err {
+			return nil // Not error. Folder removal complete.
+		}
+		dir = path.Dir(dir) // Move up one directory.
+	}
+	return
+}
+
+// List all files in storage with keys beginning with the prefix.
+func (fs *Filesystem) List(prefix string, fn func(*Info) error) (err error) {
+	err = filepath.Walk(fs.root, func(p string, fi os.FileInfo, errX error) error {
+		if nil != errX {
+			// Missing storage folder simply means nothing has been stored yet.
+			if p == fs.root && os.IsNotExist(errX) {
+				return nil
+			}
+			return errX
+		}
+		if fi.IsDir() {
+			if p == path.Join(fs.root, stagingFolder) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		// Convert the path on disk back into an object key.
+		rel, errX := filepath.Rel(fs.root, p)
+		if nil != errX {
+			return errX
+		}
+		key := "/" + filepath.ToSlash(rel)
+		if !strings.HasPrefix(key, prefix) {
+			return nil
+		}
+		return fn(&Info{Key: key, Size: fi.Size(), ModTime: fi.ModTime()})
+	})
+	return
+}
+
+// EmptyFolders in the storage folder holding no files at any depth, deepest
+// first.
+func (fs *Filesystem) EmptyFolders() (folders []string, err error) {
+	dirs := []string{}
+	used := map[string]bool{}
+	err = filepath.Walk(fs.root, func(p string, fi os.FileInfo, errX error) error {
+		if nil != errX {
+			if p == fs.root && os.IsNotExist(errX) {
+				return nil
+			}
+			return errX
+		}
+		if !fi.IsDir() {
+			// Mark every folder above the file as used.
+			for dir := filepath.Dir(p); !used[dir]; dir = filepath.Dir(dir) {
+				used[dir] = true
+				if dir == fs.root || "." == dir || "/" == dir {
+					break
+				}
+			}
+			return nil
+		}
+		if p == path.Join(fs.root, stagingFolder) {
+			return filepath.SkipDir
+		}
+		if p != fs.root {
+			dirs = append(dirs, p)
+		}
+		return nil
+	})
+	if nil != err {
+		return
+	}
+
+	// Reverse order puts subfolders ahead of their parents.
+	sort.Sort(sort.Reverse(sort.StringSlice(dirs)))
+	for _, dir := range dirs {
+		if used[dir] {
+			continue
+		}
+		rel, errX := filepath.Rel(fs.root, dir)
+		if nil != errX {
+			err = errX
+			return
+		}
+		folders = append(folders, "/"+filepath.ToSlash(rel))
+	}
+	return
+}
+
+// Prune an empty folder. Folders that aren't empty are left alone.
+func (fs *Filesystem) Prune(folder string) error {
+	fullpath := fs.fullpath(folder)
+	if fullpath == fs.root {
+		return nil
+	}
+	if err := os.Remove(fullpath); nil != err && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// Clean the staging folder of uploads that were never committed or aborted,
+// which happens when the process exits mid-upload. Only call before serving.
+func (fs *Filesystem) Clean() error {
+	return os.RemoveAll(path.Join(fs.root, stagingFolder))
+}
+
+// isStaging returns true when the key falls inside the staging folder.
+func (fs *Filesystem) isStaging(key string) bool {
+	return stagingFolder == topFolder(key)
+}
+
+// syncDir flushes a directory's entries to disk.
+func syncDir(dirPath string) (err error) {
+	var dir *os.File
+	if dir, err = os.Open(dirPath); nil != err {
+		return
+	}
+	defer dir.Close()
+	return dir.Sync()
+}
+
+// isDirEmpty returns true when a provided directory is empty.
+func isDirEmpty(dirPath string) bool {
+	// Open directory for evaluation.
+	dir, err := os.Open(dirPath)
+	if nil != err {
+		return false
+	}
+	defer dir.Close()
+
+	// Request to read one FileInfo from directory. If none found then directory
+	// is empty.
+	_, err = dir.Readdir(1)
+	return io.EOF == err
+}
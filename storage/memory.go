@@ -0,0 +1,149 @@
+package storage
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Memory backend keeps all objects in memory. Contents are lost on exit, which
+// makes it useful for testing and throwaway servers.
+type Memory struct {
+	mtx     sync.RWMutex
+	objects map[string]*memoryObject
+}
+
+// memoryObject is a single stored object.
+type memoryObject struct {
+	data    []byte
+	modTime time.Time
+}
+
+// NewMemory backend with no objects.
+func NewMemory() *Memory {
+	return &Memory{objects: map[string]*memoryObject{}}
+}
+
+// String names the backend.
+func (m *Memory) String() string {
+	return fmt.Sprintf("memory (%p)", m)
+}
+
+// Put the contents of the reader in memory. Read everything before committing
+// so slow clients don't block others.
+func (m *Memory) Put(r io.Reader) (upload Upload, err error) {
+	var data []byte
+	if data, err = ioutil.ReadAll(r); nil != err {
+		return
+	}
+	upload = &memoryUpload{m: m, data: data}
+	return
+}
+
+// memoryUpload holds contents until committed.
+type memoryUpload struct {
+	m    *Memory
+	data []byte
+}
+
+// Commit the contents into the backend.
+func (u *memoryUpload) Commit(key string) (err error) {
+	u.m.mtx.Lock()
+	defer u.m.mtx.Unlock()
+	u.m.objects[key] = &memoryObject{data: u.data, modTime: time.Now()}
+	return
+}
+
+// Abort by dropping the contents.
+func (u *memoryUpload) Abort() (err error) {
+	u.data = nil
+	return
+}
+
+// memoryReader reads a stored object.
+type memoryReader struct {
+	*bytes.Reader
+}
+
+// Close does nothing.
+func (mr memoryReader) Close() error {
+	return nil
+}
+
+// Get a reader for the object.
+func (m *Memory) Get(key string) (o Object, err error) {
+	m.mtx.RLock()
+	defer m.mtx.RUnlock()
+
+	obj, found := m.objects[key]
+	if !found {
+		err = ErrNotFound
+		return
+	}
+
+	// Stored slices are never modified, only replaced, so sharing is safe.
+	o = memoryReader{bytes.NewReader(obj.data)}
+	return
+}
+
+// Stat the object.
+func (m *Memory) Stat(key string) (info *Info, err error) {
+	m.mtx.RLock()
+	defer m.mtx.RUnlock()
+
+	obj, found := m.objects[key]
+	if !found {
+		err = ErrNotFound
+		return
+	}
+	info = &Info{Key: key, Size: int64(len(obj.data)), ModTime: obj.modTime}
+	return
+}
+
+// Delete the object.
+func (m *Memory) Delete(key string) (err error) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	if _, found := m.objects[key]; !found {
+		err = ErrNotFound
+		return
+	}
+	delete(m.objects, key)
+	return
+}
+
+// List all objects with keys beginning with the prefix in key order.
+func (m *Memory) List(prefix string, fn func(*Info) error) (err error) {
+	// Collect matches while locked, then call out without holding the lock.
+	m.mtx.RLock()
+	infos := []*Info{}
+	for key, obj := range m.objects {
+		if strings.HasPrefix(key, prefix) {
+			infos = append(infos, &Info{
+				Key:     key,
+				Size:    int64(len(obj.data)),
+				ModTime: obj.modTime,
+			})
+		}
+	}
+	m.mtx.RUnlock()
+
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Key < infos[j].Key })
+	for _, info := range infos {
+		if err = fn(info); nil != err {
+			return
+		}
+	}
+	return
+}
+
+// Clean does nothing, since uncommitted uploads never outlive the process.
+func (m *Memory) Clean() error {
+	return nil
+}
@@ -0,0 +1,378 @@
+package storage
+
+import (
+	"fmt"
+	"hash/fnv"
+	"io"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Mirror backend writes every object to all of its replicas and serves reads
+// from the first copy found. Scrubbing checks every copy and rewrites missing
+// or corrupt ones from a healthy one.
+type Mirror struct {
+	replicas []Backend
+
+	// locks keep scrubs from racing commits and deletes of the same key.
+	locks [64]sync.RWMutex
+}
+
+// NewMirror backend over the replicas, which are read in the order given.
+func NewMirror(replicas ...Backend) *Mirror {
+	return &Mirror{replicas: replicas}
+}
+
+// lock for the key.
+func (m *Mirror) lock(key string) *sync.RWMutex {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return &m.locks[h.Sum32()%uint32(len(m.locks))]
+}
+
+// Put the contents to every replica at once. Replicas that fail are left out
+// of the upload, which only fails when every replica does.
+func (m *Mirror) Put(r io.Reader) (upload Upload, err error) {
+	type result struct {
+		upload Upload
+		err    error
+	}
+	results := make([]result, len(m.replicas))
+	pipes := make([]*io.PipeWriter, len(m.replicas))
+	writers := []io.Writer{}
+	wg := sync.WaitGroup{}
+
+	for i, replica := range m.replicas {
+		pr, pw := io.Pipe()
+		pipes[i] = pw
+		writers = append(writers, &failSafeWriter{w: pw})
+
+		wg.Add(1)
+		go func(i int, replica Backend) {
+			defer wg.Done()
+			results[i].upload, results[i].err = replica.Put(pr)
+			pr.CloseWithError(results[i].err) // Stop receiving on failure.
+		}(i, replica)
+	}
+
+	_, err = io.Copy(io.MultiWriter(writers...), r)
+	for _, pw := range pipes {
+		pw.CloseWithError(err)
+	}
+	wg.Wait()
+
+	u := &mirrorUpload{m: m, uploads: make([]Upload, len(m.replicas))}
+	for i, res := range results {
+		if nil == res.err {
+			u.uploads[i] = res.upload
+			continue
+		}
+		if nil == err {
+			log.Printf("Received error while writing to %v: %v\n", m.replicas[i], res.err)
+		}
+	}
+
+	// Give up on a failed read from the client or when nothing was staged.
+	if nil == err && !u.staged() {
+		err = fmt.Errorf("unable to write to any replica: %v", results[0].err)
+	}
+	if nil != err {
+		u.Abort()
+		return
+	}
+	upload = u
+	return
+}
+
+// failSafeWriter stops writing to a replica that failed and pretends to succeed
+// so that the remaining replicas still receive everything.
+type failSafeWriter struct {
+	w      io.Writer
+	failed bool
+}
+
+// Write unless failed.
+func (fw *failSafeWriter) Write(p []byte) (int, error) {
+	if !fw.failed {
+		if _, err := fw.w.Write(p); nil != err {
+			fw.failed = true
+		}
+	}
+	return len(p), nil
+}
+
+// mirrorUpload staged on the replicas that accepted it.
+type mirrorUpload struct {
+	m       *Mirror
+	uploads []Upload
+}
+
+// staged returns true when any replica holds the upload.
+func (u *mirrorUpload) staged() bool {
+	for _, upload := range u.uploads {
+		if nil != upload {
+			return true
+		}
+	}
+	return false
+}
+
+// Commit the contents on every replica.
+func (u *mirrorUpload) Commit(key string) (err error) {
+	lock := u.m.lock(key)
+	lock.Lock()
+	defer lock.Unlock()
+
+	committed := false
+	for i, upload := range u.uploads {
+		if nil == upload {
+			continue
+		}
+		if errX := upload.Commit(key); nil != errX {
+			log.Printf("Received error while committing %s to %v: %v\n", key, u.m.replicas[i], errX)
+			err = errX
+			continue
+		}
+		committed = true
+	}
+
+	// Succeed as long as one copy made it.
+	if committed {
+		err = nil
+	}
+	return
+}
+
+// Abort the upload on every replica.
+func (u *mirrorUpload) Abort() (err error) {
+	for _, upload := range u.uploads {
+		if nil == upload {
+			continue
+		}
+		if errX := upload.Abort(); nil != errX {
+			err = errX
+		}
+	}
+	return
+}
+
+// Get the first copy of the object found. Copies are only checked while
+// scrubbing, leaving reads as cheap as a single replica's.
+func (m *Mirror) Get(key string) (obj Object, err error) {
+	lock := m.lock(key)
+	lock.RLock()
+	defer lock.RUnlock()
+
+	missing := 0
+	for _, replica := range m.replicas {
+		var errX error
+		if obj, errX = replica.Get(key); nil == errX {
+			return
+		}
+		if ErrNotFound == errX {
+			missing++
+			continue
+		}
+		log.Printf("Received error while reading %s from %v: %v\n", key, replica, errX)
+		err = errX
+	}
+	if len(m.replicas) == missing {
+		err = ErrNotFound
+	}
+	return
+}
+
+// Scrub every copy of the object, rewriting copies that are missing, unreadable
+// or rejected by the healthy function from the first one it accepts. Commits and
+// deletes of the key wait meanwhile. Fails when no copy is healthy.
+func (m *Mirror) Scrub(key string, healthy func(obj Object) (bool, error)) (repaired int, err error) {
+	lock := m.lock(key)
+	lock.Lock()
+	defer lock.Unlock()
+
+	good, missing, damaged := -1, 0, []int{}
+	for i, replica := range m.replicas {
+		ok, errX := checkCopy(replica, key, healthy)
+		switch {
+		case ErrNotFound == errX:
+			log.Printf("Found %s missing from %v\n", key, replica)
+			missing++
+		case nil != errX:
+			log.Printf("Received error while reading %s from %v: %v\n", key, replica, errX)
+		case !ok:
+			log.Printf("Found %s corrupt on %v\n", key, replica)
+		case 0 > good:
+			good = i
+			continue
+		default:
+			continue
+		}
+		damaged = append(damaged, i)
+	}
+
+	switch {
+	case len(m.replicas) == missing:
+		err = ErrNotFound
+		return
+	case 0 > good:
+		err = fmt.Errorf("no healthy copy of %s in any replica", key)
+		return
+	}
+
+	// Rewrite the damaged copies from the healthy one.
+	for _, i := range damaged {
+		replica := m.replicas[i]
+		if errX := copyTo(m.replicas[good], replica, key); nil != errX {
+			log.Printf("Received error while repairing %s on %v: %v\n", key, replica, errX)
+			err = errX
+			continue
+		}
+		log.Printf("Repaired %s on %v\n", key, replica)
+		repaired++
+	}
+	return
+}
+
+// checkCopy of an object on a replica with the healthy function.
+func checkCopy(replica Backend, key string, healthy func(obj Object) (bool, error)) (ok bool, err error) {
+	var obj Object
+	if obj, err = replica.Get(key); nil != err {
+		return
+	}
+	defer obj.Close()
+	return healthy(obj)
+}
+
+// copyTo rewrites the object at key on a backend from another.
+func copyTo(from, to Backend, key string) (err error) {
+	var obj Object
+	if obj, err = from.Get(key); nil != err {
+		return
+	}
+	defer obj.Close()
+	return putAt(to, key, obj)
+}
+
+// Stat the first copy of the object found.
+func (m *Mirror) Stat(key string) (info *Info, err error) {
+	for _, replica := range m.replicas {
+		if info, err = replica.Stat(key); nil == err {
+			return
+		}
+	}
+	return
+}
+
+// String names the replicas.
+func (m *Mirror) String() string {
+	names := make([]string, len(m.replicas))
+	for i, replica := range m.replicas {
+		names[i] = fmt.Sprint(replica)
+	}
+	return "mirror of " + strings.Join(names, ", ")
+}
+
+// Delete the object from every replica.
+func (m *Mirror) Delete(key string) (err error) {
+	lock := m.lock(key)
+	lock.Lock()
+	defer lock.Unlock()
+
+	err = ErrNotFound
+	for _, replica := range m.replicas {
+		errX := replica.Delete(key)
+		switch {
+		case nil == errX:
+			if ErrNotFound == err {
+				err = nil
+			}
+		case ErrNotFound != errX:
+			log.Printf("Received error while deleting %s from %v: %v\n", key, replica, errX)
+			err = errX
+		}
+	}
+	return
+}
+
+// List every object found on any replica in key order.
+func (m *Mirror) List(prefix string, fn func(*Info) error) (err error) {
+	found := map[string]*Info{}
+	for _, replica := range m.replicas {
+		if err = replica.List(prefix, func(info *Info) error {
+			if _, seen := found[info.Key]; !seen {
+				found[info.Key] = info
+			}
+			return nil
+		}); nil != err {
+			return
+		}
+	}
+
+	keys := make([]string, 0, len(found))
+	for key := range found {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if err = fn(found[key]); nil != err {
+			return
+		}
+	}
+	return
+}
+
+// EmptyFolders on any replica, deepest first.
+func (m *Mirror) EmptyFolders() (folders []string, err error) {
+	found := map[string]bool{}
+	for _, replica := range m.replicas {
+		pruner, ok := replica.(Pruner)
+		if !ok {
+			continue
+		}
+		var empty []string
+		if empty, err = pruner.EmptyFolders(); nil != err {
+			return
+		}
+		for _, folder := range empty {
+			if !found[folder] {
+				found[folder] = true
+				folders = append(folders, folder)
+			}
+		}
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(folders)))
+	return
+}
+
+// Prune an empty folder from every replica.
+func (m *Mirror) Prune(folder string) (err error) {
+	for _, replica := range m.replicas {
+		if pruner, ok := replica.(Pruner); ok {
+			if errX := pruner.Prune(folder); nil != errX {
+				err = errX
+			}
+		}
+	}
+	return
+}
+
+// Clean every replica.
+func (m *Mirror) Clean() (err error) {
+	for _, replica := range m.replicas {
+		if errX := replica.Clean(); nil != errX {
+			err = errX
+		}
+	}
+	return
+}
+
+// putAt stores the contents at the key on a backend.
+func putAt(backend Backend, key string, r io.Reader) (err error) {
+	var upload Upload
+	if upload, err = backend.Put(r); nil != err {
+		return
+	}
+	return upload.Commit(key)
+}
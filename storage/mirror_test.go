@@ -0,0 +1,93 @@
+package storage
+
+import (
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+// TestMirror backend against the common behavior.
+func TestMirror(t *testing.T) {
+	first, second := NewMemory(), NewMemory()
+	testBackend(t, NewMirror(first, second))
+
+	// Every copy is removed along with the objects.
+	for _, replica := range []*Memory{first, second} {
+		if 0 != len(replica.objects) {
+			t.Errorf("Replica not empty after all deletes: %d objects\n", len(replica.objects))
+		}
+	}
+}
+
+// TestMirrorScrub repairs missing and corrupt copies from a healthy replica.
+func TestMirrorScrub(t *testing.T) {
+	first, second, third := NewMemory(), NewMemory(), NewMemory()
+	mirror := NewMirror(first, second, third)
+	const key, contents = "/a/b.txt", "mirrored contents"
+
+	// healthy copies hold the contents.
+	healthy := func(obj Object) (bool, error) {
+		raw, err := ioutil.ReadAll(obj)
+		return contents == string(raw), err
+	}
+
+	// read the object through the mirror.
+	read := func() (string, error) {
+		obj, err := mirror.Get(key)
+		if nil != err {
+			return "", err
+		}
+		defer obj.Close()
+		raw, err := ioutil.ReadAll(obj)
+		return string(raw), err
+	}
+
+	// raw contents of the object on a single replica.
+	raw := func(replica Backend) string {
+		obj, err := replica.Get(key)
+		if nil != err {
+			return err.Error()
+		}
+		defer obj.Close()
+		data, _ := ioutil.ReadAll(obj)
+		return string(data)
+	}
+
+	if err := putAt(mirror, key, strings.NewReader(contents)); nil != err {
+		t.Fatalf("Failed to put with: %v\n", err)
+	}
+
+	// Lose the first copy and corrupt the second. Reads carry on from the
+	// first copy found without checking it.
+	first.Delete(key)
+	putAt(second, key, strings.NewReader("corrupted contents"))
+	if got, err := read(); nil != err || "corrupted contents" != got {
+		t.Fatalf("Expected the second copy and got %q with: %v\n", got, err)
+	}
+
+	// Scrubbing rewrites both from the third.
+	if repaired, err := mirror.Scrub(key, healthy); nil != err || 2 != repaired {
+		t.Fatalf("Expected 2 copies to be repaired and got %d with: %v\n", repaired, err)
+	}
+	for i, replica := range []Backend{first, second, third} {
+		if got := raw(replica); contents != got {
+			t.Errorf("Replica %d not repaired. Holds %q\n", i, got)
+		}
+	}
+	if repaired, err := mirror.Scrub(key, healthy); nil != err || 0 != repaired {
+		t.Errorf("Expected nothing to repair and got %d with: %v\n", repaired, err)
+	}
+
+	// No healthy copies left is an error and nothing is rewritten.
+	for _, replica := range []Backend{first, second, third} {
+		putAt(replica, key, strings.NewReader("corrupted contents"))
+	}
+	if _, err := mirror.Scrub(key, healthy); nil == err || ErrNotFound == err {
+		t.Errorf("Expected error scrubbing without a healthy copy and got: %v\n", err)
+	}
+
+	// Objects missing everywhere aren't found.
+	if _, err := mirror.Scrub("/missing.txt", healthy); ErrNotFound != err {
+		t.Errorf("Expected %v scrubbing a missing object and got: %v\n", ErrNotFound, err)
+	}
+}
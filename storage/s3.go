@@ -0,0 +1,503 @@
+package storage
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// s3Algorithm is the only signing algorithm supported.
+	s3Algorithm = "AWS4-HMAC-SHA256"
+	// s3DateFormat is the format of the "X-Amz-Date" header.
+	s3DateFormat = "20060102T150405Z"
+	// s3SpoolPrefix names the folders in the system temporary folder holding
+	// uploads before they are sent, one for each endpoint and bucket.
+	s3SpoolPrefix = "example-s3-"
+	// s3EmptyHash is the SHA-256 of an empty payload.
+	s3EmptyHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+)
+
+// S3 backend stores objects in a bucket of an S3-compatible object store such
+// as MinIO or Ceph RGW. Requests use path-style addressing and are signed with
+// AWS Signature Version 4.
+type S3 struct {
+	endpoint  *url.URL
+	region    string
+	bucket    string
+	accessKey string
+	secretKey string
+	client    *http.Client
+
+	// spool folder holds uploads until committed.
+	spool string
+
+	// now is swapped out when testing against known signatures.
+	now func() time.Time
+}
+
+// NewS3 backend for the bucket at the endpoint (ex: "http://127.0.0.1:9000").
+func NewS3(endpoint, region, bucket, accessKey, secretKey string) (s3 *S3, err error) {
+	var u *url.URL
+	if u, err = url.Parse(endpoint); nil != err {
+		return
+	}
+	if "" == u.Scheme || "" == u.Host {
+		err = fmt.Errorf("s3 endpoint must be an absolute URL: %s", endpoint)
+		return
+	}
+	if "" == bucket {
+		err = errors.New("s3 bucket not assigned")
+		return
+	}
+
+	s3 = &S3{
+		endpoint:  u,
+		region:    region,
+		bucket:    bucket,
+		accessKey: accessKey,
+		secretKey: secretKey,
+		client:    &http.Client{},
+		spool:     filepath.Join(os.TempDir(), s3SpoolPrefix+url.PathEscape(u.Host+"-"+bucket)),
+		now:       time.Now,
+	}
+	return
+}
+
+// String names the bucket and endpoint, leaving out the credentials.
+func (s3 *S3) String() string {
+	return fmt.Sprintf("s3 bucket %s at %s", s3.bucket, s3.endpoint)
+}
+
+// Put the contents of the reader into the spool folder, since S3 requires the
+// length and hash up front. Nothing is sent to the bucket until committed.
+func (s3 *S3) Put(r io.Reader) (upload Upload, err error) {
+	if err = os.MkdirAll(s3.spool, 0700); nil != err {
+		return
+	}
+	var tmp *os.File
+	if tmp, err = ioutil.TempFile(s3.spool, "upload-"); nil != err {
+		return
+	}
+	defer func() {
+		if nil != err {
+			tmp.Close()
+			os.Remove(tmp.Name())
+		}
+	}()
+
+	// Spool while hashing.
+	hash := sha256.New()
+	var size int64
+	if size, err = io.Copy(io.MultiWriter(tmp, hash), r); nil != err {
+		return
+	}
+
+	upload = &s3Upload{
+		s3:   s3,
+		tmp:  tmp,
+		size: size,
+		hash: hex.EncodeToString(hash.Sum(nil)),
+	}
+	return
+}
+
+// s3Upload is a spooled object waiting to be sent to the bucket.
+type s3Upload struct {
+	s3   *S3
+	tmp  *os.File
+	size int64
+	hash string
+}
+
+// Commit by sending the spooled object to the bucket. S3 replaces objects
+// atomically, so readers never see a partial object.
+func (u *s3Upload) Commit(key string) (err error) {
+	defer u.Abort()
+	if _, err = u.tmp.Seek(0, io.SeekStart); nil != err {
+		return
+	}
+
+	var req *http.Request
+	if req, err = u.s3.request("PUT", key, nil, nil); nil != err {
+		return
+	}
+	req.ContentLength = u.size
+	req.Body = ioutil.NopCloser(u.tmp)
+	if 0 == u.size {
+		req.Body = http.NoBody // Otherwise sent chunked with an unknown length.
+	}
+
+	var resp *http.Response
+	if resp, err = u.s3.do(req, u.hash); nil != err {
+		return
+	}
+	resp.Body.Close()
+	return
+}
+
+// Abort by removing the spooled file.
+func (u *s3Upload) Abort() error {
+	u.tmp.Close()
+	return os.Remove(u.tmp.Name())
+}
+
+// Get the object in the bucket. Seeking is served with ranged requests.
+func (s3 *S3) Get(key string) (obj Object, err error) {
+	var req *http.Request
+	if req, err = s3.request("GET", key, nil, nil); nil != err {
+		return
+	}
+
+	var resp *http.Response
+	if resp, err = s3.do(req, s3EmptyHash); nil != err {
+		return
+	}
+	obj = &s3Object{s3: s3, key: key, size: resp.ContentLength, body: resp.Body}
+
+	// Chunked responses leave the size unknown, which seeking from the end
+	// needs.
+	if 0 > resp.ContentLength {
+		var info *Info
+		if info, err = s3.Stat(key); nil != err {
+			resp.Body.Close()
+			obj = nil
+			return
+		}
+		obj.(*s3Object).size = info.Size
+	}
+	return
+}
+
+// s3Object streams an object from the bucket, starting a new ranged request
+// whenever a read follows a seek.
+type s3Object struct {
+	s3         *S3
+	key        string
+	size       int64
+	offset     int64
+	body       io.ReadCloser
+	bodyOffset int64
+}
+
+// Read from the current offset.
+func (o *s3Object) Read(p []byte) (n int, err error) {
+	if o.offset >= o.size {
+		return 0, io.EOF
+	}
+
+	// Drop the open response if a seek moved away from it.
+	if nil != o.body && o.bodyOffset != o.offset {
+		o.body.Close()
+		o.body = nil
+	}
+
+	// Request the remainder of the object from the current offset.
+	if nil == o.body {
+		var req *http.Request
+		if req, err = o.s3.request("GET", o.key, nil, nil); nil != err {
+			return
+		}
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", o.offset))
+
+		var resp *http.Response
+		if resp, err = o.s3.do(req, s3EmptyHash); nil != err {
+			return
+		}
+		o.body, o.bodyOffset = resp.Body, o.offset
+	}
+
+	n, err = o.body.Read(p)
+	o.offset += int64(n)
+	o.bodyOffset += int64(n)
+	return
+}
+
+// Seek to a new offset. Nothing is requested until the next read.
+func (o *s3Object) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += o.offset
+	case io.SeekEnd:
+		offset += o.size
+	default:
+		return o.offset, fmt.Errorf("invalid whence: %d", whence)
+	}
+	if 0 > offset {
+		return o.offset, errors.New("negative position")
+	}
+	o.offset = offset
+	return o.offset, nil
+}
+
+// Close any open response.
+func (o *s3Object) Close() (err error) {
+	if nil != o.body {
+		err = o.body.Close()
+		o.body = nil
+	}
+	return
+}
+
+// Stat the object in the bucket.
+func (s3 *S3) Stat(key string) (info *Info, err error) {
+	var req *http.Request
+	if req, err = s3.request("HEAD", key, nil, nil); nil != err {
+		return
+	}
+
+	var resp *http.Response
+	if resp, err = s3.do(req, s3EmptyHash); nil != err {
+		return
+	}
+	resp.Body.Close()
+
+	info = &Info{Key: key, Size: resp.ContentLength}
+	if modTime, errX := http.ParseTime(resp.Header.Get("Last-Modified")); nil == errX {
+		info.ModTime = modTime
+	}
+	return
+}
+
+// Delete the object from the bucket. S3 reports success when deleting missing
+// objects, so existence is checked first.
+func (s3 *S3) Delete(key string) (err error) {
+	if _, err = s3.Stat(key); nil != err {
+		return
+	}
+
+	var req *http.Request
+	if req, err = s3.request("DELETE", key, nil, nil); nil != err {
+		return
+	}
+
+	var resp *http.Response
+	if resp, err = s3.do(req, s3EmptyHash); nil != err {
+		return
+	}
+	resp.Body.Close()
+	return
+}
+
+// s3ListResult is the subset of a ListObjectsV2 response that is used.
+type s3ListResult struct {
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+	Contents              []struct {
+		Key          string    `xml:"Key"`
+		Size         int64     `xml:"Size"`
+		LastModified time.Time `xml:"LastModified"`
+	} `xml:"Contents"`
+}
+
+// List all objects in the bucket with keys beginning with the prefix, following
+// continuation tokens until the listing is complete.
+func (s3 *S3) List(prefix string, fn func(*Info) error) (err error) {
+	token := ""
+	for {
+		query := url.Values{}
+		query.Set("list-type", "2")
+		query.Set("prefix", strings.TrimPrefix(prefix, "/"))
+		if "" != token {
+			query.Set("continuation-token", token)
+		}
+
+		var req *http.Request
+		if req, err = s3.request("GET", "", query, nil); nil != err {
+			return
+		}
+
+		var resp *http.Response
+		if resp, err = s3.do(req, s3EmptyHash); nil != err {
+			return
+		}
+		result := &s3ListResult{}
+		err = xml.NewDecoder(resp.Body).Decode(result)
+		resp.Body.Close()
+		if nil != err {
+			return
+		}
+
+		for _, obj := range result.Contents {
+			info := &Info{Key: "/" + obj.Key, Size: obj.Size, ModTime: obj.LastModified}
+			if err = fn(info); nil != err {
+				return
+			}
+		}
+
+		if !result.IsTruncated || "" == result.NextContinuationToken {
+			return
+		}
+		token = result.NextContinuationToken
+	}
+}
+
+// request for the object at the key. An empty key addresses the bucket.
+func (s3 *S3) request(method, key string, query url.Values, body io.Reader) (req *http.Request, err error) {
+	u := *s3.endpoint
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/" + s3.bucket
+	if "" != key {
+		u.Path += "/" + strings.TrimPrefix(key, "/")
+	}
+	u.RawPath = uriEncode(u.Path, false)
+	u.RawQuery = canonicalQuery(query)
+
+	if req, err = http.NewRequest(method, u.String(), body); nil != err {
+		return
+	}
+	req.URL = &u
+	return
+}
+
+// do signs and performs the request, converting S3 error responses into errors.
+func (s3 *S3) do(req *http.Request, payloadHash string) (resp *http.Response, err error) {
+	s3.sign(req, payloadHash)
+	if resp, err = s3.client.Do(req); nil != err {
+		return
+	}
+	if 300 > resp.StatusCode {
+		return
+	}
+
+	// Failure. Consume the error document and close.
+	defer resp.Body.Close()
+	if http.StatusNotFound == resp.StatusCode {
+		resp, err = nil, ErrNotFound
+		return
+	}
+	doc := &struct {
+		Code    string `xml:"Code"`
+		Message string `xml:"Message"`
+	}{}
+	if errX := xml.NewDecoder(resp.Body).Decode(doc); nil != errX || "" == doc.Code {
+		doc.Code = resp.Status
+	}
+	err = fmt.Errorf("s3 %s %s failed: %s %s", req.Method, req.URL.Path, doc.Code, doc.Message)
+	resp = nil
+	return
+}
+
+// sign the request with AWS Signature Version 4. Every header already present on
+// the request is signed along with the host.
+func (s3 *S3) sign(req *http.Request, payloadHash string) {
+	now := s3.now().UTC()
+	req.Header.Set("X-Amz-Date", now.Format(s3DateFormat))
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	signed := []string{"host"}
+	for key := range req.Header {
+		if lower := strings.ToLower(key); "authorization" != lower {
+			signed = append(signed, lower)
+		}
+	}
+	sort.Strings(signed)
+
+	scope := strings.Join([]string{now.Format("20060102"), s3.region, "s3", "aws4_request"}, "/")
+	signature := s3Signature(req, signed, payloadHash, scope, s3.secretKey)
+	req.Header.Set("Authorization", fmt.Sprintf(
+		"%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s3Algorithm,
+		s3.accessKey,
+		scope,
+		strings.Join(signed, ";"),
+		signature,
+	))
+}
+
+// s3Signature of a request. Shared by the client and by anything verifying
+// requests so both sides canonicalize identically.
+func s3Signature(req *http.Request, signed []string, payloadHash, scope, secretKey string) string {
+	// Canonical headers, with the host taken from wherever the request has it.
+	headers := make([]string, 0, len(signed))
+	for _, name := range signed {
+		value := req.Header.Get(name)
+		if "host" == name {
+			if value = req.Host; "" == value {
+				value = req.URL.Host
+			}
+		}
+		headers = append(headers, name+":"+strings.TrimSpace(value)+"\n")
+	}
+
+	canonical := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		canonicalQuery(req.URL.Query()),
+		strings.Join(headers, ""),
+		strings.Join(signed, ";"),
+		payloadHash,
+	}, "\n")
+
+	canonicalHash := sha256.Sum256([]byte(canonical))
+	toSign := strings.Join([]string{
+		s3Algorithm,
+		req.Header.Get("X-Amz-Date"),
+		scope,
+		hex.EncodeToString(canonicalHash[:]),
+	}, "\n")
+
+	// Derive the signing key from the date, region and service in the scope.
+	key := []byte("AWS4" + secretKey)
+	for _, part := range strings.Split(scope, "/") {
+		key = hmacSHA256(key, part)
+	}
+	return hex.EncodeToString(hmacSHA256(key, toSign))
+}
+
+// hmacSHA256 of the data using the key.
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// canonicalQuery sorts and strictly encodes query values.
+func canonicalQuery(query url.Values) string {
+	pairs := []string{}
+	for key, values := range query {
+		for _, value := range values {
+			pairs = append(pairs, uriEncode(key, true)+"="+uriEncode(value, true))
+		}
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, "&")
+}
+
+// uriEncode percent-encodes everything except unreserved characters. Slashes are
+// kept unless encodeSlash is set.
+func uriEncode(s string, encodeSlash bool) string {
+	const hexDigits = "0123456789ABCDEF"
+	out := make([]byte, 0, len(s))
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			'-' == c, '_' == c, '.' == c, '~' == c:
+			out = append(out, c)
+		case '/' == c && !encodeSlash:
+			out = append(out, c)
+		default:
+			out = append(out, '%', hexDigits[c>>4], hexDigits[c&15])
+		}
+	}
+	return string(out)
+}
+
+// Clean the spool folder of uploads that were never committed or aborted,
+// which happens when the process exits mid-upload. Only call before serving.
+func (s3 *S3) Clean() error {
+	return os.RemoveAll(s3.spool)
+}
@@ -1,86 +1,139 @@
 package storage
 
 import (
+	"errors"
+	"fmt"
 	"io"
-	"os"
 	"path"
+	"strings"
+	"time"
 
 	"github.com/halverneus/example/config"
 )
 
-// Delete file from storage.
-func Delete(filePath string) (err error) {
-	fullpath := path.Join(config.Get.Storage.Folder, filePath)
+var (
+	// ErrNotFound is returned when an object does not exist in a backend.
+	ErrNotFound = errors.New("object not found in storage")
+)
 
-	// Remove file.
-	if err = os.Remove(fullpath); nil != err {
-		return
-	}
+// Backend is implemented by anything capable of holding object contents. Keys
+// are the slash-separated object paths used throughout the application.
+type Backend interface {
+	// Put stages the contents of the reader. Nothing is visible until the
+	// returned upload is committed at a key.
+	Put(r io.Reader) (Upload, error)
 
-	// Remove empty parent directories.
-	dir := path.Dir(fullpath)
-	for isDirEmpty(dir) {
-		if err = os.Remove(dir); nil != err {
-			return nil // Not error. Folder removal complete.
-		}
-		dir = path.Clean(dir + "/..") // Move up one directory.
-	}
-	return
+	// Get the object at the key for reading. Caller must close the object.
+	Get(key string) (Object, error)
+
+	// Stat returns information about the object at the key.
+	Stat(key string) (*Info, error)
+
+	// Delete the object at the key.
+	Delete(key string) error
+
+	// List calls the function for every object with a key starting with prefix.
+	List(prefix string, fn func(*Info) error) error
+
+	// Clean removes staged uploads abandoned by a previous run.
+	Clean() error
 }
 
-// isDirEmpty returns true when a provided directory is empty.
-func isDirEmpty(dirPath string) bool {
-	// Open directory for evaluation.
-	dir, err := os.Open(dirPath)
-	if nil != err {
-		return false
-	}
-	defer dir.Close()
+// Upload of staged contents awaiting a decision. Exactly one of Commit or Abort
+// must be called.
+type Upload interface {
+	// Commit the staged contents at the key, replacing any existing object.
+	Commit(key string) error
 
-	// Request to read one FileInfo from directory. If none found then directory
-	// is empty.
-	_, err = dir.Readdir(1)
-	return io.EOF == err
+	// Abort the upload, discarding the staged contents.
+	Abort() error
 }
 
-// Download a file from storage to the client.
-func Download(filePath string, w io.Writer) (err error) {
-	fullpath := path.Join(config.Get.Storage.Folder, filePath)
+// Object contents being read. Seeking allows serving byte ranges.
+type Object interface {
+	io.ReadSeeker
+	io.Closer
+}
 
-	// Open file for reading.
-	var file *os.File
-	if file, err = os.OpenFile(fullpath, os.O_RDONLY, 0755); nil != err {
-		return
-	}
-	defer file.Close()
+// Pruner is implemented by backends keeping objects in folders, which are left
+// behind empty when something goes wrong.
+type Pruner interface {
+	// EmptyFolders holding no objects at any depth, deepest first.
+	EmptyFolders() ([]string, error)
 
-	// Send contents to client.
-	_, err = io.Copy(w, file)
-	return
+	// Prune an empty folder.
+	Prune(folder string) error
 }
 
-// Upload a file from the client to storage.
-func Upload(filePath string, r io.Reader) (err error) {
-	fullpath := path.Join(config.Get.Storage.Folder, filePath)
-	dir := path.Dir(fullpath)
+// Scrubber is implemented by backends keeping several copies of each object,
+// which can be damaged one at a time.
+type Scrubber interface {
+	// Scrub every copy of the object, rewriting copies that are missing or that
+	// the healthy function rejects from the first one it accepts. Returns the
+	// number of copies rewritten.
+	Scrub(key string, healthy func(obj Object) (bool, error)) (repaired int, err error)
+}
 
-	// Create all parent directories.
-	if err = os.MkdirAll(dir, 0755); nil != err {
-		return
-	}
+// Info about a stored object.
+type Info struct {
+	Key     string
+	Size    int64
+	ModTime time.Time
+}
 
-	// Open file for writing.
-	var file *os.File
-	if file, err = os.OpenFile(
-		fullpath,
-		os.O_RDWR|os.O_CREATE|os.O_TRUNC,
-		0755,
-	); nil != err {
-		return
+// Reserved returns true when the key falls inside a folder that backends use
+// for their own purposes.
+func Reserved(key string) bool {
+	switch topFolder(key) {
+	case stagingFolder:
+		return true
 	}
-	defer file.Close()
+	return false
+}
+
+// topFolder of a key, which is the first element of its path.
+func topFolder(key string) string {
+	return strings.SplitN(strings.TrimPrefix(path.Clean("/"+key), "/"), "/", 2)[0]
+}
+
+// Open the backend selected by the "storage.driver" configuration value.
+func Open() (backend Backend, err error) {
+	switch config.Get.Storage.Driver {
+
+	case "", "filesystem":
+		backend = NewFilesystem(config.Get.Storage.Folder)
+
+		// Mirror across several folders when listed.
+		if folders := config.Get.Storage.Folders; 0 < len(folders) {
+			replicas := make([]Backend, len(folders))
+			for i, folder := range folders {
+				replicas[i] = NewFilesystem(folder)
+			}
+			backend = NewMirror(replicas...)
+		}
+
+	case "memory":
+		backend = NewMemory()
 
-	// Write to file.
-	_, err = io.Copy(file, r)
+	case "s3":
+		s3 := config.Get.Storage.S3
+		backend, err = NewS3(s3.Endpoint, s3.Region, s3.Bucket, s3.AccessKey, s3.SecretKey)
+
+	default:
+		err = fmt.Errorf("unknown storage driver: %s", config.Get.Storage.Driver)
+	}
 	return
 }
+
+// OpenCold backend of the "tiering.cold_folder" configuration value, which is
+// nil when tiering is disabled. The "memory" driver keeps the cold tier in
+// memory as well.
+func OpenCold() Backend {
+	switch {
+	case "" == config.Get.Tiering.ColdFolder:
+		return nil
+	case "memory" == config.Get.Storage.Driver:
+		return NewMemory()
+	}
+	return NewFilesystem(config.Get.Tiering.ColdFolder)
+}
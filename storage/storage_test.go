@@ -0,0 +1,173 @@
+package storage
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+const (
+	testFolder = "teststorage"
+)
+
+// TestFilesystem backend against the common behavior.
+func TestFilesystem(t *testing.T) {
+	defer os.RemoveAll(testFolder)
+	testBackend(t, NewFilesystem(testFolder))
+
+	// Parent directories are cleaned up, but the storage folder is kept.
+	if _, err := os.Stat(testFolder); nil != err {
+		t.Errorf("Storage folder removed with: %v\n", err)
+	}
+	if !isDirEmpty(testFolder) {
+		t.Error("Storage folder not empty after all deletes")
+	}
+
+	// Folders without files are found deepest first and pruned.
+	fs := NewFilesystem(testFolder)
+	os.MkdirAll(testFolder+"/a/b/c", 0755)
+	os.MkdirAll(testFolder+"/d", 0755)
+	putAt(fs, "/d/kept.txt", bytes.NewReader([]byte("kept")))
+	folders, err := fs.EmptyFolders()
+	if nil != err {
+		t.Fatalf("Failed to find empty folders with: %v\n", err)
+	}
+	if expected := "[/a/b/c /a/b /a]"; expected != fmt.Sprint(folders) {
+		t.Errorf("Expected empty folders %s and got %v\n", expected, folders)
+	}
+	for _, folder := range folders {
+		if err = fs.Prune(folder); nil != err {
+			t.Errorf("Failed to prune %s with: %v\n", folder, err)
+		}
+	}
+	if folders, _ = fs.EmptyFolders(); 0 != len(folders) {
+		t.Errorf("Expected no empty folders after pruning and got %v\n", folders)
+	}
+	if err = fs.Prune("/d"); nil == err {
+		t.Error("Expected error pruning a folder with files")
+	}
+}
+
+// TestMemory backend against the common behavior.
+func TestMemory(t *testing.T) {
+	testBackend(t, NewMemory())
+}
+
+// testBackend runs through put, get, stat, list and delete on a backend. Every
+// object is removed by the end.
+func testBackend(t *testing.T, backend Backend) {
+	objects := map[string]string{
+		"/a/b/one.txt": "first object",
+		"/a/two.txt":   "second object",
+		"/three.txt":   "",
+	}
+
+	// put and commit contents at a key.
+	put := func(key, contents string) {
+		upload, err := backend.Put(bytes.NewReader([]byte(contents)))
+		if nil != err {
+			t.Fatalf("Failed to put %s with: %v\n", key, err)
+		}
+		if err = upload.Commit(key); nil != err {
+			t.Fatalf("Failed to commit %s with: %v\n", key, err)
+		}
+	}
+
+	// Put all objects, overwriting each to confirm replacement.
+	for key, contents := range objects {
+		put(key, "stale")
+		put(key, contents)
+	}
+
+	// Staged uploads are invisible until committed and gone once aborted.
+	upload, err := backend.Put(bytes.NewReader([]byte("aborted")))
+	if nil != err {
+		t.Fatalf("Failed to stage upload with: %v\n", err)
+	}
+	if info, err := backend.Stat("/a/two.txt"); nil != err || int64(len(objects["/a/two.txt"])) != info.Size {
+		t.Errorf("Staged upload visible before commit: %v %v\n", info, err)
+	}
+	if err = upload.Abort(); nil != err {
+		t.Errorf("Failed to abort upload with: %v\n", err)
+	}
+	if err = backend.Clean(); nil != err {
+		t.Errorf("Failed to clean with: %v\n", err)
+	}
+
+	// Read each object back.
+	for key, contents := range objects {
+		rc, err := backend.Get(key)
+		if nil != err {
+			t.Fatalf("Failed to get %s with: %v\n", key, err)
+		}
+		raw, err := ioutil.ReadAll(rc)
+		rc.Close()
+		if nil != err {
+			t.Fatalf("Failed to read %s with: %v\n", key, err)
+		}
+		if contents != string(raw) {
+			t.Errorf("Contents mismatch for %s. Expected %q and got %q\n", key, contents, raw)
+		}
+
+		info, err := backend.Stat(key)
+		if nil != err {
+			t.Fatalf("Failed to stat %s with: %v\n", key, err)
+		}
+		if int64(len(contents)) != info.Size {
+			t.Errorf("Size mismatch for %s. Expected %d and got %d\n", key, len(contents), info.Size)
+		}
+	}
+
+	// Seek within an object and read the remainder.
+	obj, err := backend.Get("/a/two.txt")
+	if nil != err {
+		t.Fatalf("Failed to get for seeking with: %v\n", err)
+	}
+	if size, err := obj.Seek(0, io.SeekEnd); nil != err || int64(len(objects["/a/two.txt"])) != size {
+		t.Errorf("Seek to end returned %d with: %v\n", size, err)
+	}
+	if _, err = obj.Seek(7, io.SeekStart); nil != err {
+		t.Fatalf("Failed to seek with: %v\n", err)
+	}
+	raw, err := ioutil.ReadAll(obj)
+	obj.Close()
+	if nil != err || "object" != string(raw) {
+		t.Errorf("Read %q after seeking with: %v\n", raw, err)
+	}
+
+	// List by prefix.
+	found := map[string]bool{}
+	if err := backend.List("/a/", func(info *Info) error {
+		found[info.Key] = true
+		return nil
+	}); nil != err {
+		t.Fatalf("Failed to list with: %v\n", err)
+	}
+	if 2 != len(found) || !found["/a/b/one.txt"] || !found["/a/two.txt"] {
+		t.Errorf("Unexpected listing: %v\n", found)
+	}
+
+	// Missing objects report ErrNotFound.
+	if _, err := backend.Get("/missing"); ErrNotFound != err {
+		t.Errorf("Expected ErrNotFound on get and got: %v\n", err)
+	}
+	if _, err := backend.Stat("/missing"); ErrNotFound != err {
+		t.Errorf("Expected ErrNotFound on stat and got: %v\n", err)
+	}
+	if err := backend.Delete("/missing"); ErrNotFound != err {
+		t.Errorf("Expected ErrNotFound on delete and got: %v\n", err)
+	}
+
+	// Delete everything.
+	for key := range objects {
+		if err := backend.Delete(key); nil != err {
+			t.Fatalf("Failed to delete %s with: %v\n", key, err)
+		}
+		if _, err := backend.Get(key); ErrNotFound != err {
+			t.Errorf("Expected %s to be deleted and got: %v\n", key, err)
+		}
+	}
+}